@@ -1,16 +1,80 @@
 document.addEventListener('DOMContentLoaded', async () => {
-    const token = localStorage.getItem('token');
+    if (await openShareLink()) {
+        return;
+    }
+    await completeSSOLogin();
+    await handleEmailLinks();
 
-    if (token) {
-        document.getElementById('auth-section').style.display = 'none';
-        document.getElementById('video-section').style.display = 'block';
+    // The session cookies themselves can't be read, but the CSRF cookie is
+    // set alongside them.
+    if (csrfToken() && await refreshSession()) {
+        showVideoSection();
         await getVideos();
     } else {
-        document.getElementById('auth-section').style.display = 'block';
-        document.getElementById('video-section').style.display = 'none';
+        showAuthSection();
     }
 });
 
+function showVideoSection() {
+    document.getElementById('auth-section').style.display = 'none';
+    document.getElementById('video-section').style.display = 'block';
+}
+
+function showAuthSection() {
+    document.getElementById('auth-section').style.display = 'block';
+    document.getElementById('video-section').style.display = 'none';
+}
+
+// The session lives in HttpOnly cookies, out of reach of scripts. Requests
+// that change something must echo the CSRF cookie in a header.
+function csrfToken() {
+    const match = document.cookie.match(/(?:^|;\s*)tubely_csrf=([^;]*)/);
+    return match ? decodeURIComponent(match[1]) : '';
+}
+
+// apiFetch sends an authenticated request. Access tokens are short-lived,
+// so on a 401 the session is refreshed once and the request retried.
+async function apiFetch(url, options = {}) {
+    const send = () => {
+        const headers = {...options.headers};
+        if (options.method && options.method !== 'GET') {
+            headers['X-CSRF-Token'] = csrfToken();
+        }
+        return fetch(url, {...options, headers, credentials: 'same-origin'});
+    };
+
+    let res = await send();
+    if (res.status === 401 && await refreshSession()) {
+        res = await send();
+    }
+    if (res.status === 401) {
+        showAuthSection();
+    }
+    return res;
+}
+
+// Refresh tokens are single use, so concurrent requests that all got a 401
+// share one refresh instead of racing each other.
+let refreshing = null;
+
+function refreshSession() {
+    if (!refreshing) {
+        refreshing = fetch('/api/refresh', {
+            method: 'POST',
+            headers: {
+                'X-CSRF-Token': csrfToken(),
+            },
+            credentials: 'same-origin',
+        })
+            .then((res) => res.ok)
+            .catch(() => false)
+            .finally(() => {
+                refreshing = null;
+            });
+    }
+    return refreshing;
+}
+
 document.getElementById('video-draft-form').addEventListener('submit', async (event) => {
     event.preventDefault();
     await createVideoDraft();
@@ -24,15 +88,15 @@ document.getElementById('login-form').addEventListener('submit', async (event) =
 async function createVideoDraft() {
     const title = document.getElementById('video-title').value;
     const description = document.getElementById('video-description').value;
+    const visibility = document.getElementById('video-visibility').value;
 
     try {
-        const res = await fetch('/api/videos', {
+        const res = await apiFetch('/api/videos', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
-                Authorization: `Bearer ${localStorage.getItem('token')}`,
             },
-            body: JSON.stringify({title, description}),
+            body: JSON.stringify({title, description, visibility}),
         });
         const data = await res.json();
         if (!res.ok) {
@@ -54,22 +118,24 @@ async function login() {
     const password = document.getElementById('password').value;
 
     try {
-        const res = await fetch('/api/login', {
+        const res = await fetch('/api/login?session=cookie', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
             },
             body: JSON.stringify({email, password}),
         });
-        const data = await res.json();
+        let data = await res.json();
         if (!res.ok) {
             throw new Error(`Failed to login: ${data.error}`);
         }
 
-        if (data.token) {
-            localStorage.setItem('token', data.token);
-            document.getElementById('auth-section').style.display = 'none';
-            document.getElementById('video-section').style.display = 'block';
+        if (data.mfa_required) {
+            data = await loginMFA(data.mfa_token);
+        }
+
+        if (data.csrf_token) {
+            showVideoSection();
             await getVideos();
         } else {
             alert('Login failed. Please check your credentials.');
@@ -79,6 +145,191 @@ async function login() {
     }
 }
 
+async function loginMFA(mfaToken) {
+    const code = prompt('Enter the code from your authenticator app, or a recovery code');
+    if (!code) {
+        throw new Error('Login cancelled');
+    }
+
+    const res = await fetch('/api/login/mfa?session=cookie', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({mfa_token: mfaToken, code}),
+    });
+    const data = await res.json();
+    if (!res.ok) {
+        throw new Error(`Failed to login: ${data.error}`);
+    }
+    return data;
+}
+
+function loginWithSSO() {
+    window.location.href = '/api/oidc/login';
+}
+
+// The identity provider redirects back to this page with a code and state
+// in the query string, which the server exchanges for our own tokens.
+async function completeSSOLogin() {
+    const params = new URLSearchParams(window.location.search);
+    const code = params.get('code');
+    const state = params.get('state');
+    const error = params.get('error');
+    if (!code && !error) {
+        return;
+    }
+    window.history.replaceState(null, '', window.location.pathname);
+
+    try {
+        if (error) {
+            throw new Error(`SSO login failed: ${error}`);
+        }
+        const res = await fetch('/api/oidc/callback?session=cookie', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({code, state}),
+        });
+        const data = await res.json();
+        if (!res.ok) {
+            throw new Error(`Failed to login: ${data.error}`);
+        }
+
+        if (data.mfa_required) {
+            await loginMFA(data.mfa_token);
+        }
+    } catch (error) {
+        alert(`Error: ${error.message}`);
+    }
+}
+
+// Links in verification and password reset emails point back to this page
+// with their token in the query string.
+async function handleEmailLinks() {
+    const params = new URLSearchParams(window.location.search);
+    const verifyToken = params.get('verify_email');
+    const resetToken = params.get('reset_password');
+    if (!verifyToken && !resetToken) {
+        return;
+    }
+    window.history.replaceState(null, '', window.location.pathname);
+
+    try {
+        if (verifyToken) {
+            const res = await fetch('/api/users/verify_email', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                },
+                body: JSON.stringify({token: verifyToken}),
+            });
+            if (!res.ok) {
+                const data = await res.json();
+                throw new Error(`Failed to verify email: ${data.error}`);
+            }
+            alert('Your email is verified.');
+            return;
+        }
+
+        const password = prompt('Choose a new password');
+        if (!password) {
+            return;
+        }
+        const res = await fetch('/api/password_reset/confirm', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({token: resetToken, password}),
+        });
+        if (!res.ok) {
+            const data = await res.json();
+            throw new Error(`Failed to reset password: ${data.error}`);
+        }
+        alert('Your password has been changed. Please log in.');
+    } catch (error) {
+        alert(`Error: ${error.message}`);
+    }
+}
+
+// Share links point back to this page with their token in the query
+// string. They work without logging in, so only the video is shown.
+async function openShareLink() {
+    const params = new URLSearchParams(window.location.search);
+    const token = params.get('share');
+    if (!token) {
+        return false;
+    }
+
+    try {
+        let password = '';
+        let res;
+        for (;;) {
+            res = await fetch('/api/share', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                },
+                body: JSON.stringify({token, password}),
+            });
+            if (res.status !== 401) {
+                break;
+            }
+            const data = await res.json();
+            password = prompt(data.error === 'Password required' ? 'This video is protected. Enter the password' : 'Incorrect password, try again');
+            if (!password) {
+                return true;
+            }
+        }
+
+        const data = await res.json();
+        if (!res.ok) {
+            throw new Error(`Failed to open share link: ${data.error}`);
+        }
+        document.getElementById('auth-section').style.display = 'none';
+        document.getElementById('video-section').style.display = 'none';
+        document.getElementById('shared-video-section').style.display = 'block';
+        document.getElementById('shared-video-title').textContent = data.video.title;
+        document.getElementById('shared-video-description').textContent = data.video.description;
+        const player = document.getElementById('shared-video-player');
+        if (data.video.video_url) {
+            player.src = data.video.video_url;
+        } else {
+            player.style.display = 'none';
+        }
+    } catch (error) {
+        alert(`Error: ${error.message}`);
+    }
+    return true;
+}
+
+async function forgotPassword() {
+    const email = document.getElementById('email').value;
+    if (!email) {
+        alert('Enter your email first.');
+        return;
+    }
+
+    try {
+        const res = await fetch('/api/password_reset', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({email}),
+        });
+        if (!res.ok) {
+            const data = await res.json();
+            throw new Error(`Failed to request password reset: ${data.error}`);
+        }
+        alert('If that email has an account, a reset link is on its way.');
+    } catch (error) {
+        alert(`Error: ${error.message}`);
+    }
+}
+
 async function signup() {
     const email = document.getElementById('email').value;
     const password = document.getElementById('password').value;
@@ -102,10 +353,19 @@ async function signup() {
     }
 }
 
-function logout() {
-    localStorage.removeItem('token');
-    document.getElementById('auth-section').style.display = 'block';
-    document.getElementById('video-section').style.display = 'none';
+async function logout() {
+    try {
+        await fetch('/api/revoke', {
+            method: 'POST',
+            headers: {
+                'X-CSRF-Token': csrfToken(),
+            },
+            credentials: 'same-origin',
+        });
+    } catch (error) {
+        console.error(error);
+    }
+    showAuthSection();
 }
 
 function setUploadButtonState(uploading, selector) {
@@ -130,11 +390,8 @@ async function uploadThumbnail(videoID) {
     setUploadButtonState(true, uploadBtnSelector);
 
     try {
-        const res = await fetch(`/api/thumbnail_upload/${videoID}`, {
+        const res = await apiFetch(`/api/thumbnail_upload/${videoID}`, {
             method: 'POST',
-            headers: {
-                Authorization: `Bearer ${localStorage.getItem('token')}`,
-            },
             body: formData,
         });
         if (!res.ok) {
@@ -163,11 +420,8 @@ async function uploadVideoFile(videoID) {
     setUploadButtonState(true, uploadBtnSelector);
 
     try {
-        const res = await fetch(`/api/video_upload/${videoID}`, {
+        const res = await apiFetch(`/api/video_upload/${videoID}`, {
             method: 'POST',
-            headers: {
-                Authorization: `Bearer ${localStorage.getItem('token')}`,
-            },
             body: formData,
         });
         if (!res.ok) {
@@ -188,11 +442,8 @@ const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
     try {
-        const res = await fetch('/api/videos', {
+        const res = await apiFetch('/api/videos', {
             method: 'GET',
-            headers: {
-                Authorization: `Bearer ${localStorage.getItem('token')}`,
-            },
         });
         if (!res.ok) {
             const data = await res.json();
@@ -231,11 +482,8 @@ function createVideoStateHandler() {
 
 async function getVideo(videoID) {
     try {
-        const res = await fetch(`/api/videos/${videoID}`, {
+        const res = await apiFetch(`/api/videos/${videoID}`, {
             method: 'GET',
-            headers: {
-                Authorization: `Bearer ${localStorage.getItem('token')}`,
-            },
         });
         if (!res.ok) {
             throw new Error('Failed to get video.');
@@ -284,11 +532,8 @@ async function deleteVideo() {
     }
 
     try {
-        const res = await fetch(`/api/videos/${currentVideo.id}`, {
+        const res = await apiFetch(`/api/videos/${currentVideo.id}`, {
             method: 'DELETE',
-            headers: {
-                Authorization: `Bearer ${localStorage.getItem('token')}`,
-            },
         });
         if (!res.ok) {
             throw new Error('Failed to delete video.');
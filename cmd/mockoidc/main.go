@@ -0,0 +1,30 @@
+// Command mockoidc runs a mock OpenID provider for trying out SSO login
+// locally. Anyone can sign in as any email, so never expose it.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/andycostintoma/tubely/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":8092", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:8092", "issuer URL, must match how tubely reaches the provider")
+	clientID := flag.String("client-id", "tubely", "client ID tubely is registered with")
+	clientSecret := flag.String("client-secret", "", "client secret, empty to rely on PKCE alone")
+	flag.Parse()
+
+	provider, err := oidctest.New(*issuer, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Mock OpenID provider %v listening on %v", *issuer, *addr)
+	err = http.ListenAndServe(*addr, provider)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,33 @@
+// Command webhookreceiver runs a webhook endpoint that verifies and prints
+// the deliveries it gets, for trying out webhooks locally.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/andycostintoma/tubely/internal/webhook/webhooktest"
+)
+
+func main() {
+	addr := flag.String("addr", ":8093", "address to listen on")
+	secret := flag.String("secret", "", "signing secret returned when the webhook was created")
+	failFirst := flag.Int("fail-first", 0, "number of deliveries to fail with a 500 before accepting any")
+	flag.Parse()
+	if *secret == "" {
+		log.Fatal("-secret is required")
+	}
+
+	receiver := webhooktest.New(*secret)
+	receiver.FailNext(*failFirst)
+	receiver.OnDelivery(func(d webhooktest.Delivery) {
+		log.Printf("Delivery %s (%s): %s", d.ID, d.Event, d.Body)
+	})
+
+	log.Printf("Webhook receiver listening on %v", *addr)
+	err := http.ListenAndServe(*addr, receiver)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
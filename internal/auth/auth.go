@@ -18,8 +18,13 @@ type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "tubely-access"
+	// TokenTypeMFA tokens prove the password was checked and are exchanged
+	// for an access token once the second factor is too.
+	TokenTypeMFA TokenType = "tubely-mfa"
 )
 
+const apiKeyPrefix = "tubely"
+
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
 
 func HashPassword(password string) (string, error) {
@@ -34,46 +39,104 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+// AccessClaims are the claims of an access token. Scope holds the granted
+// scopes separated by spaces, as in OAuth 2.0. Role is the user's role when
+// the token was issued.
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	Scope *string `json:"scope,omitempty"`
+	Role  string  `json:"role,omitempty"`
+}
+
+type AccessToken struct {
+	UserID uuid.UUID
+	Scopes []string
+	Role   string
+	// IssuedAt is only set on validated tokens.
+	IssuedAt time.Time
+}
+
+func MakeJWT(accessToken AccessToken, keys *KeySet, expiresIn time.Duration) (string, error) {
+	scope := strings.Join(accessToken.Scopes, " ")
+	return keys.sign(AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   accessToken.UserID.String(),
+		},
+		Scope: &scope,
+		Role:  accessToken.Role,
 	})
-	return token.SignedString(signingKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+// ValidateJWT checks an access token and returns its subject and scopes.
+// Tokens issued before scopes existed carry no scope claim and are granted
+// AllScopes.
+func ValidateJWT(tokenString string, keys *KeySet) (AccessToken, error) {
+	claimsStruct := AccessClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		keys.keyFunc,
+		jwt.WithValidMethods(keys.validMethods()),
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return AccessToken{}, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return AccessToken{}, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 	if err != nil {
-		return uuid.Nil, err
+		return AccessToken{}, err
 	}
 	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+		return AccessToken{}, errors.New("invalid issuer")
 	}
 
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+		return AccessToken{}, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	scopes := AllScopes
+	if claimsStruct.Scope != nil {
+		scopes = strings.Fields(*claimsStruct.Scope)
 	}
-	return id, nil
+	var issuedAt time.Time
+	if claimsStruct.IssuedAt != nil {
+		issuedAt = claimsStruct.IssuedAt.Time
+	}
+	return AccessToken{UserID: id, Scopes: scopes, Role: claimsStruct.Role, IssuedAt: issuedAt}, nil
+}
+
+func MakeMFAToken(userID uuid.UUID, keys *KeySet, expiresIn time.Duration) (string, error) {
+	return keys.sign(jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeMFA),
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   userID.String(),
+	})
+}
+
+func ValidateMFAToken(tokenString string, keys *KeySet) (uuid.UUID, error) {
+	claims := jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		keys.keyFunc,
+		jwt.WithValidMethods(keys.validMethods()),
+		jwt.WithIssuer(string(TokenTypeMFA)),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.Parse(claims.Subject)
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -110,3 +173,30 @@ func GetAPIKey(headers http.Header) (string, error) {
 
 	return splitAuth[1], nil
 }
+
+// MakeAPIKey generates a key of the form tubely_<id>_<secret>. The id is a
+// short public identifier that lets users tell their keys apart.
+func MakeAPIKey() (key string, id string, err error) {
+	idBytes := make([]byte, 4)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return "", "", err
+	}
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	id = hex.EncodeToString(idBytes)
+	return fmt.Sprintf("%s_%s_%s", apiKeyPrefix, id, hex.EncodeToString(secret)), id, nil
+}
+
+// ParseAPIKey returns the public identifier of a key made by MakeAPIKey.
+func ParseAPIKey(key string) (string, error) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", errors.New("malformed api key")
+	}
+	return parts[1], nil
+}
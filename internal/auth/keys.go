@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSAKeyBits = 2048
+
+// SigningKey is an asymmetric key used to sign or verify access tokens.
+// Private is nil for keys that are only kept around to verify tokens
+// signed before a rotation.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// NewSigningKey wraps an Ed25519 or RSA key, which may be either the private
+// or the public half. The key ID is the RFC 7638 thumbprint of the public
+// key, so the same key always gets the same ID.
+func NewSigningKey(key any) (*SigningKey, error) {
+	sk := &SigningKey{}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sk.Private, sk.Public = k, k.Public()
+	case ed25519.PublicKey:
+		sk.Public = k
+	case *rsa.PrivateKey:
+		sk.Private, sk.Public = k, k.Public()
+	case *rsa.PublicKey:
+		sk.Public = k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, must be Ed25519 or RSA", key)
+	}
+
+	switch pub := sk.Public.(type) {
+	case ed25519.PublicKey:
+		sk.Method = jwt.SigningMethodEdDSA
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSAKeyBits)
+		}
+		sk.Method = jwt.SigningMethodRS256
+	}
+
+	thumbprint, err := json.Marshal(sk.thumbprintMembers())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(thumbprint)
+	sk.ID = base64.RawURLEncoding.EncodeToString(sum[:])
+	return sk, nil
+}
+
+// LoadSigningKey reads a PEM encoded key from path. Private keys may be in
+// PKCS #8 or PKCS #1 form, public keys in PKIX form.
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	sk, err := NewSigningKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return sk, nil
+}
+
+// JWK is a public key in the JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{
+		KeyID:     k.ID,
+		Use:       "sig",
+		Algorithm: k.Method.Alg(),
+	}
+	members := k.thumbprintMembers()
+	jwk.KeyType = members["kty"]
+	jwk.Curve = members["crv"]
+	jwk.X = members["x"]
+	jwk.N = members["n"]
+	jwk.E = members["e"]
+	return jwk
+}
+
+// thumbprintMembers returns the required members of the public key's JWK,
+// which are what RFC 7638 hashes. encoding/json sorts map keys, giving the
+// lexicographic order the RFC asks for.
+func (k *SigningKey) thumbprintMembers() map[string]string {
+	switch pub := k.Public.(type) {
+	case ed25519.PublicKey:
+		return map[string]string{
+			"crv": "Ed25519",
+			"kty": "OKP",
+			"x":   base64.RawURLEncoding.EncodeToString(pub),
+		}
+	case *rsa.PublicKey:
+		return map[string]string{
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		}
+	}
+	return nil
+}
+
+// KeySet holds the keys used to sign and verify access tokens.
+//
+// Tokens are signed with the current asymmetric key when there is one and
+// carry its ID in the kid header. Previous keys stay in the set so tokens
+// signed before a rotation remain valid until they expire. An HMAC secret
+// is supported for compatibility: it signs tokens when there's no
+// asymmetric key and verifies HS256 tokens otherwise.
+type KeySet struct {
+	current    *SigningKey
+	keys       map[string]*SigningKey
+	hmacSecret []byte
+}
+
+// NewKeySet builds a key set signing with current, which must have a private
+// key, and also accepting tokens signed by any of previous. Either current
+// or hmacSecret must be set.
+func NewKeySet(current *SigningKey, previous []*SigningKey, hmacSecret string) (*KeySet, error) {
+	ks := &KeySet{
+		current:    current,
+		keys:       map[string]*SigningKey{},
+		hmacSecret: []byte(hmacSecret),
+	}
+	if current == nil && hmacSecret == "" {
+		return nil, errors.New("a signing key or an HMAC secret is required")
+	}
+	if current != nil {
+		if current.Private == nil {
+			return nil, errors.New("the signing key must be a private key")
+		}
+		ks.keys[current.ID] = current
+	}
+	for _, key := range previous {
+		if _, ok := ks.keys[key.ID]; !ok {
+			ks.keys[key.ID] = key
+		}
+	}
+	return ks, nil
+}
+
+// JWKS returns the public keys that verify tokens issued by this set. The
+// HMAC secret is never published.
+func (ks *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	if ks.current != nil {
+		set.Keys = append(set.Keys, ks.current.JWK())
+	}
+	for id, key := range ks.keys {
+		if ks.current != nil && id == ks.current.ID {
+			continue
+		}
+		set.Keys = append(set.Keys, key.JWK())
+	}
+	return set
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	if ks.current == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.hmacSecret)
+	}
+	token := jwt.NewWithClaims(ks.current.Method, claims)
+	token.Header["kid"] = ks.current.ID
+	return token.SignedString(ks.current.Private)
+}
+
+func (ks *KeySet) keyFunc(token *jwt.Token) (any, error) {
+	if token.Method == jwt.SigningMethodHS256 {
+		if len(ks.hmacSecret) == 0 {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return ks.hmacSecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if key.Method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not use %s", kid, token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+func (ks *KeySet) validMethods() []string {
+	methods := []string{}
+	if len(ks.hmacSecret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	for _, key := range ks.keys {
+		methods = append(methods, key.Method.Alg())
+	}
+	return methods
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newEd25519Key(t *testing.T) *SigningKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewSigningKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newRSAKey(t *testing.T) *SigningKey {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, minRSAKeyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewSigningKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// publicOnly is the key as it's configured after a rotation, in
+// JWT_VERIFICATION_KEY_FILES.
+func publicOnly(t *testing.T, key *SigningKey) *SigningKey {
+	t.Helper()
+	pub, err := NewSigningKey(key.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+func newKeySet(t *testing.T, current *SigningKey, previous []*SigningKey, hmacSecret string) *KeySet {
+	t.Helper()
+	ks, err := NewKeySet(current, previous, hmacSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func makeToken(t *testing.T, ks *KeySet, userID uuid.UUID) string {
+	t.Helper()
+	token, err := MakeJWT(AccessToken{UserID: userID, Scopes: AllScopes}, ks, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func accessClaims(userID uuid.UUID) AccessClaims {
+	return AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Subject:   userID.String(),
+		},
+	}
+}
+
+func TestKeySetRollover(t *testing.T) {
+	userID := uuid.New()
+	oldKey := newEd25519Key(t)
+	newKey := newRSAKey(t)
+
+	before := newKeySet(t, oldKey, nil, "")
+	token := makeToken(t, before, userID)
+
+	// The new key signs, the old one is kept to verify what it signed.
+	during := newKeySet(t, newKey, []*SigningKey{publicOnly(t, oldKey)}, "")
+	got, err := ValidateJWT(token, during)
+	if err != nil {
+		t.Fatalf("token signed with the old key was rejected during the rollover: %v", err)
+	}
+	if got.UserID != userID {
+		t.Errorf("got user %v, want %v", got.UserID, userID)
+	}
+	newToken := makeToken(t, during, userID)
+	if kid := tokenKeyID(t, newToken); kid != newKey.ID {
+		t.Errorf("new tokens carry kid %q, want the new key's %q", kid, newKey.ID)
+	}
+	if _, err := ValidateJWT(newToken, during); err != nil {
+		t.Errorf("token signed with the new key was rejected: %v", err)
+	}
+
+	after := newKeySet(t, newKey, nil, "")
+	if _, err := ValidateJWT(token, after); err == nil {
+		t.Error("token signed with the old key was accepted after the key was removed")
+	}
+	if _, err := ValidateJWT(newToken, after); err != nil {
+		t.Errorf("token signed with the new key was rejected after the rollover: %v", err)
+	}
+}
+
+func TestKeySetRejectsUnknownKeyID(t *testing.T) {
+	key := newEd25519Key(t)
+	ks := newKeySet(t, key, nil, "")
+
+	for _, kid := range []any{"not-a-key", nil} {
+		token := jwt.NewWithClaims(key.Method, accessClaims(uuid.New()))
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key.Private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ValidateJWT(signed, ks); err == nil {
+			t.Errorf("token with kid %v was accepted", kid)
+		}
+	}
+
+	// A key nobody configured can't sign its way in by reusing a known kid.
+	other := newEd25519Key(t)
+	token := jwt.NewWithClaims(other.Method, accessClaims(uuid.New()))
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(other.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(signed, ks); err == nil {
+		t.Error("token signed by an unknown key under a known kid was accepted")
+	}
+}
+
+func TestKeySetHMACCompat(t *testing.T) {
+	const secret = "compat-secret"
+	userID := uuid.New()
+
+	hmacOnly := newKeySet(t, nil, nil, secret)
+	token := makeToken(t, hmacOnly, userID)
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Method != jwt.SigningMethodHS256 {
+		t.Errorf("got %s, want HS256 without a signing key", parsed.Method.Alg())
+	}
+	if _, err := ValidateJWT(token, hmacOnly); err != nil {
+		t.Errorf("HS256 token was rejected: %v", err)
+	}
+
+	// After moving to a signing key, existing HS256 tokens keep working
+	// while the secret is still set.
+	migrated := newKeySet(t, newEd25519Key(t), nil, secret)
+	if _, err := ValidateJWT(token, migrated); err != nil {
+		t.Errorf("HS256 token was rejected after adding a signing key: %v", err)
+	}
+
+	withoutSecret := newKeySet(t, newEd25519Key(t), nil, "")
+	if _, err := ValidateJWT(token, withoutSecret); err == nil {
+		t.Error("HS256 token was accepted without an HMAC secret")
+	}
+
+	wrongSecret := newKeySet(t, nil, nil, "another-secret")
+	if _, err := ValidateJWT(token, wrongSecret); err == nil {
+		t.Error("HS256 token was accepted with the wrong secret")
+	}
+}
+
+func TestKeySetRejectsAlgorithmConfusion(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	edKey := newEd25519Key(t)
+	ks := newKeySet(t, rsaKey, []*SigningKey{publicOnly(t, edKey)}, "")
+
+	// The classic attack: sign with HS256 using the published public key
+	// as the HMAC secret.
+	der, err := x509.MarshalPKIXPublicKey(rsaKey.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	for _, secret := range [][]byte{publicPEM, der} {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims(uuid.New()))
+		token.Header["kid"] = rsaKey.ID
+		signed, err := token.SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ValidateJWT(signed, ks); err == nil {
+			t.Error("HS256 token keyed with the public key was accepted")
+		}
+	}
+
+	// A token must use the algorithm of the key its kid names.
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, accessClaims(uuid.New()))
+	token.Header["kid"] = edKey.ID
+	signed, err := token.SignedString(rsaKey.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(signed, ks); err == nil {
+		t.Error("RS256 token was accepted under an Ed25519 kid")
+	}
+
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, accessClaims(uuid.New()))
+	unsigned.Header["kid"] = rsaKey.ID
+	none, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(none, ks); err == nil {
+		t.Error("unsigned token was accepted")
+	}
+}
+
+func TestKeySetJWKS(t *testing.T) {
+	current := newRSAKey(t)
+	previous := newEd25519Key(t)
+	ks := newKeySet(t, current, []*SigningKey{publicOnly(t, previous)}, "hmac-secret")
+
+	set := ks.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(set.Keys))
+	}
+	if set.Keys[0].KeyID != current.ID {
+		t.Errorf("first key is %q, want the current key %q", set.Keys[0].KeyID, current.ID)
+	}
+
+	want := map[string]JWK{
+		current.ID:  {KeyType: "RSA", Algorithm: "RS256"},
+		previous.ID: {KeyType: "OKP", Algorithm: "EdDSA", Curve: "Ed25519"},
+	}
+	for _, jwk := range set.Keys {
+		w, ok := want[jwk.KeyID]
+		if !ok {
+			t.Errorf("unexpected key %q", jwk.KeyID)
+			continue
+		}
+		if jwk.KeyType != w.KeyType || jwk.Algorithm != w.Algorithm || jwk.Curve != w.Curve || jwk.Use != "sig" {
+			t.Errorf("key %q is %+v, want %+v", jwk.KeyID, jwk, w)
+		}
+	}
+
+	// Only public members may be published: no private exponent or seed,
+	// and never the HMAC secret.
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range raw.Keys {
+		for _, member := range []string{"d", "p", "q", "dp", "dq", "qi", "k"} {
+			if _, ok := key[member]; ok {
+				t.Errorf("key %v publishes private member %q", key["kid"], member)
+			}
+		}
+	}
+	if strings.Contains(string(data), "hmac-secret") {
+		t.Error("JWKS contains the HMAC secret")
+	}
+
+	// The published key has to verify what the set signs.
+	token := makeToken(t, ks, uuid.New())
+	// A set can't be built from verification keys alone, so give it a
+	// secret the token doesn't use.
+	verifier := newKeySet(t, nil, []*SigningKey{publicOnly(t, current)}, "unused")
+	if _, err := ValidateJWT(token, verifier); err != nil {
+		t.Errorf("published key didn't verify a token from the set: %v", err)
+	}
+}
+
+func TestSigningKeyIDIsStable(t *testing.T) {
+	key := newEd25519Key(t)
+	if pub := publicOnly(t, key); pub.ID != key.ID {
+		t.Errorf("public half has kid %q, private half %q", pub.ID, key.ID)
+	}
+	if other := newEd25519Key(t); other.ID == key.ID {
+		t.Error("different keys got the same kid")
+	}
+}
+
+func tokenKeyID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
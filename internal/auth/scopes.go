@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	ScopeVideosRead   = "videos:read"
+	ScopeVideosWrite  = "videos:write"
+	ScopeVideosDelete = "videos:delete"
+	ScopeUploadsWrite = "uploads:write"
+)
+
+// AllScopes are granted to access tokens issued by logging in. API keys may
+// be limited to a subset.
+var AllScopes = []string{
+	ScopeVideosRead,
+	ScopeVideosWrite,
+	ScopeVideosDelete,
+	ScopeUploadsWrite,
+}
+
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !slices.Contains(AllScopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+func HasScope(scopes []string, scope string) bool {
+	return slices.Contains(scopes, scope)
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey is a long-lived credential for scripts. Only a hash of the key is
+// stored; Prefix is the public part users see when listing their keys.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type CreateAPIKeyParams struct {
+	UserID    uuid.UUID
+	Name      string
+	Prefix    string
+	Key       string
+	Scopes    []string
+	ExpiresAt *time.Time
+}
+
+const apiKeyColumns = `
+	id,
+	created_at,
+	user_id,
+	name,
+	prefix,
+	scopes,
+	expires_at,
+	last_used_at,
+	revoked_at
+`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	err := row.Scan(
+		&key.ID,
+		&key.CreatedAt,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&scopes,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	)
+	key.Scopes = strings.Fields(scopes)
+	return key, err
+}
+
+func (c *Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (
+		id,
+		created_at,
+		user_id,
+		name,
+		prefix,
+		key_hash,
+		scopes,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.UserID,
+		params.Name,
+		params.Prefix,
+		hashToken(params.Key),
+		strings.Join(params.Scopes, " "),
+		params.ExpiresAt,
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return c.GetAPIKey(id)
+}
+
+func (c *Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	query := `SELECT` + apiKeyColumns + `FROM api_keys WHERE id = ?`
+	key, err := scanAPIKey(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// GetAPIKeyByKey looks a key up by the hash of its full value.
+func (c *Client) GetAPIKeyByKey(key string) (APIKey, error) {
+	query := `SELECT` + apiKeyColumns + `FROM api_keys WHERE key_hash = ?`
+	apiKey, err := scanAPIKey(c.db.QueryRow(query, hashToken(key)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return apiKey, nil
+}
+
+func (c *Client) GetAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `SELECT` + apiKeyColumns + `FROM api_keys WHERE user_id = ? ORDER BY created_at DESC`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (c *Client) TouchAPIKey(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, time.Now().UTC(), id)
+	return err
+}
+
+func (c *Client) RevokeAPIKey(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now().UTC(), id)
+	return err
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditLogEntry records a privileged action taken by ActorID.
+type AuditLogEntry struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ActorID    uuid.UUID `json:"actor_id"`
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetID   string    `json:"target_id"`
+	Details    string    `json:"details"`
+	IPAddress  string    `json:"ip_address"`
+}
+
+type CreateAuditLogEntryParams struct {
+	ActorID    uuid.UUID
+	Action     string
+	TargetType string
+	TargetID   string
+	Details    string
+	IPAddress  string
+}
+
+func (c *Client) CreateAuditLogEntry(params CreateAuditLogEntryParams) error {
+	query := `
+	INSERT INTO audit_log (
+		id,
+		created_at,
+		actor_id,
+		action,
+		target_type,
+		target_id,
+		details,
+		ip_address
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		uuid.New(),
+		time.Now().UTC(),
+		params.ActorID,
+		params.Action,
+		params.TargetType,
+		params.TargetID,
+		params.Details,
+		params.IPAddress,
+	)
+	return err
+}
+
+// GetAuditLog returns the most recent entries first.
+func (c *Client) GetAuditLog(limit int) ([]AuditLogEntry, error) {
+	query := `
+	SELECT id, created_at, actor_id, action, target_type, target_id, details, ip_address
+	FROM audit_log
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditLogEntry{}
+	for rows.Next() {
+		var entry AuditLogEntry
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.ActorID,
+			&entry.Action,
+			&entry.TargetType,
+			&entry.TargetID,
+			&entry.Details,
+			&entry.IPAddress,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
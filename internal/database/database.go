@@ -3,14 +3,19 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type Client struct {
 	db *sql.DB
 }
 
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
 func NewClient(pathToDB string) (Client, error) {
 	db, err := sql.Open("sqlite3", pathToDB)
 	if err != nil {
@@ -41,7 +46,7 @@ func (c *Client) autoMigrate() error {
 	}
 	refreshTokenTable := `
 	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
+		token_hash TEXT PRIMARY KEY,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		revoked_at TIMESTAMP,
@@ -54,6 +59,10 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+	err = c.hashPlaintextRefreshTokens()
+	if err != nil {
+		return err
+	}
 
 	videoTable := `
 	CREATE TABLE IF NOT EXISTS videos (
@@ -72,10 +81,591 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	tagTable := `
+	CREATE TABLE IF NOT EXISTS tags (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		UNIQUE(user_id, name),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(tagTable)
+	if err != nil {
+		return err
+	}
+
+	videoTagTable := `
+	CREATE TABLE IF NOT EXISTS video_tags (
+		video_id TEXT NOT NULL,
+		tag_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, tag_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(tag_id) REFERENCES tags(id)
+	);
+	`
+	_, err = c.db.Exec(videoTagTable)
+	if err != nil {
+		return err
+	}
+
+	playlistTable := `
+	CREATE TABLE IF NOT EXISTS playlists (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		title TEXT NOT NULL,
+		description TEXT,
+		user_id TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(playlistTable)
+	if err != nil {
+		return err
+	}
+
+	playlistItemTable := `
+	CREATE TABLE IF NOT EXISTS playlist_items (
+		playlist_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(playlist_id, video_id),
+		FOREIGN KEY(playlist_id) REFERENCES playlists(id),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(playlistItemTable)
+	if err != nil {
+		return err
+	}
+
+	apiKeyTable := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		prefix TEXT UNIQUE NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '',
+		expires_at TIMESTAMP,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(apiKeyTable)
+	if err != nil {
+		return err
+	}
+
+	auditLogTable := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		actor_id TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target_type TEXT NOT NULL,
+		target_id TEXT NOT NULL,
+		details TEXT NOT NULL DEFAULT '',
+		ip_address TEXT NOT NULL DEFAULT ''
+	);
+	`
+	_, err = c.db.Exec(auditLogTable)
+	if err != nil {
+		return err
+	}
+
+	oidcLoginTable := `
+	CREATE TABLE IF NOT EXISTS oidc_logins (
+		state_hash TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		nonce TEXT NOT NULL,
+		code_verifier TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(oidcLoginTable)
+	if err != nil {
+		return err
+	}
+
+	userIdentityTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		PRIMARY KEY(issuer, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userIdentityTable)
+	if err != nil {
+		return err
+	}
+
+	userTokenTable := `
+	CREATE TABLE IF NOT EXISTS user_tokens (
+		token_hash TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		purpose TEXT NOT NULL,
+		email TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userTokenTable)
+	if err != nil {
+		return err
+	}
+
+	totpCredentialTable := `
+	CREATE TABLE IF NOT EXISTS totp_credentials (
+		user_id TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		confirmed_at TIMESTAMP,
+		last_step INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(totpCredentialTable)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodeTable := `
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		code_hash TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(recoveryCodeTable)
+	if err != nil {
+		return err
+	}
+
+	shareLinkTable := `
+	CREATE TABLE IF NOT EXISTS share_links (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		password_hash TEXT NOT NULL DEFAULT '',
+		expires_at TIMESTAMP NOT NULL,
+		max_views INTEGER,
+		view_count INTEGER NOT NULL DEFAULT 0,
+		last_viewed_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(shareLinkTable)
+	if err != nil {
+		return err
+	}
+	shareSessionTable := `
+	CREATE TABLE IF NOT EXISTS share_sessions (
+		token_hash TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		link_id TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(link_id) REFERENCES share_links(id)
+	);
+	`
+	_, err = c.db.Exec(shareSessionTable)
+	if err != nil {
+		return err
+	}
+
+	workspaceTable := `
+	CREATE TABLE IF NOT EXISTS workspaces (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(workspaceTable)
+	if err != nil {
+		return err
+	}
+
+	workspaceMemberTable := `
+	CREATE TABLE IF NOT EXISTS workspace_members (
+		workspace_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(workspace_id, user_id),
+		FOREIGN KEY(workspace_id) REFERENCES workspaces(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(workspaceMemberTable)
+	if err != nil {
+		return err
+	}
+
+	workspaceInviteTable := `
+	CREATE TABLE IF NOT EXISTS workspace_invites (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		workspace_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		role TEXT NOT NULL,
+		invited_by TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		accepted_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(workspace_id) REFERENCES workspaces(id),
+		FOREIGN KEY(invited_by) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(workspaceInviteTable)
+	if err != nil {
+		return err
+	}
+
+	videoCollaboratorTable := `
+	CREATE TABLE IF NOT EXISTS video_collaborators (
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		access TEXT NOT NULL,
+		granted_by TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(video_id, user_id),
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(granted_by) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(videoCollaboratorTable)
+	if err != nil {
+		return err
+	}
+	videoInviteTable := `
+	CREATE TABLE IF NOT EXISTS video_invites (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		access TEXT NOT NULL,
+		invited_by TEXT NOT NULL,
+		token_hash TEXT UNIQUE NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		accepted_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		FOREIGN KEY(video_id) REFERENCES videos(id),
+		FOREIGN KEY(invited_by) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(videoInviteTable)
+	if err != nil {
+		return err
+	}
+
+	webhookTable := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		events TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(webhookTable)
+	if err != nil {
+		return err
+	}
+
+	webhookDeliveryTable := `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		webhook_id TEXT NOT NULL,
+		event_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP,
+		last_attempt_at TIMESTAMP,
+		response_status INTEGER,
+		response_body TEXT NOT NULL DEFAULT '',
+		last_error TEXT NOT NULL DEFAULT '',
+		delivered_at TIMESTAMP,
+		redelivery_of TEXT,
+		FOREIGN KEY(webhook_id) REFERENCES webhooks(id)
+	);
+	`
+	_, err = c.db.Exec(webhookDeliveryTable)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(`CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at)`)
+	if err != nil {
+		return err
+	}
+
+	loginAttemptTable := `
+	CREATE TABLE IF NOT EXISTS login_attempts (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL,
+		last_failed_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(loginAttemptTable)
+	if err != nil {
+		return err
+	}
+
+	columns := []struct {
+		table      string
+		name       string
+		definition string
+		// backfill runs once, right after the column is added
+		backfill string
+	}{
+		{"users", "role", "TEXT NOT NULL DEFAULT 'user'", ""},
+		{"users", "disabled_at", "TIMESTAMP", ""},
+		{"users", "email_verified_at", "TIMESTAMP", ""},
+		{"users", "tokens_valid_after", "TIMESTAMP", ""},
+		{"users", "feed_title", "TEXT NOT NULL DEFAULT ''", ""},
+		{"users", "feed_author", "TEXT NOT NULL DEFAULT ''", ""},
+		{"users", "feed_description", "TEXT NOT NULL DEFAULT ''", ""},
+		{"users", "feed_changed_at", "TIMESTAMP", "UPDATE users SET feed_changed_at = CURRENT_TIMESTAMP"},
+		{"videos", "deleted_at", "TIMESTAMP", ""},
+		{"videos", "status", "TEXT NOT NULL DEFAULT 'draft'", "UPDATE videos SET status = 'ready' WHERE video_url IS NOT NULL"},
+		{"videos", "failure_reason", "TEXT", ""},
+		// Videos from before visibility existed could be fetched by anyone
+		// with the link, so they stay that way.
+		{"videos", "visibility", "TEXT NOT NULL DEFAULT 'private'", "UPDATE videos SET visibility = 'unlisted'"},
+		{"videos", "workspace_id", "TEXT REFERENCES workspaces(id)", ""},
+		// Uploaded files were already sorted into folders by aspect ratio.
+		{"videos", "aspect_ratio", "TEXT", `UPDATE videos SET aspect_ratio = CASE
+			WHEN video_url LIKE '%landscape/%' THEN '16:9'
+			WHEN video_url LIKE '%portrait/%' THEN '9:16'
+			ELSE 'other'
+		END WHERE video_url IS NOT NULL`},
+		{"videos", "video_size", "INTEGER", ""},
+		{"videos", "publish_at", "TIMESTAMP", ""},
+		{"videos", "unpublish_at", "TIMESTAMP", ""},
+		{"videos", "status_changed_at", "TIMESTAMP", "UPDATE videos SET status_changed_at = updated_at"},
+		{"refresh_tokens", "family_id", "TEXT", "UPDATE refresh_tokens SET family_id = lower(hex(randomblob(16)))"},
+		{"refresh_tokens", "rotated_at", "TIMESTAMP", ""},
+		{"refresh_tokens", "last_used_at", "TIMESTAMP", ""},
+		{"refresh_tokens", "user_agent", "TEXT NOT NULL DEFAULT ''", ""},
+		{"refresh_tokens", "ip_address", "TEXT NOT NULL DEFAULT ''", ""},
+	}
+	for _, column := range columns {
+		added, err := c.addColumnIfNotExists(column.table, column.name, column.definition)
+		if err != nil {
+			return err
+		}
+		if added && column.backfill != "" {
+			if _, err := c.db.Exec(column.backfill); err != nil {
+				return fmt.Errorf("failed to backfill column %s.%s: %w", column.table, column.name, err)
+			}
+		}
+	}
+
+	// Feeds are cached by their Last-Modified time, which has to move
+	// forward on every change to a user's videos, including deletes, so
+	// it's kept up to date by the database rather than derived from the
+	// videos that are left.
+	feedTriggers := `
+	CREATE TRIGGER IF NOT EXISTS videos_feed_insert AFTER INSERT ON videos
+	BEGIN
+		UPDATE users SET feed_changed_at = CURRENT_TIMESTAMP WHERE id = NEW.user_id;
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_feed_update
+	AFTER UPDATE OF title, description, thumbnail_url, video_url, user_id, deleted_at, status, visibility, workspace_id, video_size ON videos
+	BEGIN
+		UPDATE users SET feed_changed_at = CURRENT_TIMESTAMP WHERE id IN (OLD.user_id, NEW.user_id);
+	END;
+	CREATE TRIGGER IF NOT EXISTS videos_feed_delete AFTER DELETE ON videos
+	BEGIN
+		UPDATE users SET feed_changed_at = CURRENT_TIMESTAMP WHERE id = OLD.user_id;
+	END;
+	`
+	_, err = c.db.Exec(feedTriggers)
+	if err != nil {
+		return fmt.Errorf("failed to create feed triggers: %w", err)
+	}
 	return nil
 }
 
+// addColumnIfNotExists lets tables created by older versions pick up
+// columns that were added later.
+func (c *Client) addColumnIfNotExists(table, column, definition string) (bool, error) {
+	exists, err := c.hasColumn(table, column)
+	if err != nil || exists {
+		return false, err
+	}
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return true, nil
+}
+
+func (c *Client) hasColumn(table, column string) (bool, error) {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			name         string
+			columnType   string
+			notNull      int
+			defaultValue sql.NullString
+			primaryKey   int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// hashPlaintextRefreshTokens upgrades refresh_tokens tables from versions
+// that stored raw token values, replacing each one with its hash so
+// existing sessions keep working.
+func (c *Client) hashPlaintextRefreshTokens() error {
+	plaintext, err := c.hasColumn("refresh_tokens", "token")
+	if err != nil || !plaintext {
+		return err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash")
+	if err != nil {
+		return fmt.Errorf("failed to rename refresh_tokens.token: %w", err)
+	}
+
+	rows, err := tx.Query("SELECT token_hash FROM refresh_tokens")
+	if err != nil {
+		return err
+	}
+	var tokens []string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			rows.Close()
+			return err
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		_, err = tx.Exec("UPDATE refresh_tokens SET token_hash = ? WHERE token_hash = ?", hashToken(token), token)
+		if err != nil {
+			return fmt.Errorf("failed to hash refresh token: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
 func (c *Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM login_attempts"); err != nil {
+		return fmt.Errorf("failed to reset table login_attempts: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhook_deliveries"); err != nil {
+		return fmt.Errorf("failed to reset table webhook_deliveries: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhooks"); err != nil {
+		return fmt.Errorf("failed to reset table webhooks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_invites"); err != nil {
+		return fmt.Errorf("failed to reset table video_invites: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_collaborators"); err != nil {
+		return fmt.Errorf("failed to reset table video_collaborators: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM workspace_invites"); err != nil {
+		return fmt.Errorf("failed to reset table workspace_invites: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM workspace_members"); err != nil {
+		return fmt.Errorf("failed to reset table workspace_members: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM workspaces"); err != nil {
+		return fmt.Errorf("failed to reset table workspaces: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM share_sessions"); err != nil {
+		return fmt.Errorf("failed to reset table share_sessions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM share_links"); err != nil {
+		return fmt.Errorf("failed to reset table share_links: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM recovery_codes"); err != nil {
+		return fmt.Errorf("failed to reset table recovery_codes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM totp_credentials"); err != nil {
+		return fmt.Errorf("failed to reset table totp_credentials: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table user_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM oidc_logins"); err != nil {
+		return fmt.Errorf("failed to reset table oidc_logins: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_identities"); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlist_items"); err != nil {
+		return fmt.Errorf("failed to reset table playlist_items: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playlists"); err != nil {
+		return fmt.Errorf("failed to reset table playlists: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_tags"); err != nil {
+		return fmt.Errorf("failed to reset table video_tags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM tags"); err != nil {
+		return fmt.Errorf("failed to reset table tags: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
@@ -87,3 +677,14 @@ func (c *Client) Reset() error {
 	}
 	return nil
 }
+
+// parseTimestamp parses timestamps that the driver returns as text, such as
+// the results of aggregates, which lose the column's declared type.
+func parseTimestamp(value string) (time.Time, error) {
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(format, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", value)
+}
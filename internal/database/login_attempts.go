@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginAttempts counts recent failed attempts to sign in, keyed by what was
+// being guessed at, such as an account or the client's IP address.
+type LoginAttempts struct {
+	Key          string
+	Failures     int
+	LastFailedAt time.Time
+}
+
+func (c *Client) GetLoginAttempts(key string) (LoginAttempts, error) {
+	query := `
+		SELECT key, failures, last_failed_at
+		FROM login_attempts
+		WHERE key = ?
+	`
+	var attempts LoginAttempts
+	err := c.db.QueryRow(query, key).Scan(&attempts.Key, &attempts.Failures, &attempts.LastFailedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginAttempts{Key: key}, nil
+	}
+	return attempts, err
+}
+
+// ReserveLoginAttempt counts an attempt as failed before it's made, so
+// parallel guesses can't all get past the check before any failure is
+// recorded. lockedFor reports how long the key is locked given its current
+// count; while it is, nothing is counted and the wait is returned instead.
+// Failures older than forgetAfter are forgotten, so the count starts over.
+func (c *Client) ReserveLoginAttempt(key string, forgetAfter time.Duration, lockedFor func(LoginAttempts) time.Duration) (time.Duration, error) {
+	for {
+		now := time.Now().UTC()
+		attempts, err := c.GetLoginAttempts(key)
+		if err != nil {
+			return 0, err
+		}
+		stored := attempts.Failures
+		if attempts.LastFailedAt.Before(now.Add(-forgetAfter)) {
+			attempts.Failures = 0
+		}
+		if wait := lockedFor(attempts); wait > 0 {
+			return wait, nil
+		}
+
+		// Only count the attempt if nobody else did in the meantime;
+		// otherwise check again against their count.
+		var result sql.Result
+		if attempts.LastFailedAt.IsZero() {
+			result, err = c.db.Exec(`
+				INSERT INTO login_attempts (key, failures, last_failed_at)
+				VALUES (?, 1, ?)
+				ON CONFLICT(key) DO NOTHING
+			`, key, now)
+		} else {
+			result, err = c.db.Exec(`
+				UPDATE login_attempts
+				SET failures = ?, last_failed_at = ?
+				WHERE key = ? AND failures = ?
+			`, attempts.Failures+1, now, key, stored)
+		}
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if n == 1 {
+			return 0, nil
+		}
+	}
+}
+
+// ReleaseLoginAttempt takes back an attempt counted by ReserveLoginAttempt
+// that turned out to succeed.
+func (c *Client) ReleaseLoginAttempt(key string) error {
+	_, err := c.db.Exec(`UPDATE login_attempts SET failures = failures - 1 WHERE key = ? AND failures > 0`, key)
+	return err
+}
+
+func (c *Client) ClearLoginFailures(key string) error {
+	_, err := c.db.Exec(`DELETE FROM login_attempts WHERE key = ?`, key)
+	return err
+}
+
+func (c *Client) DeleteLoginAttemptsBefore(t time.Time) error {
+	_, err := c.db.Exec(`DELETE FROM login_attempts WHERE last_failed_at < ?`, t.UTC())
+	return err
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TOTPCredential is a user's authenticator app secret. It only protects
+// the account once ConfirmedAt is set, which happens after the user proves
+// their app produces matching codes.
+type TOTPCredential struct {
+	UserID      uuid.UUID
+	Secret      string
+	CreatedAt   time.Time
+	ConfirmedAt *time.Time
+	LastStep    int64
+}
+
+func (c *Client) GetTOTPCredential(userID uuid.UUID) (*TOTPCredential, error) {
+	query := `
+		SELECT user_id, secret, created_at, confirmed_at, last_step
+		FROM totp_credentials
+		WHERE user_id = ?
+	`
+	var cred TOTPCredential
+	var id string
+	err := c.db.QueryRow(query, userID.String()).Scan(&id, &cred.Secret, &cred.CreatedAt, &cred.ConfirmedAt, &cred.LastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cred.UserID, err = uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+// SetPendingTOTPSecret starts enrolling the user, replacing any enrollment
+// they didn't finish.
+func (c *Client) SetPendingTOTPSecret(userID uuid.UUID, secret string) error {
+	query := `
+		INSERT INTO totp_credentials (user_id, secret, created_at, last_step)
+		VALUES (?, ?, ?, 0)
+		ON CONFLICT(user_id) DO UPDATE
+		SET secret = excluded.secret, created_at = excluded.created_at, last_step = 0
+		WHERE confirmed_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String(), secret, time.Now().UTC())
+	return err
+}
+
+// ConfirmTOTP enables two-factor authentication with a fresh set of
+// recovery codes, replacing any the user had before.
+func (c *Client) ConfirmTOTP(userID uuid.UUID, step int64, recoveryCodes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`
+		UPDATE totp_credentials
+		SET confirmed_at = ?, last_step = ?
+		WHERE user_id = ?
+	`, now, step, userID.String())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return err
+	}
+	for _, code := range recoveryCodes {
+		_, err = tx.Exec(`
+			INSERT INTO recovery_codes (code_hash, user_id, created_at)
+			VALUES (?, ?, ?)
+		`, hashToken(code), userID.String(), now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code for step was used. It returns false if
+// that code, or a later one, was used already, so intercepted codes can't
+// be replayed.
+func (c *Client) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	query := `
+		UPDATE totp_credentials
+		SET last_step = ?
+		WHERE user_id = ? AND last_step < ?
+	`
+	result, err := c.db.Exec(query, step, userID.String(), step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// UseRecoveryCode redeems one of the user's recovery codes, returning
+// false if it isn't valid or was used before.
+func (c *Client) UseRecoveryCode(userID uuid.UUID, code string) (bool, error) {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = ?
+		WHERE code_hash = ? AND user_id = ? AND used_at IS NULL
+	`
+	result, err := c.db.Exec(query, time.Now().UTC(), hashToken(code), userID.String())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+func (c *Client) CountRecoveryCodes(userID uuid.UUID) (int, error) {
+	var count int
+	err := c.db.QueryRow(`
+		SELECT COUNT(*) FROM recovery_codes
+		WHERE user_id = ? AND used_at IS NULL
+	`, userID.String()).Scan(&count)
+	return count, err
+}
+
+func (c *Client) DeleteTOTP(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM totp_credentials WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
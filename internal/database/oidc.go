@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OIDCLogin is an SSO login that has been sent to the identity provider and
+// is waiting for the callback. It's looked up by the state parameter, of
+// which only a hash is stored.
+type OIDCLogin struct {
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// UserIdentity links a user to an account at an identity provider.
+type UserIdentity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+}
+
+func (c *Client) CreateOIDCLogin(state string, login OIDCLogin) error {
+	query := `
+		INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expires_at)
+		VALUES (?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, hashToken(state), login.Nonce, login.CodeVerifier, login.ExpiresAt)
+	return err
+}
+
+// TakeOIDCLogin returns the pending login for state and deletes it, so each
+// state can only be used once. Expired logins are cleaned up along the way.
+func (c *Client) TakeOIDCLogin(state string) (*OIDCLogin, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var login OIDCLogin
+	err = tx.QueryRow(`
+		SELECT nonce, code_verifier, expires_at
+		FROM oidc_logins
+		WHERE state_hash = ?
+	`, hashToken(state)).Scan(&login.Nonce, &login.CodeVerifier, &login.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM oidc_logins WHERE state_hash = ? OR expires_at < ?`, hashToken(state), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+func (c *Client) GetUserByIdentity(issuer, subject string) (*User, error) {
+	query := `
+		SELECT` + userColumns + `
+		FROM users
+		JOIN user_identities ui ON users.id = ui.user_id
+		WHERE ui.issuer = ? AND ui.subject = ?
+	`
+	user, err := scanUser(c.db.QueryRow(query, issuer, subject))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *Client) CreateUserIdentity(identity UserIdentity) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, created_at, user_id, email)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, identity.Issuer, identity.Subject, time.Now().UTC(), identity.UserID.String(), identity.Email)
+	return err
+}
@@ -0,0 +1,310 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrPlaylistItemExists   = errors.New("video is already in the playlist")
+	ErrPlaylistItemNotFound = errors.New("video is not in the playlist")
+	ErrPlaylistOrder        = errors.New("order must list every video in the playlist exactly once")
+)
+
+type Playlist struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatePlaylistParams
+}
+
+type CreatePlaylistParams struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	UserID      uuid.UUID `json:"user_id"`
+}
+
+func (c *Client) CreatePlaylist(params CreatePlaylistParams) (Playlist, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO playlists (
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		user_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	return c.GetPlaylist(id)
+}
+
+func (c *Client) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	query := `
+	SELECT id, created_at, updated_at, title, description, user_id
+	FROM playlists
+	WHERE id = ?
+	`
+	var playlist Playlist
+	err := c.db.QueryRow(query, id).Scan(
+		&playlist.ID,
+		&playlist.CreatedAt,
+		&playlist.UpdatedAt,
+		&playlist.Title,
+		&playlist.Description,
+		&playlist.UserID,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Playlist{}, nil
+		}
+		return Playlist{}, err
+	}
+	return playlist, nil
+}
+
+func (c *Client) GetPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	query := `
+	SELECT id, created_at, updated_at, title, description, user_id
+	FROM playlists
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		var playlist Playlist
+		if err := rows.Scan(
+			&playlist.ID,
+			&playlist.CreatedAt,
+			&playlist.UpdatedAt,
+			&playlist.Title,
+			&playlist.Description,
+			&playlist.UserID,
+		); err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	return playlists, rows.Err()
+}
+
+func (c *Client) UpdatePlaylist(playlist Playlist) error {
+	query := `
+	UPDATE playlists
+	SET title = ?, description = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, playlist.Title, playlist.Description, time.Now().UTC(), playlist.ID)
+	return err
+}
+
+func (c *Client) DeletePlaylist(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM playlist_items WHERE playlist_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM playlists WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPlaylistVideos returns the playlist's videos in order. Videos in the
+// trash keep their place but are left out.
+func (c *Client) GetPlaylistVideos(playlistID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	JOIN playlist_items pi ON pi.video_id = videos.id
+	WHERE pi.playlist_id = ? AND videos.deleted_at IS NULL
+	ORDER BY pi.position
+	`
+	videos, err := c.queryVideos(query, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	if videos == nil {
+		videos = []Video{}
+	}
+	return videos, nil
+}
+
+// InsertPlaylistItem adds a video at the given zero-based position,
+// shifting later videos down. A negative or out of range position appends.
+func (c *Client) InsertPlaylistItem(playlistID, videoID uuid.UUID, position int) error {
+	return c.updatePlaylistOrder(playlistID, func(order []uuid.UUID) ([]uuid.UUID, error) {
+		for _, id := range order {
+			if id == videoID {
+				return nil, ErrPlaylistItemExists
+			}
+		}
+		if position < 0 || position > len(order) {
+			position = len(order)
+		}
+		order = append(order, uuid.Nil)
+		copy(order[position+1:], order[position:])
+		order[position] = videoID
+		return order, nil
+	})
+}
+
+func (c *Client) RemovePlaylistItem(playlistID, videoID uuid.UUID) error {
+	return c.updatePlaylistOrder(playlistID, func(order []uuid.UUID) ([]uuid.UUID, error) {
+		for i, id := range order {
+			if id == videoID {
+				return append(order[:i], order[i+1:]...), nil
+			}
+		}
+		return nil, ErrPlaylistItemNotFound
+	})
+}
+
+// ReorderPlaylistItems rewrites the order of a playlist. videoIDs must hold
+// every video in the playlist that isn't in the trash; trashed videos are
+// moved after them.
+func (c *Client) ReorderPlaylistItems(playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	trashed, err := c.trashedPlaylistVideos(playlistID)
+	if err != nil {
+		return err
+	}
+
+	return c.updatePlaylistOrder(playlistID, func(order []uuid.UUID) ([]uuid.UUID, error) {
+		remaining := make(map[uuid.UUID]bool, len(order))
+		for _, id := range order {
+			if !trashed[id] {
+				remaining[id] = true
+			}
+		}
+		if len(videoIDs) != len(remaining) {
+			return nil, ErrPlaylistOrder
+		}
+
+		newOrder := make([]uuid.UUID, 0, len(order))
+		for _, id := range videoIDs {
+			if !remaining[id] {
+				return nil, ErrPlaylistOrder
+			}
+			delete(remaining, id)
+			newOrder = append(newOrder, id)
+		}
+		for _, id := range order {
+			if trashed[id] {
+				newOrder = append(newOrder, id)
+			}
+		}
+		return newOrder, nil
+	})
+}
+
+func (c *Client) trashedPlaylistVideos(playlistID uuid.UUID) (map[uuid.UUID]bool, error) {
+	query := `
+	SELECT pi.video_id
+	FROM playlist_items pi
+	JOIN videos v ON v.id = pi.video_id
+	WHERE pi.playlist_id = ? AND v.deleted_at IS NOT NULL
+	`
+	rows, err := c.db.Query(query, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trashed := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		trashed[id] = true
+	}
+	return trashed, rows.Err()
+}
+
+// updatePlaylistOrder loads the playlist's video IDs in order, lets change
+// edit them and writes the result back with contiguous positions.
+func (c *Client) updatePlaylistOrder(playlistID uuid.UUID, change func([]uuid.UUID) ([]uuid.UUID, error)) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT video_id FROM playlist_items WHERE playlist_id = ? ORDER BY position`, playlistID)
+	if err != nil {
+		return err
+	}
+	order := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		order = append(order, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	existing := make(map[uuid.UUID]bool, len(order))
+	for _, id := range order {
+		existing[id] = true
+	}
+
+	order, err = change(order)
+	if err != nil {
+		return err
+	}
+
+	for position, videoID := range order {
+		if existing[videoID] {
+			delete(existing, videoID)
+			_, err = tx.Exec(`
+			UPDATE playlist_items SET position = ?
+			WHERE playlist_id = ? AND video_id = ?
+			`, position, playlistID, videoID)
+		} else {
+			_, err = tx.Exec(`
+			INSERT INTO playlist_items (playlist_id, video_id, position, created_at)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+			`, playlistID, videoID, position)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for videoID := range existing {
+		_, err = tx.Exec(`DELETE FROM playlist_items WHERE playlist_id = ? AND video_id = ?`, playlistID, videoID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE playlists SET updated_at = ? WHERE id = ?`, time.Now().UTC(), playlistID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
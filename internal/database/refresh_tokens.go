@@ -1,41 +1,133 @@
 package database
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+var ErrRefreshTokenReused = errors.New("refresh token has already been rotated")
+
+// RefreshToken is a stored refresh token. Only a hash of the token value is
+// kept, so the raw token can't be recovered from the database.
 type RefreshToken struct {
-	CreateRefreshTokenParams
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	RevokedAt *time.Time `json:"revoked_at"`
+	TokenHash  string     `json:"-"`
+	UserID     uuid.UUID  `json:"user_id"`
+	FamilyID   string     `json:"family_id"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	RotatedAt  *time.Time `json:"rotated_at"`
 }
 
+// CreateRefreshTokenParams describes a new refresh token. Every token issued
+// by rotating another one shares its FamilyID with the token it replaced.
 type CreateRefreshTokenParams struct {
-	Token     string    `json:"token"`
-	UserID    uuid.UUID `json:"user_id"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token     string
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	FamilyID  string
+	UserAgent string
+	IPAddress string
+}
+
+// Session is a chain of rotated refresh tokens started by a single login,
+// identified by the family ID the tokens share.
+type Session struct {
+	ID         string     `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func (c *Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	err := createRefreshToken(c.db, params)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	return c.GetRefreshToken(params.Token)
+}
+
+func createRefreshToken(ex execer, params CreateRefreshTokenParams) error {
 	query := `
 		INSERT INTO refresh_tokens (
-			token,
+			token_hash,
 			created_at,
 			updated_at,
+			last_used_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			expires_at,
+			family_id,
+			user_agent,
+			ip_address
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := ex.Exec(
+		query,
+		hashToken(params.Token),
+		time.Now().UTC(),
+		params.UserID.String(),
+		params.ExpiresAt,
+		params.FamilyID,
+		params.UserAgent,
+		params.IPAddress,
+	)
+	return err
+}
+
+// RotateRefreshToken marks oldToken as used and stores its replacement in
+// the same family. If oldToken was already rotated, nothing is stored and
+// ErrRefreshTokenReused is returned.
+func (c *Client) RotateRefreshToken(oldToken string, params CreateRefreshTokenParams) (RefreshToken, error) {
+	tx, err := c.db.Begin()
 	if err != nil {
 		return RefreshToken{}, err
 	}
+	defer tx.Rollback()
 
+	now := time.Now().UTC()
+	query := `
+		UPDATE refresh_tokens
+		SET rotated_at = ?, last_used_at = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE token_hash = ? AND rotated_at IS NULL
+	`
+	result, err := tx.Exec(query, now, now, hashToken(oldToken))
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if rows == 0 {
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+
+	err = createRefreshToken(tx, params)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return RefreshToken{}, err
+	}
 	return c.GetRefreshToken(params.Token)
 }
 
@@ -43,22 +135,54 @@ func (c *Client) RevokeRefreshToken(token string) error {
 	query := `
 		UPDATE refresh_tokens
 		SET revoked_at = CURRENT_TIMESTAMP
-		WHERE token = ?
+		WHERE token_hash = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.db.Exec(query, hashToken(token))
+	return err
+}
+
+func (c *Client) RevokeRefreshTokenFamily(familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE family_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.Exec(query, familyID)
 	return err
 }
 
 func (c *Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT
+			token_hash,
+			created_at,
+			updated_at,
+			user_id,
+			expires_at,
+			revoked_at,
+			rotated_at,
+			last_used_at,
+			family_id,
+			user_agent,
+			ip_address
 		FROM refresh_tokens
-		WHERE token = ?
+		WHERE token_hash = ?
 	`
 	var rt RefreshToken
 	var userID string
-	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+	err := c.db.QueryRow(query, hashToken(token)).Scan(
+		&rt.TokenHash,
+		&rt.CreatedAt,
+		&rt.UpdatedAt,
+		&userID,
+		&rt.ExpiresAt,
+		&rt.RevokedAt,
+		&rt.RotatedAt,
+		&rt.LastUsedAt,
+		&rt.FamilyID,
+		&rt.UserAgent,
+		&rt.IPAddress,
+	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return RefreshToken{}, nil
@@ -77,8 +201,101 @@ func (c *Client) GetRefreshToken(token string) (RefreshToken, error) {
 func (c *Client) DeleteRefreshToken(token string) error {
 	query := `
 		DELETE FROM refresh_tokens
-		WHERE token = ?
+		WHERE token_hash = ?
+	`
+	_, err := c.db.Exec(query, hashToken(token))
+	return err
+}
+
+// GetSessions lists the user's sessions that still hold a usable refresh
+// token, most recently used first.
+func (c *Client) GetSessions(userID uuid.UUID) ([]Session, error) {
+	query := `
+		SELECT
+			rt.family_id,
+			rt.user_agent,
+			rt.ip_address,
+			(SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = rt.family_id),
+			rt.last_used_at,
+			rt.expires_at
+		FROM refresh_tokens rt
+		WHERE rt.user_id = ?
+			AND rt.revoked_at IS NULL
+			AND rt.rotated_at IS NULL
+			AND rt.expires_at > ?
+		ORDER BY rt.last_used_at DESC
+	`
+	rows, err := c.db.Query(query, userID.String(), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		var createdAt string
+		if err := rows.Scan(
+			&session.ID,
+			&session.UserAgent,
+			&session.IPAddress,
+			&createdAt,
+			&session.LastUsedAt,
+			&session.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		session.CreatedAt, err = parseTimestamp(createdAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes every refresh token in the session, reporting
+// whether the session belonged to the user.
+func (c *Client) RevokeSession(userID uuid.UUID, sessionID string) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND family_id = ? AND revoked_at IS NULL
 	`
-	_, err := c.db.Exec(query, token)
+	result, err := c.db.Exec(query, userID.String(), sessionID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// RevokeAllSessions signs the user out everywhere: every refresh token is
+// revoked and access tokens issued until now stop being accepted.
+func (c *Client) RevokeAllSessions(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = revokeAllSessions(tx, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// revokeAllSessions does the work of RevokeAllSessions inside tx. Token
+// issue times only have second precision, so the cutoff is rounded down to
+// keep tokens issued right afterwards, such as the new session after a
+// password change, valid.
+func revokeAllSessions(tx *sql.Tx, userID uuid.UUID) error {
+	now := time.Now().UTC()
+	_, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, now, userID.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE users SET tokens_valid_after = ? WHERE id = ?`, now.Truncate(time.Second), userID.String())
 	return err
 }
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareLink lets someone without an account watch a video until the link
+// expires, runs out of views or is revoked. Only a hash of the token is
+// stored, so links can't be recovered from the database.
+type ShareLink struct {
+	ID           uuid.UUID  `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	VideoID      uuid.UUID  `json:"video_id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	PasswordHash string     `json:"-"`
+	HasPassword  bool       `json:"has_password"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	MaxViews     *int       `json:"max_views"`
+	ViewCount    int        `json:"view_count"`
+	LastViewedAt *time.Time `json:"last_viewed_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+}
+
+// Active reports whether the link is neither revoked nor expired at time t.
+func (l ShareLink) Active(t time.Time) bool {
+	return l.RevokedAt == nil && t.Before(l.ExpiresAt)
+}
+
+// Usable reports whether the link can still be opened at time t, ignoring
+// its password.
+func (l ShareLink) Usable(t time.Time) bool {
+	if !l.Active(t) {
+		return false
+	}
+	return l.MaxViews == nil || l.ViewCount < *l.MaxViews
+}
+
+type CreateShareLinkParams struct {
+	VideoID      uuid.UUID
+	UserID       uuid.UUID
+	Token        string
+	PasswordHash string
+	ExpiresAt    time.Time
+	MaxViews     *int
+}
+
+const shareLinkColumns = `
+	id,
+	created_at,
+	video_id,
+	user_id,
+	password_hash,
+	expires_at,
+	max_views,
+	view_count,
+	last_viewed_at,
+	revoked_at
+`
+
+func scanShareLink(row rowScanner) (ShareLink, error) {
+	var link ShareLink
+	err := row.Scan(
+		&link.ID,
+		&link.CreatedAt,
+		&link.VideoID,
+		&link.UserID,
+		&link.PasswordHash,
+		&link.ExpiresAt,
+		&link.MaxViews,
+		&link.ViewCount,
+		&link.LastViewedAt,
+		&link.RevokedAt,
+	)
+	link.HasPassword = link.PasswordHash != ""
+	return link, err
+}
+
+func (c *Client) CreateShareLink(params CreateShareLinkParams) (ShareLink, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO share_links (
+		id,
+		created_at,
+		video_id,
+		user_id,
+		token_hash,
+		password_hash,
+		expires_at,
+		max_views
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.VideoID,
+		params.UserID,
+		hashToken(params.Token),
+		params.PasswordHash,
+		params.ExpiresAt.UTC(),
+		params.MaxViews,
+	)
+	if err != nil {
+		return ShareLink{}, err
+	}
+
+	return c.GetShareLink(id)
+}
+
+func (c *Client) GetShareLink(id uuid.UUID) (ShareLink, error) {
+	query := `SELECT` + shareLinkColumns + `FROM share_links WHERE id = ?`
+	return c.queryShareLink(query, id)
+}
+
+// GetShareLinkByToken looks a link up by the hash of its token.
+func (c *Client) GetShareLinkByToken(token string) (ShareLink, error) {
+	query := `SELECT` + shareLinkColumns + `FROM share_links WHERE token_hash = ?`
+	return c.queryShareLink(query, hashToken(token))
+}
+
+func (c *Client) queryShareLink(query string, args ...any) (ShareLink, error) {
+	link, err := scanShareLink(c.db.QueryRow(query, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ShareLink{}, nil
+		}
+		return ShareLink{}, err
+	}
+	return link, nil
+}
+
+func (c *Client) GetShareLinks(videoID uuid.UUID) ([]ShareLink, error) {
+	query := `SELECT` + shareLinkColumns + `FROM share_links WHERE video_id = ? ORDER BY created_at DESC`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// ShareSession is a browser watching a share link. Opening the link counts
+// one view and starts a session, and the video is then streamed for as long
+// as the session lasts without counting more views.
+type ShareSession struct {
+	LinkID    uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// OpenShareSession counts a view of the link and starts a session for it
+// with the given token. It reports false, without doing either, if the link
+// was revoked, expired or used up in the meantime, so concurrent views
+// can't exceed the limit.
+func (c *Client) OpenShareSession(linkID uuid.UUID, token string, expiresAt time.Time) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	query := `
+	UPDATE share_links
+	SET view_count = view_count + 1, last_viewed_at = ?
+	WHERE id = ?
+		AND revoked_at IS NULL
+		AND expires_at > ?
+		AND (max_views IS NULL OR view_count < max_views)
+	`
+	result, err := tx.Exec(query, now, linkID, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n != 1 {
+		return false, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM share_sessions WHERE expires_at <= ?`, now)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO share_sessions (token_hash, created_at, link_id, expires_at) VALUES (?, ?, ?, ?)`,
+		hashToken(token), now, linkID, expiresAt.UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// GetShareSession returns the unexpired session with the given token, or a
+// zero ShareSession if there is none.
+func (c *Client) GetShareSession(token string) (ShareSession, error) {
+	query := `
+	SELECT link_id, created_at, expires_at
+	FROM share_sessions
+	WHERE token_hash = ? AND expires_at > ?
+	`
+	var session ShareSession
+	err := c.db.QueryRow(query, hashToken(token), time.Now().UTC()).Scan(&session.LinkID, &session.CreatedAt, &session.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShareSession{}, nil
+	}
+	return session, err
+}
+
+func (c *Client) RevokeShareLink(id uuid.UUID) error {
+	_, err := c.db.Exec(`UPDATE share_links SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now().UTC(), id)
+	return err
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const MaxTagLength = 50
+
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// NormalizeTagName trims and lowercases a tag and collapses runs of
+// whitespace so that "Go  Tutorials " and "go tutorials" are the same tag.
+func NormalizeTagName(name string) (string, error) {
+	normalized := strings.ToLower(strings.Join(strings.Fields(name), " "))
+	if normalized == "" {
+		return "", errors.New("tag name is empty")
+	}
+	if utf8.RuneCountInString(normalized) > MaxTagLength {
+		return "", fmt.Errorf("tag name is longer than %d characters", MaxTagLength)
+	}
+	return normalized, nil
+}
+
+// AddVideoTags attaches tags to a video, creating any of the owner's tags
+// that don't exist yet. Tags belong to the video's owner whoever adds
+// them, so they show up in the owner's tag list. Tags already on the video
+// are left untouched.
+func (c *Client) AddVideoTags(ownerID, videoID uuid.UUID, names []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, name := range names {
+		name, err = NormalizeTagName(name)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+		INSERT INTO tags (id, created_at, user_id, name)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?)
+		ON CONFLICT(user_id, name) DO NOTHING
+		`, uuid.New(), ownerID, name)
+		if err != nil {
+			return err
+		}
+
+		var tagID string
+		err = tx.QueryRow(`SELECT id FROM tags WHERE user_id = ? AND name = ?`, ownerID, name).Scan(&tagID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+		INSERT OR IGNORE INTO video_tags (video_id, tag_id, created_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		`, videoID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveVideoTag takes the tag off the video, whoever's tag it is.
+func (c *Client) RemoveVideoTag(videoID uuid.UUID, name string) error {
+	name, err := NormalizeTagName(name)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	DELETE FROM video_tags
+	WHERE video_id = ? AND tag_id IN (
+		SELECT id FROM tags WHERE name = ?
+	)
+	`
+	_, err = c.db.Exec(query, videoID, name)
+	return err
+}
+
+func (c *Client) GetVideoTags(videoID uuid.UUID) ([]string, error) {
+	query := `
+	SELECT t.name
+	FROM tags t
+	JOIN video_tags vt ON vt.tag_id = t.id
+	WHERE vt.video_id = ?
+	ORDER BY t.name
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+	return tags, rows.Err()
+}
+
+// GetTagCounts lists the user's tags with the number of videos, outside the
+// trash, that carry each one. Tags that are no longer used are omitted.
+func (c *Client) GetTagCounts(userID uuid.UUID) ([]TagCount, error) {
+	query := `
+	SELECT t.name, COUNT(v.id)
+	FROM tags t
+	JOIN video_tags vt ON vt.tag_id = t.id
+	JOIN videos v ON v.id = vt.video_id AND v.deleted_at IS NULL
+	WHERE t.user_id = ?
+	GROUP BY t.id, t.name
+	ORDER BY t.name
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []TagCount{}
+	for rows.Next() {
+		var count TagCount
+		if err := rows.Scan(&count.Name, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
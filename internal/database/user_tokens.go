@@ -0,0 +1,122 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserTokenPurpose says what a single-use user token may be redeemed for.
+type UserTokenPurpose string
+
+const (
+	UserTokenEmailVerification UserTokenPurpose = "email_verification"
+	UserTokenPasswordReset     UserTokenPurpose = "password_reset"
+)
+
+// UserToken is a single-use token sent to a user by email. Only a hash of
+// the token is stored. Email is the address the token was sent to, so a
+// verification link only verifies the address it was sent to.
+type UserToken struct {
+	UserID    uuid.UUID
+	Purpose   UserTokenPurpose
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+type CreateUserTokenParams struct {
+	Token     string
+	UserID    uuid.UUID
+	Purpose   UserTokenPurpose
+	Email     string
+	ExpiresAt time.Time
+}
+
+func (c *Client) CreateUserToken(params CreateUserTokenParams) error {
+	query := `
+	INSERT INTO user_tokens (
+		token_hash,
+		created_at,
+		user_id,
+		purpose,
+		email,
+		expires_at
+	) VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		hashToken(params.Token),
+		time.Now().UTC(),
+		params.UserID.String(),
+		params.Purpose,
+		params.Email,
+		params.ExpiresAt,
+	)
+	return err
+}
+
+// ConsumeUserToken marks an unused, unexpired token as used and returns it.
+// It returns nil if there's no such token, so each token works exactly once.
+func (c *Client) ConsumeUserToken(token string, purpose UserTokenPurpose) (*UserToken, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	result, err := tx.Exec(`
+		UPDATE user_tokens
+		SET used_at = ?
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+	`, now, hashToken(token), purpose, now)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, nil
+	}
+
+	var ut UserToken
+	var userID string
+	err = tx.QueryRow(`
+		SELECT user_id, purpose, email, created_at, expires_at, used_at
+		FROM user_tokens
+		WHERE token_hash = ?
+	`, hashToken(token)).Scan(&userID, &ut.Purpose, &ut.Email, &ut.CreatedAt, &ut.ExpiresAt, &ut.UsedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ut.UserID, err = uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &ut, nil
+}
+
+// InvalidateUserTokens uses up every outstanding token of the user for
+// purpose, so only the newest link sent works.
+func (c *Client) InvalidateUserTokens(userID uuid.UUID, purpose UserTokenPurpose) error {
+	query := `
+		UPDATE user_tokens
+		SET used_at = ?
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), userID.String(), purpose)
+	return err
+}
@@ -3,29 +3,120 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type UserRole string
+
+const (
+	UserRoleUser      UserRole = "user"
+	UserRoleModerator UserRole = "moderator"
+	UserRoleAdmin     UserRole = "admin"
+)
+
+var userRoleRanks = map[UserRole]int{
+	UserRoleUser:      0,
+	UserRoleModerator: 1,
+	UserRoleAdmin:     2,
+}
+
+func ParseUserRole(s string) (UserRole, error) {
+	role := UserRole(s)
+	if _, ok := userRoleRanks[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return role, nil
+}
+
+// AtLeast reports whether r grants everything min does. Admins can do
+// anything moderators can.
+func (r UserRole) AtLeast(min UserRole) bool {
+	rank, ok := userRoleRanks[r]
+	return ok && rank >= userRoleRanks[min]
+}
+
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              uuid.UUID  `json:"id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	Role            UserRole   `json:"role"`
+	DisabledAt      *time.Time `json:"disabled_at"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// TokensValidAfter is when the user was last signed out everywhere.
+	// Access tokens issued before then are rejected.
+	TokensValidAfter *time.Time   `json:"-"`
+	Feed             FeedSettings `json:"-"`
+	// FeedChangedAt is when anything shown in the user's feeds last
+	// changed, including videos leaving them.
+	FeedChangedAt *time.Time `json:"-"`
 	CreateUserParams
 }
 
+// FeedSettings describe the user's public feeds. Empty fields fall back to
+// defaults when the feeds are built.
+type FeedSettings struct {
+	Title       string `json:"title"`
+	Author      string `json:"author"`
+	Description string `json:"description"`
+}
+
 type CreateUserParams struct {
 	Email    string `json:"email"`
-	Password string `json:"password"`
+	Password string `json:"-"`
+}
+
+const userColumns = `
+	users.id,
+	users.created_at,
+	users.updated_at,
+	users.email,
+	users.password,
+	users.role,
+	users.disabled_at,
+	users.email_verified_at,
+	users.tokens_valid_after,
+	users.feed_title,
+	users.feed_author,
+	users.feed_description,
+	users.feed_changed_at
+`
+
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	var id string
+	err := row.Scan(
+		&id,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.Email,
+		&user.Password,
+		&user.Role,
+		&user.DisabledAt,
+		&user.EmailVerifiedAt,
+		&user.TokensValidAfter,
+		&user.Feed.Title,
+		&user.Feed.Author,
+		&user.Feed.Description,
+		&user.FeedChangedAt,
+	)
+	if err != nil {
+		return User{}, err
+	}
+	user.ID, err = uuid.Parse(id)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
 }
 
 func (c *Client) GetUsers() ([]User, error) {
 	query := `
-		SELECT
-			id,
-			email
+		SELECT` + userColumns + `
 		FROM users
+		ORDER BY created_at
 	`
 
 	rows, err := c.db.Query(query)
@@ -34,66 +125,49 @@ func (c *Client) GetUsers() ([]User, error) {
 	}
 	defer rows.Close()
 
-	var users []User
+	users := []User{}
 	for rows.Next() {
-		var user User
-		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
-			return nil, err
-		}
-		user.ID, err = uuid.Parse(id)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 func (c *Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT` + userColumns + `
 		FROM users
 		WHERE email = ?
 	`
-	var user User
-	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, email))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
 		}
 		return User{}, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return User{}, err
-	}
 	return user, nil
 }
 
 func (c *Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
-		FROM users u
-		JOIN refresh_tokens rt ON u.id = rt.user_id
-		WHERE rt.token = ?
+		SELECT` + userColumns + `
+		FROM users
+		JOIN refresh_tokens rt ON users.id = rt.user_id
+		WHERE rt.token_hash = ?
 	`
 
-	var user User
-	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, hashToken(token)))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return nil, err
-	}
 
 	return &user, nil
 }
@@ -117,31 +191,202 @@ func (c *Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c *Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT` + userColumns + `
 		FROM users
 		WHERE id = ?
 	`
-	var user User
-	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	user, err := scanUser(c.db.QueryRow(query, id.String()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(idStr)
+	return &user, nil
+}
+
+func (c *Client) SetUserRole(id uuid.UUID, role UserRole) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, role, id.String())
+	return err
+}
+
+// BootstrapAdmins makes admins of the users with the given emails, so
+// there's someone to grant roles through the API. It does nothing once any
+// admin exists, so demoting a listed user sticks across restarts, and only
+// promotes users who have verified their email, matched case-insensitively.
+// It returns the users it promoted, with their previous role.
+func (c *Client) BootstrapAdmins(emails []string) ([]User, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var admins int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM users WHERE role = ?`, UserRoleAdmin).Scan(&admins)
 	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+	if admins > 0 {
+		return nil, nil
+	}
+
+	var promoted []User
+	for _, email := range emails {
+		query := `SELECT` + userColumns + `FROM users WHERE LOWER(email) = LOWER(?) AND email_verified_at IS NOT NULL`
+		user, err := scanUser(tx.QueryRow(query, email))
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`UPDATE users SET role = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, UserRoleAdmin, user.ID.String())
+		if err != nil {
+			return nil, err
+		}
+		promoted = append(promoted, user)
+	}
+	return promoted, tx.Commit()
 }
 
-func (c *Client) DeleteUser(id uuid.UUID) error {
+// SetUserDisabled disables or re-enables an account. Disabling also revokes
+// the user's sessions and API keys.
+func (c *Client) SetUserDisabled(id uuid.UUID, disabled bool) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var disabledAt *time.Time
+	if disabled {
+		now := time.Now().UTC()
+		disabledAt = &now
+
+		_, err = tx.Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, now, id.String())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE api_keys SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, now, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE users SET disabled_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, disabledAt, id.String())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetUserEmailVerified marks the user's email as verified, as long as it's
+// still email. A verification link for an address the user has since
+// changed away from does nothing.
+func (c *Client) SetUserEmailVerified(id uuid.UUID, email string) (bool, error) {
+	query := `
+		UPDATE users
+		SET email_verified_at = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND email = ?
+	`
+	result, err := c.db.Exec(query, time.Now().UTC(), id.String(), email)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// UpdateUserPassword sets a new password hash and signs the user out
+// everywhere, since whoever knew the old password may still hold a session.
+func (c *Client) UpdateUserPassword(id uuid.UUID, hashedPassword string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, hashedPassword, id.String())
+	if err != nil {
+		return err
+	}
+	err = revokeAllSessions(tx, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateUserEmail changes the user's email, which then needs verifying
+// again.
+func (c *Client) UpdateUserEmail(id uuid.UUID, email string) error {
+	query := `
+		UPDATE users
+		SET email = ?, email_verified_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, email, id.String())
+	return err
+}
+
+// UpdateUserFeed changes how the user's feeds describe themselves.
+func (c *Client) UpdateUserFeed(id uuid.UUID, feed FeedSettings) error {
 	query := `
-		DELETE FROM users
+		UPDATE users
+		SET feed_title = ?, feed_author = ?, feed_description = ?, feed_changed_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id.String())
+	_, err := c.db.Exec(query, feed.Title, feed.Author, feed.Description, id.String())
 	return err
 }
+
+// DeleteUser deletes the user and everything they own. Stored files aren't
+// touched, so callers delete those first.
+func (c *Client) DeleteUser(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Videos the user made in a workspace belong to the workspace and stay.
+	queries := []string{
+		`DELETE FROM video_tags WHERE video_id IN (SELECT id FROM videos WHERE user_id = ? AND workspace_id IS NULL)`,
+		`DELETE FROM playlist_items WHERE video_id IN (SELECT id FROM videos WHERE user_id = ? AND workspace_id IS NULL)`,
+		`DELETE FROM playlist_items WHERE playlist_id IN (SELECT id FROM playlists WHERE user_id = ?)`,
+		`DELETE FROM playlists WHERE user_id = ?`,
+		`DELETE FROM video_tags WHERE tag_id IN (SELECT id FROM tags WHERE user_id = ?)`,
+		`DELETE FROM tags WHERE user_id = ?`,
+		`DELETE FROM share_sessions WHERE link_id IN (SELECT id FROM share_links WHERE video_id IN (SELECT id FROM videos WHERE user_id = ? AND workspace_id IS NULL))`,
+		`DELETE FROM share_links WHERE video_id IN (SELECT id FROM videos WHERE user_id = ? AND workspace_id IS NULL)`,
+		`DELETE FROM video_invites WHERE video_id IN (SELECT id FROM videos WHERE user_id = ? AND workspace_id IS NULL)`,
+		`DELETE FROM video_invites WHERE invited_by = ? AND accepted_at IS NULL`,
+		`DELETE FROM video_collaborators WHERE video_id IN (SELECT id FROM videos WHERE user_id = ? AND workspace_id IS NULL)`,
+		`DELETE FROM video_collaborators WHERE user_id = ?`,
+		`DELETE FROM videos WHERE user_id = ? AND workspace_id IS NULL`,
+		`DELETE FROM workspace_members WHERE user_id = ?`,
+		`DELETE FROM workspace_invites WHERE invited_by = ? AND accepted_at IS NULL`,
+		`DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)`,
+		`DELETE FROM webhooks WHERE user_id = ?`,
+		`DELETE FROM refresh_tokens WHERE user_id = ?`,
+		`DELETE FROM api_keys WHERE user_id = ?`,
+		`DELETE FROM user_tokens WHERE user_id = ?`,
+		`DELETE FROM totp_credentials WHERE user_id = ?`,
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM user_identities WHERE user_id = ?`,
+		`DELETE FROM users WHERE id = ?`,
+	}
+	for _, query := range queries {
+		_, err = tx.Exec(query, id.String())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
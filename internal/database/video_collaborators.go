@@ -0,0 +1,316 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CollaboratorAccess is what a collaborator may do with a video someone
+// else owns. Viewers can watch it; editors can also change its details,
+// replace its files and move it to the trash.
+type CollaboratorAccess string
+
+const (
+	CollaboratorAccessView CollaboratorAccess = "view"
+	CollaboratorAccessEdit CollaboratorAccess = "edit"
+)
+
+func ParseCollaboratorAccess(s string) (CollaboratorAccess, error) {
+	switch access := CollaboratorAccess(s); access {
+	case CollaboratorAccessView, CollaboratorAccessEdit:
+		return access, nil
+	}
+	return "", fmt.Errorf("unknown collaborator access %q", s)
+}
+
+type VideoCollaborator struct {
+	VideoID   uuid.UUID          `json:"video_id"`
+	UserID    uuid.UUID          `json:"user_id"`
+	Email     string             `json:"email"`
+	Access    CollaboratorAccess `json:"access"`
+	GrantedBy uuid.UUID          `json:"granted_by"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+type SetVideoCollaboratorParams struct {
+	VideoID   uuid.UUID
+	UserID    uuid.UUID
+	Access    CollaboratorAccess
+	GrantedBy uuid.UUID
+}
+
+const videoCollaboratorColumns = `
+	vc.video_id,
+	vc.user_id,
+	u.email,
+	vc.access,
+	vc.granted_by,
+	vc.created_at,
+	vc.updated_at
+`
+
+func scanVideoCollaborator(row rowScanner) (VideoCollaborator, error) {
+	var collaborator VideoCollaborator
+	err := row.Scan(
+		&collaborator.VideoID,
+		&collaborator.UserID,
+		&collaborator.Email,
+		&collaborator.Access,
+		&collaborator.GrantedBy,
+		&collaborator.CreatedAt,
+		&collaborator.UpdatedAt,
+	)
+	return collaborator, err
+}
+
+// SetVideoCollaborator grants the user access to the video, replacing any
+// access they were granted before.
+func (c *Client) SetVideoCollaborator(params SetVideoCollaboratorParams) (VideoCollaborator, error) {
+	query := `
+	INSERT INTO video_collaborators (video_id, user_id, access, granted_by, created_at, updated_at)
+	VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	ON CONFLICT (video_id, user_id) DO UPDATE SET
+		access = excluded.access,
+		granted_by = excluded.granted_by,
+		updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(query, params.VideoID, params.UserID, params.Access, params.GrantedBy)
+	if err != nil {
+		return VideoCollaborator{}, err
+	}
+	return c.GetVideoCollaborator(params.VideoID, params.UserID)
+}
+
+func (c *Client) GetVideoCollaborator(videoID, userID uuid.UUID) (VideoCollaborator, error) {
+	query := `SELECT` + videoCollaboratorColumns + `
+	FROM video_collaborators vc
+	JOIN users u ON u.id = vc.user_id
+	WHERE vc.video_id = ? AND vc.user_id = ?
+	`
+	collaborator, err := scanVideoCollaborator(c.db.QueryRow(query, videoID, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoCollaborator{}, nil
+		}
+		return VideoCollaborator{}, err
+	}
+	return collaborator, nil
+}
+
+func (c *Client) GetVideoCollaborators(videoID uuid.UUID) ([]VideoCollaborator, error) {
+	query := `SELECT` + videoCollaboratorColumns + `
+	FROM video_collaborators vc
+	JOIN users u ON u.id = vc.user_id
+	WHERE vc.video_id = ?
+	ORDER BY vc.created_at
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := []VideoCollaborator{}
+	for rows.Next() {
+		collaborator, err := scanVideoCollaborator(rows)
+		if err != nil {
+			return nil, err
+		}
+		collaborators = append(collaborators, collaborator)
+	}
+	return collaborators, rows.Err()
+}
+
+// GetCollaboratorAccess returns the access the user was granted to the
+// video, or "" if they weren't granted any.
+func (c *Client) GetCollaboratorAccess(videoID, userID uuid.UUID) (CollaboratorAccess, error) {
+	var access CollaboratorAccess
+	err := c.db.QueryRow(`
+	SELECT access FROM video_collaborators WHERE video_id = ? AND user_id = ?
+	`, videoID, userID).Scan(&access)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return access, err
+}
+
+func (c *Client) RemoveVideoCollaborator(videoID, userID uuid.UUID) error {
+	_, err := c.db.Exec(`DELETE FROM video_collaborators WHERE video_id = ? AND user_id = ?`, videoID, userID)
+	return err
+}
+
+// VideoInvite offers access to a video to whoever owns an email address.
+// Collaborators are only ever added through invites, so inviting an address
+// looks the same whether or not it has an account.
+type VideoInvite struct {
+	ID         uuid.UUID          `json:"id"`
+	CreatedAt  time.Time          `json:"created_at"`
+	VideoID    uuid.UUID          `json:"video_id"`
+	Email      string             `json:"email"`
+	Access     CollaboratorAccess `json:"access"`
+	InvitedBy  uuid.UUID          `json:"invited_by"`
+	ExpiresAt  time.Time          `json:"expires_at"`
+	AcceptedAt *time.Time         `json:"accepted_at"`
+	RevokedAt  *time.Time         `json:"revoked_at"`
+}
+
+type CreateVideoInviteParams struct {
+	VideoID   uuid.UUID
+	Email     string
+	Access    CollaboratorAccess
+	InvitedBy uuid.UUID
+	Token     string
+	ExpiresAt time.Time
+}
+
+const videoInviteColumns = `
+	id,
+	created_at,
+	video_id,
+	email,
+	access,
+	invited_by,
+	expires_at,
+	accepted_at,
+	revoked_at
+`
+
+func scanVideoInvite(row rowScanner) (VideoInvite, error) {
+	var invite VideoInvite
+	err := row.Scan(
+		&invite.ID,
+		&invite.CreatedAt,
+		&invite.VideoID,
+		&invite.Email,
+		&invite.Access,
+		&invite.InvitedBy,
+		&invite.ExpiresAt,
+		&invite.AcceptedAt,
+		&invite.RevokedAt,
+	)
+	return invite, err
+}
+
+func (c *Client) CreateVideoInvite(params CreateVideoInviteParams) (VideoInvite, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO video_invites (
+		id,
+		created_at,
+		video_id,
+		email,
+		access,
+		invited_by,
+		token_hash,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.VideoID,
+		params.Email,
+		params.Access,
+		params.InvitedBy,
+		hashToken(params.Token),
+		params.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return VideoInvite{}, err
+	}
+	return c.GetVideoInvite(id)
+}
+
+func (c *Client) GetVideoInvite(id uuid.UUID) (VideoInvite, error) {
+	query := `SELECT` + videoInviteColumns + `FROM video_invites WHERE id = ?`
+	invite, err := scanVideoInvite(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoInvite{}, nil
+	}
+	return invite, err
+}
+
+// GetPendingVideoInvites returns the invites that can still be accepted.
+func (c *Client) GetPendingVideoInvites(videoID uuid.UUID) ([]VideoInvite, error) {
+	query := `SELECT` + videoInviteColumns + `
+	FROM video_invites
+	WHERE video_id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, videoID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []VideoInvite{}
+	for rows.Next() {
+		invite, err := scanVideoInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+func (c *Client) RevokeVideoInvite(id uuid.UUID) error {
+	_, err := c.db.Exec(`
+	UPDATE video_invites SET revoked_at = ? WHERE id = ? AND accepted_at IS NULL AND revoked_at IS NULL
+	`, time.Now().UTC(), id)
+	return err
+}
+
+// AcceptVideoInvite makes the user a collaborator on the video the invite
+// is for, as long as it was sent to their email. A zero VideoInvite is
+// returned if the token is unknown, used, revoked or expired. Users who are
+// already collaborators keep their current access.
+func (c *Client) AcceptVideoInvite(token string, userID uuid.UUID, email string) (VideoInvite, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return VideoInvite{}, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT` + videoInviteColumns + `
+	FROM video_invites
+	WHERE token_hash = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?
+	`
+	invite, err := scanVideoInvite(tx.QueryRow(query, hashToken(token), time.Now().UTC()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoInvite{}, nil
+	}
+	if err != nil {
+		return VideoInvite{}, err
+	}
+	if !strings.EqualFold(invite.Email, email) {
+		return VideoInvite{}, ErrInviteEmailMismatch
+	}
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`UPDATE video_invites SET accepted_at = ? WHERE id = ?`, now, invite.ID)
+	if err != nil {
+		return VideoInvite{}, err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO video_collaborators (video_id, user_id, access, granted_by, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (video_id, user_id) DO NOTHING
+	`, invite.VideoID, userID, invite.Access, invite.InvitedBy, now, now)
+	if err != nil {
+		return VideoInvite{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return VideoInvite{}, err
+	}
+	invite.AcceptedAt = &now
+	return invite, nil
+}
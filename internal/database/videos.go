@@ -3,43 +3,171 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+var (
+	ErrVideoModified           = errors.New("video was modified concurrently")
+	ErrInvalidStatusTransition = errors.New("invalid video status transition")
+)
+
+type VideoStatus string
+
+const (
+	VideoStatusDraft      VideoStatus = "draft"
+	VideoStatusUploading  VideoStatus = "uploading"
+	VideoStatusProcessing VideoStatus = "processing"
+	VideoStatusReady      VideoStatus = "ready"
+	VideoStatusFailed     VideoStatus = "failed"
+)
+
+// videoStatusTransitions lists the statuses each status may move to. Ready
+// and failed videos can go back to uploading when a new file is sent.
+var videoStatusTransitions = map[VideoStatus][]VideoStatus{
+	VideoStatusDraft:      {VideoStatusUploading},
+	VideoStatusUploading:  {VideoStatusProcessing, VideoStatusFailed},
+	VideoStatusProcessing: {VideoStatusReady, VideoStatusFailed},
+	VideoStatusReady:      {VideoStatusUploading},
+	VideoStatusFailed:     {VideoStatusUploading},
+}
+
+func ParseVideoStatus(s string) (VideoStatus, error) {
+	status := VideoStatus(s)
+	if _, ok := videoStatusTransitions[status]; !ok {
+		return "", fmt.Errorf("unknown video status %q", s)
+	}
+	return status, nil
+}
+
+func (s VideoStatus) CanTransitionTo(next VideoStatus) bool {
+	for _, allowed := range videoStatusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// VideoVisibility controls who can fetch a video. Unlisted videos are
+// available to anyone who has the link but aren't listed anywhere public.
+type VideoVisibility string
+
+const (
+	VideoVisibilityPrivate  VideoVisibility = "private"
+	VideoVisibilityUnlisted VideoVisibility = "unlisted"
+	VideoVisibilityPublic   VideoVisibility = "public"
+)
+
+func ParseVideoVisibility(s string) (VideoVisibility, error) {
+	switch visibility := VideoVisibility(s); visibility {
+	case VideoVisibilityPrivate, VideoVisibilityUnlisted, VideoVisibilityPublic:
+		return visibility, nil
+	}
+	return "", fmt.Errorf("unknown video visibility %q", s)
+}
+
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID            uuid.UUID   `json:"id"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+	DeletedAt     *time.Time  `json:"deleted_at"`
+	ThumbnailURL  *string     `json:"thumbnail_url"`
+	VideoURL      *string     `json:"video_url"`
+	Status        VideoStatus `json:"status"`
+	FailureReason *string     `json:"failure_reason"`
+	// AspectRatio is "16:9", "9:16" or "other", and VideoSize is the
+	// stored file's size in bytes. Both are only known once a file has been
+	// uploaded.
+	AspectRatio *string `json:"aspect_ratio"`
+	VideoSize   *int64  `json:"video_size"`
 	CreateVideoParams
 }
 
+type VideoFilter struct {
+	// WorkspaceID lists a workspace's videos instead of the user's
+	// personal ones.
+	WorkspaceID *uuid.UUID
+	// Shared lists the videos others have made the user a collaborator on
+	// instead.
+	Shared       bool
+	Statuses     []VideoStatus
+	Visibilities []VideoVisibility
+	// Tags must already be normalized. Videos match if they carry every
+	// tag, or any of them when MatchAnyTag is set.
+	Tags        []string
+	MatchAnyTag bool
+}
+
+// CreateVideoParams holds what's set when a video is created. Videos
+// without a WorkspaceID are personal to UserID; in a workspace, UserID is
+// just whoever created the video. A video becomes public at PublishAt and
+// private again at UnpublishAt; each is cleared once it has happened.
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Visibility  VideoVisibility `json:"visibility"`
+	PublishAt   *time.Time      `json:"publish_at"`
+	UnpublishAt *time.Time      `json:"unpublish_at"`
+	UserID      uuid.UUID       `json:"user_id"`
+	WorkspaceID *uuid.UUID      `json:"workspace_id"`
 }
 
-func (c *Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+// videoColumns is qualified with the table name so it can be selected
+// from queries that join other tables.
+const videoColumns = `
+		videos.id,
+		videos.created_at,
+		videos.updated_at,
+		videos.deleted_at,
+		videos.title,
+		videos.description,
+		videos.thumbnail_url,
+		videos.video_url,
+		videos.status,
+		videos.failure_reason,
+		videos.aspect_ratio,
+		videos.video_size,
+		videos.visibility,
+		videos.publish_at,
+		videos.unpublish_at,
+		videos.user_id,
+		videos.workspace_id
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.DeletedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.Status,
+		&video.FailureReason,
+		&video.AspectRatio,
+		&video.VideoSize,
+		&video.Visibility,
+		&video.PublishAt,
+		&video.UnpublishAt,
+		&video.UserID,
+		&video.WorkspaceID,
+	)
+	return video, err
+}
 
-	rows, err := c.db.Query(query, userID)
+func (c *Client) queryVideos(query string, args ...any) ([]Video, error) {
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,27 +175,92 @@ func (c *Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	var videos []Video
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
+}
+
+func (c *Client) queryVideo(query string, args ...any) (Video, error) {
+	video, err := scanVideo(c.db.QueryRow(query, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, nil
+		}
+		return Video{}, err
+	}
+	return video, nil
+}
+
+func (c *Client) GetVideos(userID uuid.UUID, filter VideoFilter) ([]Video, error) {
+	conditions := []string{"user_id = ?", "workspace_id IS NULL", "deleted_at IS NULL"}
+	args := []any{userID}
+	if filter.WorkspaceID != nil {
+		conditions = []string{"workspace_id = ?", "deleted_at IS NULL"}
+		args = []any{*filter.WorkspaceID}
+	} else if filter.Shared {
+		conditions = []string{"id IN (SELECT video_id FROM video_collaborators WHERE user_id = ?)", "deleted_at IS NULL"}
+	}
+
+	if len(filter.Statuses) > 0 {
+		placeholders := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			placeholders[i] = "?"
+			args = append(args, status)
+		}
+		conditions = append(conditions, fmt.Sprintf("status IN (%s)", strings.Join(placeholders, ", ")))
+	}
+
+	if len(filter.Visibilities) > 0 {
+		placeholders := make([]string, len(filter.Visibilities))
+		for i, visibility := range filter.Visibilities {
+			placeholders[i] = "?"
+			args = append(args, visibility)
+		}
+		conditions = append(conditions, fmt.Sprintf("visibility IN (%s)", strings.Join(placeholders, ", ")))
+	}
+
+	if len(filter.Tags) > 0 {
+		placeholders := make([]string, len(filter.Tags))
+		for i, tag := range filter.Tags {
+			placeholders[i] = "?"
+			args = append(args, tag)
+		}
+		tagQuery := fmt.Sprintf(`id IN (
+		SELECT vt.video_id
+		FROM video_tags vt
+		JOIN tags t ON t.id = vt.tag_id
+		WHERE t.name IN (%s)
+		GROUP BY vt.video_id`, strings.Join(placeholders, ", "))
+		if !filter.MatchAnyTag {
+			tagQuery += `
+		HAVING COUNT(DISTINCT t.id) = ?`
+			args = append(args, len(filter.Tags))
+		}
+		conditions = append(conditions, tagQuery+")")
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(query, args...)
 }
 
+// CreateVideo creates a draft video. It's private unless params asks for
+// another visibility.
 func (c *Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	id := uuid.New()
+	visibility := params.Visibility
+	if visibility == "" {
+		visibility = VideoVisibilityPrivate
+	}
 	query := `
 	INSERT INTO videos (
 		id,
@@ -75,10 +268,26 @@ func (c *Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		status,
+		visibility,
+		publish_at,
+		unpublish_at,
+		user_id,
+		workspace_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.Title,
+		params.Description,
+		VideoStatusDraft,
+		visibility,
+		utcTime(params.PublishAt),
+		utcTime(params.UnpublishAt),
+		params.UserID,
+		params.WorkspaceID,
+	)
 	if err != nil {
 		return Video{}, err
 	}
@@ -86,70 +295,411 @@ func (c *Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	return c.GetVideo(id)
 }
 
+// GetVideo returns the video with the given ID unless it has been moved to
+// the trash. A zero Video is returned when no such video exists.
 func (c *Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE id = ?
+	WHERE id = ? AND deleted_at IS NULL
 	`
+	return c.queryVideo(query, id)
+}
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+// GetVideoByThumbnailURL returns the video, trashed or not, whose thumbnail
+// is stored at url.
+func (c *Client) GetVideoByThumbnailURL(url string) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE thumbnail_url = ?
+	`
+	return c.queryVideo(query, url)
+}
+
+func (c *Client) GetTrashedVideo(id uuid.UUID) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id = ? AND deleted_at IS NOT NULL
+	`
+	return c.queryVideo(query, id)
+}
+
+// GetTrashedVideos returns the user's personal videos in the trash.
+func (c *Client) GetTrashedVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND workspace_id IS NULL AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
+
+func (c *Client) GetTrashedWorkspaceVideos(workspaceID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE workspace_id = ? AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC
+	`
+	return c.queryVideos(query, workspaceID)
+}
+
+// GetVideosTrashedBefore returns every trashed video, across all users,
+// that was moved to the trash before cutoff.
+func (c *Client) GetVideosTrashedBefore(cutoff time.Time) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NOT NULL AND deleted_at < ?
+	`
+	return c.queryVideos(query, cutoff.UTC())
+}
+
+func (c *Client) UpdateVideo(video Video) error {
+	return updateVideo(c.db, video)
+}
+
+// UpdateVideoIfUnmodified updates the video only if its stored updated_at
+// still equals lastUpdatedAt, returning ErrVideoModified otherwise.
+func (c *Client) UpdateVideoIfUnmodified(video Video, lastUpdatedAt time.Time) error {
+	tx, err := c.db.Begin()
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return Video{}, nil
-		}
-		return Video{}, err
+		return err
 	}
+	defer tx.Rollback()
 
-	return video, nil
+	var updatedAt time.Time
+	err = tx.QueryRow(`SELECT updated_at FROM videos WHERE id = ?`, video.ID).Scan(&updatedAt)
+	if err != nil {
+		return err
+	}
+	if !updatedAt.Equal(lastUpdatedAt) {
+		return ErrVideoModified
+	}
+
+	err = updateVideo(tx, video)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (c *Client) UpdateVideo(video Video) error {
+func updateVideo(ex execer, video Video) error {
 	query := `
 	UPDATE videos
 	SET
+		updated_at = ?,
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
+		aspect_ratio = ?,
+		video_size = ?,
+		visibility = ?,
+		publish_at = ?,
+		unpublish_at = ?,
 		user_id = ?
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
+	updatedAt := video.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = time.Now().UTC()
+	}
+
+	_, err := ex.Exec(
 		query,
+		updatedAt,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
+		video.AspectRatio,
+		video.VideoSize,
+		video.Visibility,
+		utcTime(video.PublishAt),
+		utcTime(video.UnpublishAt),
 		video.UserID,
 		video.ID,
 	)
 	return err
 }
 
+// SetVideoStatus moves a video to a new status, returning
+// ErrInvalidStatusTransition if the move isn't allowed from its current
+// status. The failure reason is kept only for failed videos.
+func (c *Client) SetVideoStatus(id uuid.UUID, status VideoStatus, failureReason string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current VideoStatus
+	err = tx.QueryRow(`SELECT status FROM videos WHERE id = ?`, id).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if !current.CanTransitionTo(status) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidStatusTransition, current, status)
+	}
+
+	var reason *string
+	if status == VideoStatusFailed {
+		reason = &failureReason
+	}
+
+	now := time.Now().UTC()
+	query := `
+	UPDATE videos
+	SET status = ?, failure_reason = ?, updated_at = ?, status_changed_at = ?
+	WHERE id = ?
+	`
+	_, err = tx.Exec(query, status, reason, now, now, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type CompleteVideoUploadParams struct {
+	VideoURL    string
+	AspectRatio string
+	VideoSize   int64
+}
+
+// CompleteVideoUpload attaches a newly stored file to a processing video
+// and makes it ready, returning the URL of the file it replaced, if any.
+// It fails with ErrInvalidStatusTransition if the video stopped processing
+// in the meantime, in which case the video still points at its old file.
+func (c *Client) CompleteVideoUpload(id uuid.UUID, params CompleteVideoUploadParams) (*string, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var current VideoStatus
+	var previousURL *string
+	err = tx.QueryRow(`SELECT status, video_url FROM videos WHERE id = ?`, id).Scan(&current, &previousURL)
+	if err != nil {
+		return nil, err
+	}
+	if !current.CanTransitionTo(VideoStatusReady) {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidStatusTransition, current, VideoStatusReady)
+	}
+
+	now := time.Now().UTC()
+	query := `
+	UPDATE videos
+	SET video_url = ?, aspect_ratio = ?, video_size = ?, status = ?, failure_reason = NULL,
+		updated_at = ?, status_changed_at = ?
+	WHERE id = ?
+	`
+	_, err = tx.Exec(query, params.VideoURL, params.AspectRatio, params.VideoSize, VideoStatusReady, now, now, id)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return previousURL, nil
+}
+
+// FailStalledUploads marks videos that have been uploading or processing
+// since before the given time as failed, returning the videos it changed.
+// Their upload was abandoned or the server stopped while handling it, and
+// until they fail they can't be uploaded again.
+func (c *Client) FailStalledUploads(before time.Time, reason string) ([]Video, error) {
+	before = before.UTC()
+	stalled, err := c.queryVideos(`
+	SELECT`+videoColumns+`
+	FROM videos
+	WHERE status IN (?, ?) AND status_changed_at < ?
+	`, VideoStatusUploading, VideoStatusProcessing, before)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each update rechecks the status, so an upload that moved on since
+	// the query is left alone.
+	var failed []Video
+	now := time.Now().UTC()
+	for _, video := range stalled {
+		result, err := c.db.Exec(`
+		UPDATE videos
+		SET status = ?, failure_reason = ?, updated_at = ?, status_changed_at = ?
+		WHERE id = ? AND status = ? AND status_changed_at < ?
+		`, VideoStatusFailed, reason, now, now, video.ID, video.Status, before)
+		if err != nil {
+			return nil, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			continue
+		}
+		video.Status = VideoStatusFailed
+		video.FailureReason = &reason
+		video.UpdatedAt = now
+		failed = append(failed, video)
+	}
+	return failed, nil
+}
+
+func (c *Client) TrashVideo(id uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET deleted_at = ?
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), id)
+	return err
+}
+
+func (c *Client) RestoreVideo(id uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET deleted_at = NULL, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), id)
+	return err
+}
+
 func (c *Client) DeleteVideo(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM video_tags WHERE video_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM playlist_items WHERE video_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM share_sessions WHERE link_id IN (SELECT id FROM share_links WHERE video_id = ?)`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM share_links WHERE video_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM video_invites WHERE video_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM video_collaborators WHERE video_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
-	return err
+	_, err = tx.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PublishDueVideos makes every video whose publish_at has passed public,
+// returning the videos it changed.
+func (c *Client) PublishDueVideos(now time.Time) ([]Video, error) {
+	return c.applyDueSchedule("publish_at", VideoVisibilityPublic, now)
+}
+
+// UnpublishDueVideos makes every video whose unpublish_at has passed
+// private, returning the videos it changed.
+func (c *Client) UnpublishDueVideos(now time.Time) ([]Video, error) {
+	return c.applyDueSchedule("unpublish_at", VideoVisibilityPrivate, now)
+}
+
+// applyDueSchedule sets the visibility of videos whose schedule column has
+// passed and clears the column, so each change happens once even if
+// several schedulers race. Videos in the trash wait until they're restored.
+func (c *Client) applyDueSchedule(column string, visibility VideoVisibility, now time.Time) ([]Video, error) {
+	now = now.UTC()
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+	SELECT`+videoColumns+`
+	FROM videos
+	WHERE `+column+` IS NOT NULL AND `+column+` <= ? AND deleted_at IS NULL
+	ORDER BY `+column, now)
+	if err != nil {
+		return nil, err
+	}
+	var due []Video
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, video)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var changed []Video
+	for _, video := range due {
+		result, err := tx.Exec(`
+		UPDATE videos
+		SET visibility = ?, `+column+` = NULL, updated_at = ?
+		WHERE id = ? AND `+column+` IS NOT NULL AND `+column+` <= ?
+		`, visibility, now, video.ID, now)
+		if err != nil {
+			return nil, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			continue
+		}
+
+		video.Visibility = visibility
+		video.UpdatedAt = now
+		if column == "publish_at" {
+			video.PublishAt = nil
+		} else {
+			video.UnpublishAt = nil
+		}
+		changed = append(changed, video)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
 }
@@ -0,0 +1,410 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook is an endpoint a user registered to hear about events on their
+// videos. The secret signs every delivery, so unlike tokens it has to be
+// stored as is.
+type Webhook struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	UserID      uuid.UUID `json:"user_id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Events      []string  `json:"events"`
+	Secret      string    `json:"-"`
+}
+
+// Subscribed reports whether the webhook wants to hear about event.
+func (w Webhook) Subscribed(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateWebhookParams struct {
+	UserID      uuid.UUID
+	URL         string
+	Description string
+	Events      []string
+	Secret      string
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event on its way to one webhook. Pending
+// deliveries form the outbox the dispatcher works through; finished ones
+// are kept as the delivery log. EventID is shared by every delivery of the
+// same event, including redeliveries, so receivers can deduplicate.
+type WebhookDelivery struct {
+	ID             uuid.UUID             `json:"id"`
+	CreatedAt      time.Time             `json:"created_at"`
+	WebhookID      uuid.UUID             `json:"webhook_id"`
+	EventID        uuid.UUID             `json:"event_id"`
+	Event          string                `json:"event"`
+	Payload        string                `json:"payload"`
+	Status         WebhookDeliveryStatus `json:"status"`
+	Attempts       int                   `json:"attempts"`
+	NextAttemptAt  *time.Time            `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time            `json:"last_attempt_at"`
+	ResponseStatus *int                  `json:"response_status"`
+	ResponseBody   string                `json:"response_body"`
+	LastError      string                `json:"last_error"`
+	DeliveredAt    *time.Time            `json:"delivered_at"`
+	RedeliveryOf   *uuid.UUID            `json:"redelivery_of"`
+}
+
+type CreateWebhookDeliveryParams struct {
+	WebhookID    uuid.UUID
+	EventID      uuid.UUID
+	Event        string
+	Payload      string
+	RedeliveryOf *uuid.UUID
+}
+
+// WebhookAttempt is the outcome of sending a delivery once. ResponseStatus
+// is nil when no response came back at all.
+type WebhookAttempt struct {
+	At             time.Time
+	ResponseStatus *int
+	ResponseBody   string
+	Error          string
+}
+
+const webhookColumns = `
+	id,
+	created_at,
+	updated_at,
+	user_id,
+	url,
+	description,
+	events,
+	secret
+`
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var webhook Webhook
+	var events string
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.UpdatedAt,
+		&webhook.UserID,
+		&webhook.URL,
+		&webhook.Description,
+		&events,
+		&webhook.Secret,
+	)
+	webhook.Events = strings.Fields(events)
+	return webhook, err
+}
+
+func (c *Client) CreateWebhook(params CreateWebhookParams) (Webhook, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO webhooks (
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		url,
+		description,
+		events,
+		secret
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.UserID,
+		params.URL,
+		params.Description,
+		strings.Join(params.Events, " "),
+		params.Secret,
+	)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return c.GetWebhook(id)
+}
+
+func (c *Client) GetWebhook(id uuid.UUID) (Webhook, error) {
+	query := `SELECT` + webhookColumns + `FROM webhooks WHERE id = ?`
+	webhook, err := scanWebhook(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Webhook{}, nil
+		}
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (c *Client) GetWebhooks(userID uuid.UUID) ([]Webhook, error) {
+	query := `SELECT` + webhookColumns + `FROM webhooks WHERE user_id = ? ORDER BY created_at`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook deletes the webhook along with its outbox and delivery log.
+func (c *Client) DeleteWebhook(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const webhookDeliveryColumns = `
+	id,
+	created_at,
+	webhook_id,
+	event_id,
+	event,
+	payload,
+	status,
+	attempts,
+	next_attempt_at,
+	last_attempt_at,
+	response_status,
+	response_body,
+	last_error,
+	delivered_at,
+	redelivery_of
+`
+
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.CreatedAt,
+		&delivery.WebhookID,
+		&delivery.EventID,
+		&delivery.Event,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastAttemptAt,
+		&delivery.ResponseStatus,
+		&delivery.ResponseBody,
+		&delivery.LastError,
+		&delivery.DeliveredAt,
+		&delivery.RedeliveryOf,
+	)
+	return delivery, err
+}
+
+// CreateWebhookDelivery adds a delivery to the outbox, due right away.
+func (c *Client) CreateWebhookDelivery(params CreateWebhookDeliveryParams) (WebhookDelivery, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO webhook_deliveries (
+		id,
+		created_at,
+		webhook_id,
+		event_id,
+		event,
+		payload,
+		status,
+		next_attempt_at,
+		redelivery_of
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	now := time.Now().UTC()
+	_, err := c.db.Exec(
+		query,
+		id,
+		now,
+		params.WebhookID,
+		params.EventID,
+		params.Event,
+		params.Payload,
+		WebhookDeliveryPending,
+		now,
+		params.RedeliveryOf,
+	)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	return c.GetWebhookDelivery(id)
+}
+
+func (c *Client) GetWebhookDelivery(id uuid.UUID) (WebhookDelivery, error) {
+	query := `SELECT` + webhookDeliveryColumns + `FROM webhook_deliveries WHERE id = ?`
+	delivery, err := scanWebhookDelivery(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return WebhookDelivery{}, nil
+		}
+		return WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+// GetWebhookDeliveries returns the webhook's most recent deliveries first.
+func (c *Client) GetWebhookDeliveries(webhookID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	query := `SELECT` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE webhook_id = ?
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+	return c.queryWebhookDeliveries(c.db, query, webhookID, limit)
+}
+
+// ClaimDueWebhookDeliveries takes up to limit pending deliveries whose
+// next attempt is due and pushes that attempt back by lease, so another
+// dispatcher won't send them at the same time. If the claimer dies before
+// recording the outcome, the delivery is retried once the lease runs out.
+func (c *Client) ClaimDueWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	now = now.UTC()
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE status = ? AND next_attempt_at <= ?
+	ORDER BY next_attempt_at
+	LIMIT ?
+	`
+	due, err := c.queryWebhookDeliveries(tx, query, WebhookDeliveryPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	leaseUntil := now.Add(lease)
+	var claimed []WebhookDelivery
+	for _, delivery := range due {
+		result, err := tx.Exec(`
+		UPDATE webhook_deliveries
+		SET next_attempt_at = ?
+		WHERE id = ? AND status = ? AND next_attempt_at <= ?
+		`, leaseUntil, delivery.ID, WebhookDeliveryPending, now)
+		if err != nil {
+			return nil, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n == 1 {
+			delivery.NextAttemptAt = &leaseUntil
+			claimed = append(claimed, delivery)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// RecordWebhookAttempt stores the outcome of sending a delivery. A
+// successful attempt finishes the delivery; otherwise it's retried at
+// retryAt, or marked failed for good when retryAt is nil.
+func (c *Client) RecordWebhookAttempt(id uuid.UUID, attempt WebhookAttempt, succeeded bool, retryAt *time.Time) error {
+	at := attempt.At.UTC()
+	status := WebhookDeliveryFailed
+	var deliveredAt *time.Time
+	switch {
+	case succeeded:
+		status = WebhookDeliverySucceeded
+		deliveredAt = &at
+		retryAt = nil
+	case retryAt != nil:
+		status = WebhookDeliveryPending
+	}
+
+	query := `
+	UPDATE webhook_deliveries
+	SET
+		status = ?,
+		attempts = attempts + 1,
+		next_attempt_at = ?,
+		last_attempt_at = ?,
+		response_status = ?,
+		response_body = ?,
+		last_error = ?,
+		delivered_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(
+		query,
+		status,
+		utcTime(retryAt),
+		at,
+		attempt.ResponseStatus,
+		attempt.ResponseBody,
+		attempt.Error,
+		deliveredAt,
+		id,
+	)
+	return err
+}
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func (c *Client) queryWebhookDeliveries(q querier, query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,465 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrLastWorkspaceOwner  = errors.New("workspace must keep at least one owner")
+	ErrWorkspaceNotEmpty   = errors.New("workspace still has videos")
+	ErrInviteEmailMismatch = errors.New("invite was sent to a different email")
+)
+
+// WorkspaceRole is what a member may do in a workspace. Viewers can watch
+// its videos, editors can also upload and change them, and owners manage
+// the members.
+type WorkspaceRole string
+
+const (
+	WorkspaceRoleViewer WorkspaceRole = "viewer"
+	WorkspaceRoleEditor WorkspaceRole = "editor"
+	WorkspaceRoleOwner  WorkspaceRole = "owner"
+)
+
+var workspaceRoleRanks = map[WorkspaceRole]int{
+	WorkspaceRoleViewer: 0,
+	WorkspaceRoleEditor: 1,
+	WorkspaceRoleOwner:  2,
+}
+
+func ParseWorkspaceRole(s string) (WorkspaceRole, error) {
+	role := WorkspaceRole(s)
+	if _, ok := workspaceRoleRanks[role]; !ok {
+		return "", fmt.Errorf("unknown workspace role %q", s)
+	}
+	return role, nil
+}
+
+// AtLeast reports whether r grants everything min does. The empty role of
+// a non-member grants nothing.
+func (r WorkspaceRole) AtLeast(min WorkspaceRole) bool {
+	rank, ok := workspaceRoleRanks[r]
+	return ok && rank >= workspaceRoleRanks[min]
+}
+
+type Workspace struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name"`
+}
+
+// WorkspaceMembership is a workspace as seen by one of its members.
+type WorkspaceMembership struct {
+	Workspace
+	Role WorkspaceRole `json:"role"`
+}
+
+type WorkspaceMember struct {
+	UserID   uuid.UUID     `json:"user_id"`
+	Email    string        `json:"email"`
+	Role     WorkspaceRole `json:"role"`
+	JoinedAt time.Time     `json:"joined_at"`
+}
+
+type WorkspaceInvite struct {
+	ID          uuid.UUID     `json:"id"`
+	CreatedAt   time.Time     `json:"created_at"`
+	WorkspaceID uuid.UUID     `json:"workspace_id"`
+	Email       string        `json:"email"`
+	Role        WorkspaceRole `json:"role"`
+	InvitedBy   uuid.UUID     `json:"invited_by"`
+	ExpiresAt   time.Time     `json:"expires_at"`
+	AcceptedAt  *time.Time    `json:"accepted_at"`
+	RevokedAt   *time.Time    `json:"revoked_at"`
+}
+
+type CreateWorkspaceInviteParams struct {
+	WorkspaceID uuid.UUID
+	Email       string
+	Role        WorkspaceRole
+	InvitedBy   uuid.UUID
+	Token       string
+	ExpiresAt   time.Time
+}
+
+// CreateWorkspace creates a workspace with ownerID as its first owner.
+func (c *Client) CreateWorkspace(name string, ownerID uuid.UUID) (Workspace, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Workspace{}, err
+	}
+	defer tx.Rollback()
+
+	id := uuid.New()
+	_, err = tx.Exec(`
+	INSERT INTO workspaces (id, created_at, updated_at, name)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?)
+	`, id, name)
+	if err != nil {
+		return Workspace{}, err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`, id, ownerID, WorkspaceRoleOwner)
+	if err != nil {
+		return Workspace{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Workspace{}, err
+	}
+	return c.GetWorkspace(id)
+}
+
+func (c *Client) GetWorkspace(id uuid.UUID) (Workspace, error) {
+	var workspace Workspace
+	err := c.db.QueryRow(`
+	SELECT id, created_at, updated_at, name
+	FROM workspaces
+	WHERE id = ?
+	`, id).Scan(&workspace.ID, &workspace.CreatedAt, &workspace.UpdatedAt, &workspace.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Workspace{}, nil
+	}
+	return workspace, err
+}
+
+func (c *Client) GetWorkspacesForUser(userID uuid.UUID) ([]WorkspaceMembership, error) {
+	rows, err := c.db.Query(`
+	SELECT w.id, w.created_at, w.updated_at, w.name, m.role
+	FROM workspaces w
+	JOIN workspace_members m ON m.workspace_id = w.id
+	WHERE m.user_id = ?
+	ORDER BY w.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []WorkspaceMembership{}
+	for rows.Next() {
+		var w WorkspaceMembership
+		err := rows.Scan(&w.ID, &w.CreatedAt, &w.UpdatedAt, &w.Name, &w.Role)
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, w)
+	}
+	return workspaces, rows.Err()
+}
+
+// GetWorkspaceRole returns the user's role in the workspace, or an empty
+// role if they aren't a member.
+func (c *Client) GetWorkspaceRole(workspaceID, userID uuid.UUID) (WorkspaceRole, error) {
+	var role WorkspaceRole
+	err := c.db.QueryRow(`
+	SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?
+	`, workspaceID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+func (c *Client) GetWorkspaceMembers(workspaceID uuid.UUID) ([]WorkspaceMember, error) {
+	rows, err := c.db.Query(`
+	SELECT m.user_id, u.email, m.role, m.created_at
+	FROM workspace_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.workspace_id = ?
+	ORDER BY m.created_at
+	`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []WorkspaceMember{}
+	for rows.Next() {
+		var m WorkspaceMember
+		err := rows.Scan(&m.UserID, &m.Email, &m.Role, &m.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// SetWorkspaceMemberRole changes a member's role, returning
+// ErrLastWorkspaceOwner rather than demoting the only owner.
+func (c *Client) SetWorkspaceMemberRole(workspaceID, userID uuid.UUID, role WorkspaceRole) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if role != WorkspaceRoleOwner {
+		err = checkNotLastOwner(tx, workspaceID, userID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`
+	UPDATE workspace_members SET role = ? WHERE workspace_id = ? AND user_id = ?
+	`, role, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveWorkspaceMember takes the user out of the workspace, returning
+// ErrLastWorkspaceOwner rather than leaving it without an owner.
+func (c *Client) RemoveWorkspaceMember(workspaceID, userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkNotLastOwner(tx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?`, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func checkNotLastOwner(tx *sql.Tx, workspaceID, userID uuid.UUID) error {
+	var role WorkspaceRole
+	err := tx.QueryRow(`
+	SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?
+	`, workspaceID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if role != WorkspaceRoleOwner {
+		return nil
+	}
+
+	var owners int
+	err = tx.QueryRow(`
+	SELECT COUNT(*) FROM workspace_members WHERE workspace_id = ? AND role = ?
+	`, workspaceID, WorkspaceRoleOwner).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastWorkspaceOwner
+	}
+	return nil
+}
+
+// CountSoleOwnedWorkspaces counts the workspaces that would be left
+// without an owner if the user went away.
+func (c *Client) CountSoleOwnedWorkspaces(userID uuid.UUID) (int, error) {
+	var count int
+	err := c.db.QueryRow(`
+	SELECT COUNT(*)
+	FROM workspace_members m
+	WHERE m.user_id = ? AND m.role = ?
+		AND NOT EXISTS (
+			SELECT 1 FROM workspace_members o
+			WHERE o.workspace_id = m.workspace_id AND o.role = ? AND o.user_id != m.user_id
+		)
+	`, userID, WorkspaceRoleOwner, WorkspaceRoleOwner).Scan(&count)
+	return count, err
+}
+
+// DeleteWorkspace deletes an empty workspace along with its members and
+// invites. Workspaces that still hold videos, including trashed ones,
+// return ErrWorkspaceNotEmpty, since their files would be left behind.
+func (c *Client) DeleteWorkspace(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var videos int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM videos WHERE workspace_id = ?`, id).Scan(&videos)
+	if err != nil {
+		return err
+	}
+	if videos > 0 {
+		return ErrWorkspaceNotEmpty
+	}
+
+	queries := []string{
+		`DELETE FROM workspace_invites WHERE workspace_id = ?`,
+		`DELETE FROM workspace_members WHERE workspace_id = ?`,
+		`DELETE FROM workspaces WHERE id = ?`,
+	}
+	for _, query := range queries {
+		_, err = tx.Exec(query, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const workspaceInviteColumns = `
+	id,
+	created_at,
+	workspace_id,
+	email,
+	role,
+	invited_by,
+	expires_at,
+	accepted_at,
+	revoked_at
+`
+
+func scanWorkspaceInvite(row rowScanner) (WorkspaceInvite, error) {
+	var invite WorkspaceInvite
+	err := row.Scan(
+		&invite.ID,
+		&invite.CreatedAt,
+		&invite.WorkspaceID,
+		&invite.Email,
+		&invite.Role,
+		&invite.InvitedBy,
+		&invite.ExpiresAt,
+		&invite.AcceptedAt,
+		&invite.RevokedAt,
+	)
+	return invite, err
+}
+
+func (c *Client) CreateWorkspaceInvite(params CreateWorkspaceInviteParams) (WorkspaceInvite, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO workspace_invites (
+		id,
+		created_at,
+		workspace_id,
+		email,
+		role,
+		invited_by,
+		token_hash,
+		expires_at
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		id,
+		params.WorkspaceID,
+		params.Email,
+		params.Role,
+		params.InvitedBy,
+		hashToken(params.Token),
+		params.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return WorkspaceInvite{}, err
+	}
+	return c.GetWorkspaceInvite(id)
+}
+
+func (c *Client) GetWorkspaceInvite(id uuid.UUID) (WorkspaceInvite, error) {
+	query := `SELECT` + workspaceInviteColumns + `FROM workspace_invites WHERE id = ?`
+	invite, err := scanWorkspaceInvite(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return WorkspaceInvite{}, nil
+	}
+	return invite, err
+}
+
+// GetPendingWorkspaceInvites returns the invites that can still be
+// accepted.
+func (c *Client) GetPendingWorkspaceInvites(workspaceID uuid.UUID) ([]WorkspaceInvite, error) {
+	query := `SELECT` + workspaceInviteColumns + `
+	FROM workspace_invites
+	WHERE workspace_id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, workspaceID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []WorkspaceInvite{}
+	for rows.Next() {
+		invite, err := scanWorkspaceInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+func (c *Client) RevokeWorkspaceInvite(id uuid.UUID) error {
+	_, err := c.db.Exec(`
+	UPDATE workspace_invites SET revoked_at = ? WHERE id = ? AND accepted_at IS NULL AND revoked_at IS NULL
+	`, time.Now().UTC(), id)
+	return err
+}
+
+// AcceptWorkspaceInvite adds the user to the workspace the invite is for,
+// as long as it was sent to their email. A zero WorkspaceInvite is returned
+// if the token is unknown, used, revoked or expired. Users who are already
+// members keep their current role.
+func (c *Client) AcceptWorkspaceInvite(token string, userID uuid.UUID, email string) (WorkspaceInvite, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return WorkspaceInvite{}, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT` + workspaceInviteColumns + `
+	FROM workspace_invites
+	WHERE token_hash = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?
+	`
+	invite, err := scanWorkspaceInvite(tx.QueryRow(query, hashToken(token), time.Now().UTC()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return WorkspaceInvite{}, nil
+	}
+	if err != nil {
+		return WorkspaceInvite{}, err
+	}
+	if !strings.EqualFold(invite.Email, email) {
+		return WorkspaceInvite{}, ErrInviteEmailMismatch
+	}
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`UPDATE workspace_invites SET accepted_at = ? WHERE id = ?`, now, invite.ID)
+	if err != nil {
+		return WorkspaceInvite{}, err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT (workspace_id, user_id) DO NOTHING
+	`, invite.WorkspaceID, userID, invite.Role, now)
+	if err != nil {
+		return WorkspaceInvite{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return WorkspaceInvite{}, err
+	}
+	invite.AcceptedAt = &now
+	return invite, nil
+}
@@ -0,0 +1,150 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/andycostintoma/tubely/internal/oidc"
+	"github.com/andycostintoma/tubely/internal/oidc/oidctest"
+)
+
+const redirectURL = "https://tubely.example/app/"
+
+func newProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+	mock, srv, err := oidctest.NewServer("tubely", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	return mock, oidc.NewProvider(oidc.Config{
+		Issuer:      mock.Issuer,
+		ClientID:    "tubely",
+		RedirectURL: redirectURL,
+	})
+}
+
+// authorize has the mock provider approve email and returns the code.
+func authorize(t *testing.T, p *oidc.Provider, email, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), "state", nonce, oidc.S256Challenge(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL + "&login_hint=" + url.QueryEscape(email))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := callback.Query().Get("code")
+	if code == "" {
+		t.Fatalf("provider redirected to %s without a code", callback)
+	}
+	return code
+}
+
+func TestExchange(t *testing.T) {
+	_, p := newProvider(t)
+	ctx := context.Background()
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := authorize(t, p, "user@example.com", "nonce", verifier)
+	claims, err := p.Exchange(ctx, code, verifier, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != "user@example.com" || !bool(claims.EmailVerified) || claims.Subject == "" {
+		t.Errorf("got claims %+v", claims)
+	}
+
+	// Codes are single use.
+	if _, err := p.Exchange(ctx, code, verifier, "nonce"); err == nil {
+		t.Error("a code was redeemed twice")
+	}
+}
+
+func TestExchangeChecksPKCE(t *testing.T) {
+	_, p := newProvider(t)
+	ctx := context.Background()
+
+	// A stolen code is useless without the verifier it was requested with.
+	code := authorize(t, p, "user@example.com", "nonce", "the-real-verifier")
+	if _, err := p.Exchange(ctx, code, "another-verifier", "nonce"); err == nil {
+		t.Error("code was redeemed with the wrong PKCE verifier")
+	}
+}
+
+func TestExchangeChecksNonce(t *testing.T) {
+	_, p := newProvider(t)
+
+	code := authorize(t, p, "user@example.com", "nonce", "verifier")
+	if _, err := p.Exchange(context.Background(), code, "verifier", "another-nonce"); err == nil {
+		t.Error("ID token was accepted for another login's nonce")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	mock, p := newProvider(t)
+	ctx := context.Background()
+
+	token, err := mock.IDToken("user@example.com", false, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.VerifyIDToken(ctx, token, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.EmailVerified {
+		t.Error("email_verified=false came back verified")
+	}
+
+	if _, err := p.VerifyIDToken(ctx, token, "another-nonce"); err == nil {
+		t.Error("token was accepted with the wrong nonce")
+	}
+
+	// Tokens issued to another client of the same provider are refused.
+	other := oidc.NewProvider(oidc.Config{Issuer: mock.Issuer, ClientID: "other", RedirectURL: redirectURL})
+	if _, err := other.VerifyIDToken(ctx, token, "nonce"); err == nil {
+		t.Error("token was accepted by a client it wasn't issued to")
+	}
+
+	// And so are tokens signed by another provider.
+	impostor, srv, err := oidctest.NewServer("tubely", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	forged, err := impostor.IDToken("user@example.com", true, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.VerifyIDToken(ctx, forged, "nonce"); err == nil {
+		t.Error("token from another provider was accepted")
+	}
+	if _, err := p.VerifyIDToken(ctx, token[:len(token)-4]+"AAAA", "nonce"); err == nil {
+		t.Error("token with a tampered signature was accepted")
+	}
+}
+
+func TestDiscoveryChecksIssuer(t *testing.T) {
+	mock, _ := newProvider(t)
+
+	// A discovery document must name the issuer it was fetched from.
+	p := oidc.NewProvider(oidc.Config{Issuer: mock.Issuer + "/", ClientID: "tubely", RedirectURL: redirectURL})
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", oidc.S256Challenge("verifier")); err == nil {
+		t.Error("provider with a mismatched issuer was accepted")
+	}
+}
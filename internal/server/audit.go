@@ -0,0 +1,25 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/google/uuid"
+)
+
+// audit records a privileged action taken by actorID. A failure to write the
+// entry is logged rather than returned, since the action has already happened.
+func (cfg *apiConfig) audit(r *http.Request, actorID uuid.UUID, action, targetType, targetID, details string) {
+	err := cfg.db.CreateAuditLogEntry(database.CreateAuditLogEntryParams{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+		IPAddress:  cfg.clientIP(r),
+	})
+	if err != nil {
+		log.Printf("Couldn't write audit log entry %s %s/%s: %v", action, targetType, targetID, err)
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/andycostintoma/tubely/internal/auth"
+	"net/http"
+	"time"
+)
+
+// Browsers can keep their session in cookies instead of handing tokens to
+// JavaScript. The access and refresh tokens are HttpOnly so a script
+// injected into the page can't read them; the CSRF token is readable on
+// purpose, since the app copies it into the X-CSRF-Token header to prove
+// the request came from a page on our origin (double-submit).
+const (
+	accessTokenCookie  = "tubely_access"
+	refreshTokenCookie = "tubely_refresh"
+	csrfTokenCookie    = "tubely_csrf"
+	csrfTokenHeader    = "X-CSRF-Token"
+
+	// cookieAccessTokenDuration is short because the app refreshes
+	// silently whenever it gets a 401.
+	cookieAccessTokenDuration = 15 * time.Minute
+)
+
+// wantsCookieSession reports whether a new session should be delivered in
+// cookies: either the client asked with ?session=cookie, or it's already
+// using a cookie session.
+func wantsCookieSession(r *http.Request) bool {
+	return r.URL.Query().Get("session") == "cookie" || authFromContext(r.Context()).CookieSession
+}
+
+// setSessionCookies stores a session's tokens in cookies and returns the
+// CSRF token, which is kept across refreshes so requests already in flight
+// don't fail.
+func setSessionCookies(w http.ResponseWriter, r *http.Request, accessToken, refreshToken string) (string, error) {
+	csrfToken := ""
+	if cookie, err := r.Cookie(csrfTokenCookie); err == nil && cookie.Value != "" {
+		csrfToken = cookie.Value
+	} else {
+		b := make([]byte, 32)
+		_, err := rand.Read(b)
+		if err != nil {
+			return "", err
+		}
+		csrfToken = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	maxAge := int(refreshTokenDuration.Seconds())
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookie,
+		Value:    accessToken,
+		Path:     "/",
+		MaxAge:   int(cookieAccessTokenDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	// The refresh token is only needed by /api/refresh and /api/revoke.
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    refreshToken,
+		Path:     "/api/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfTokenCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return csrfToken, nil
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, cookie := range []struct {
+		name, path string
+		httpOnly   bool
+	}{
+		{accessTokenCookie, "/", true},
+		{refreshTokenCookie, "/api/", true},
+		{csrfTokenCookie, "/", false},
+	} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     cookie.name,
+			Value:    "",
+			Path:     cookie.path,
+			MaxAge:   -1,
+			HttpOnly: cookie.httpOnly,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// checkCSRF guards requests authenticated by cookie, which the browser
+// attaches no matter which site triggered them. Safe methods don't change
+// anything, so they're let through.
+func checkCSRF(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+
+	cookie, err := r.Cookie(csrfTokenCookie)
+	if err != nil || cookie.Value == "" {
+		return NewApiError(http.StatusForbidden, "Missing CSRF token", err)
+	}
+	header := r.Header.Get(csrfTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+		logSecurityEvent(r, "csrf_mismatch", "%s %s", r.Method, r.URL.Path)
+		return NewApiError(http.StatusForbidden, "Invalid CSRF token", nil)
+	}
+	return nil
+}
+
+// refreshTokenFromRequest reads the refresh token from the Authorization
+// header or, for cookie sessions, from its cookie.
+func refreshTokenFromRequest(r *http.Request) (token string, fromCookie bool, err error) {
+	token, err = auth.GetBearerToken(r.Header)
+	if err == nil {
+		return token, false, nil
+	}
+	cookie, cookieErr := r.Cookie(refreshTokenCookie)
+	if cookieErr != nil || cookie.Value == "" {
+		return "", false, NewApiError(http.StatusUnauthorized, "Unauthorized: Missing or invalid token", err)
+	}
+	err = checkCSRF(r)
+	if err != nil {
+		return "", true, err
+	}
+	return cookie.Value, true, nil
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/andycostintoma/tubely/internal/auth"
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/andycostintoma/tubely/internal/mail"
+	"log"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	emailVerificationDuration = 48 * time.Hour
+	passwordResetDuration     = time.Hour
+	mailSendTimeout           = 30 * time.Second
+)
+
+// validateEmail accepts a bare address such as "user@example.com",
+// without a display name.
+func validateEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "", fmt.Errorf("%q is not a valid email address", email)
+	}
+	return email, nil
+}
+
+// appURL links to the web app with the given query parameters.
+func (cfg *apiConfig) appURL(params url.Values) string {
+	return fmt.Sprintf("%s:%s/app/?%s", cfg.serverURL, cfg.port, params.Encode())
+}
+
+// sendMail delivers msg in the background, so requests don't wait on the
+// mail server and their timing doesn't reveal whether an email was sent.
+func (cfg *apiConfig) sendMail(msg mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		err := cfg.mailer.Send(ctx, msg)
+		if err != nil {
+			log.Printf("Couldn't send %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
+
+func (cfg *apiConfig) sendEmailVerification(user database.User, email string) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.db.InvalidateUserTokens(user.ID, database.UserTokenEmailVerification)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.CreateUserToken(database.CreateUserTokenParams{
+		Token:     token,
+		UserID:    user.ID,
+		Purpose:   database.UserTokenEmailVerification,
+		Email:     email,
+		ExpiresAt: time.Now().UTC().Add(emailVerificationDuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	cfg.sendMail(mail.Message{
+		To:      email,
+		Subject: "Verify your Tubely email",
+		Body: fmt.Sprintf(
+			"Confirm that this is your email address by opening this link:\n\n%s\n\nThe link expires in %s. If you didn't sign up for Tubely, you can ignore this email.\n",
+			cfg.appURL(url.Values{"verify_email": {token}}),
+			formatHours(emailVerificationDuration),
+		),
+	})
+	return nil
+}
+
+func (cfg *apiConfig) sendPasswordReset(user database.User) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.db.InvalidateUserTokens(user.ID, database.UserTokenPasswordReset)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.CreateUserToken(database.CreateUserTokenParams{
+		Token:     token,
+		UserID:    user.ID,
+		Purpose:   database.UserTokenPasswordReset,
+		Email:     user.Email,
+		ExpiresAt: time.Now().UTC().Add(passwordResetDuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	cfg.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Tubely password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password of your Tubely account. To choose a new password, open this link:\n\n%s\n\nThe link expires in %s. If it wasn't you, you can ignore this email.\n",
+			cfg.appURL(url.Values{"reset_password": {token}}),
+			formatHours(passwordResetDuration),
+		),
+	})
+	return nil
+}
+
+func formatHours(d time.Duration) string {
+	hours := int(d.Hours())
+	if hours == 1 {
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d hours", hours)
+}
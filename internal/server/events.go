@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	eventVideoCreated     = "video.created"
+	eventVideoReady       = "video.ready"
+	eventVideoFailed      = "video.failed"
+	eventVideoDeleted     = "video.deleted"
+	eventVideoPublished   = "video.published"
+	eventVideoUnpublished = "video.unpublished"
+
+	// eventPing is only sent on request, to check an endpoint is set up.
+	eventPing = "ping"
+)
+
+// webhookEvents are the events webhooks can subscribe to.
+var webhookEvents = []string{
+	eventVideoCreated,
+	eventVideoReady,
+	eventVideoFailed,
+	eventVideoDeleted,
+	eventVideoPublished,
+	eventVideoUnpublished,
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range webhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// eventPayload is the body sent to webhooks. ID identifies the event, so
+// it stays the same across retries and redeliveries.
+type eventPayload struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// emitVideoEvent queues the event for delivery to the video owner's
+// webhooks that subscribe to it. For a workspace video the owner is whoever
+// uploaded it, who only hears about it while still a member. Failing to
+// queue it is logged rather than failing whatever caused the event.
+func (cfg *apiConfig) emitVideoEvent(event string, video database.Video) {
+	log.Printf("Event %s: video %v", event, video.ID)
+
+	if video.WorkspaceID != nil {
+		role, err := cfg.db.GetWorkspaceRole(*video.WorkspaceID, video.UserID)
+		if err != nil {
+			log.Printf("Couldn't check workspace membership for event %s video/%s: %v", event, video.ID, err)
+			return
+		}
+		if role == "" {
+			return
+		}
+	}
+
+	webhooks, err := cfg.db.GetWebhooks(video.UserID)
+	if err != nil {
+		log.Printf("Couldn't get webhooks for event %s video/%s: %v", event, video.ID, err)
+		return
+	}
+	var subscribed []database.Webhook
+	for _, webhook := range webhooks {
+		if webhook.Subscribed(event) {
+			subscribed = append(subscribed, webhook)
+		}
+	}
+	if len(subscribed) == 0 {
+		return
+	}
+
+	// The payload is stored and may be retried for hours, long after a
+	// signed link to the file would have expired, so receivers fetch the
+	// video by its ID when they want to play it.
+	video.VideoURL = nil
+	_, err = cfg.enqueueEvent(event, map[string]any{"video": video}, subscribed...)
+	if err != nil {
+		log.Printf("Couldn't queue event %s video/%s: %v", event, video.ID, err)
+	}
+}
+
+// enqueueEvent adds a delivery of the event to each webhook's outbox and
+// wakes the dispatcher.
+func (cfg *apiConfig) enqueueEvent(event string, data any, webhooks ...database.Webhook) ([]database.WebhookDelivery, error) {
+	eventID := uuid.New()
+	payload, err := json.Marshal(eventPayload{
+		ID:        eventID,
+		Type:      event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]database.WebhookDelivery, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		delivery, err := cfg.db.CreateWebhookDelivery(database.CreateWebhookDeliveryParams{
+			WebhookID: webhook.ID,
+			EventID:   eventID,
+			Event:     event,
+			Payload:   string(payload),
+		})
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	cfg.wakeWebhookDispatcher()
+	return deliveries, nil
+}
+
+// emitVideoStatusEvent emits the event with the video as it is now, so the
+// payload carries the status the event is about.
+func (cfg *apiConfig) emitVideoStatusEvent(event string, videoID uuid.UUID) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		log.Printf("Couldn't get video for event %s video/%s: %v", event, videoID, err)
+		return
+	}
+	cfg.emitVideoEvent(event, video)
+}
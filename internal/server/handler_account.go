@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/andycostintoma/tubely/internal/auth"
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/andycostintoma/tubely/internal/mail"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// handlerPasswordChange sets a new password after checking the current
+// one. Every session is signed out and the caller gets a fresh one.
+func (cfg *apiConfig) handlerPasswordChange(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	user, err := cfg.getAccountForUpdate(r, userID)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+	if params.NewPassword == "" {
+		return NewApiError(http.StatusBadRequest, "New password is required", nil)
+	}
+
+	err = cfg.checkCurrentPassword(w, r, user, params.CurrentPassword)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := auth.HashPassword(params.NewPassword)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't hash password", err)
+	}
+	err = cfg.db.UpdateUserPassword(user.ID, hashedPassword)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't update password", err)
+	}
+
+	cfg.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Your Tubely password was changed",
+		Body:    "The password of your Tubely account was just changed. If it wasn't you, reset your password right away.\n",
+	})
+
+	updated, err := cfg.db.GetUser(user.ID)
+	if err != nil || updated == nil {
+		return NewInternalServerError(err)
+	}
+	return cfg.respondWithNewSession(w, r, *updated)
+}
+
+// handlerEmailChange moves the account to a new email. The new address
+// has to be verified again, and the old one is told about the change in
+// case the account was taken over.
+func (cfg *apiConfig) handlerEmailChange(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	user, err := cfg.getAccountForUpdate(r, userID)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	email, err := validateEmail(params.Email)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, err.Error(), err)
+	}
+	if email == user.Email {
+		return NewApiError(http.StatusBadRequest, "That is already your email", nil)
+	}
+
+	err = cfg.reauthenticate(w, r, user, params.Password, params.Code)
+	if err != nil {
+		return err
+	}
+
+	existing, err := cfg.db.GetUserByEmail(email)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if existing.ID != uuid.Nil {
+		return NewApiError(http.StatusConflict, "Email is already in use", nil)
+	}
+
+	err = cfg.db.UpdateUserEmail(user.ID, email)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't update email", err)
+	}
+
+	updated, err := cfg.db.GetUser(user.ID)
+	if err != nil || updated == nil {
+		return NewInternalServerError(err)
+	}
+	err = cfg.sendEmailVerification(*updated, email)
+	if err != nil {
+		log.Printf("Couldn't send verification email to user %s: %v", user.ID, err)
+	}
+	cfg.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Your Tubely email was changed",
+		Body:    fmt.Sprintf("The email of your Tubely account was changed to %s. If it wasn't you, contact support.\n", email),
+	})
+
+	respondWithJSON(w, http.StatusOK, updated)
+	return nil
+}
+
+// handlerUserDelete deletes the caller's account for good: every personal
+// video, including those in the trash, with its files, and everything else
+// the account owns. Videos in workspaces stay with the workspace.
+func (cfg *apiConfig) handlerUserDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	user, err := cfg.getAccountForUpdate(r, userID)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	err = cfg.reauthenticate(w, r, user, params.Password, params.Code)
+	if err != nil {
+		return err
+	}
+
+	owned, err := cfg.db.CountSoleOwnedWorkspaces(user.ID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if owned > 0 {
+		return NewApiError(http.StatusConflict, "Hand your workspaces to another owner or delete them first", nil)
+	}
+
+	videos, err := cfg.db.GetVideos(user.ID, database.VideoFilter{})
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	trashed, err := cfg.db.GetTrashedVideos(user.ID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	// Files go first: if one can't be deleted the account is left intact,
+	// so the request can be retried without leaving orphaned files behind.
+	for _, video := range append(videos, trashed...) {
+		err = cfg.deleteVideoObjects(r.Context(), video)
+		if err != nil {
+			return NewApiError(http.StatusInternalServerError, "Couldn't delete stored files, please try again", err)
+		}
+	}
+
+	err = cfg.db.DeleteUser(user.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't delete account", err)
+	}
+	cfg.audit(r, user.ID, "user.delete", "user", user.ID.String(), user.Email)
+	if authFromContext(r.Context()).CookieSession {
+		clearSessionCookies(w)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// getAccountForUpdate loads the caller's account for a change that API
+// keys aren't allowed to make.
+func (cfg *apiConfig) getAccountForUpdate(r *http.Request, userID uuid.UUID) (database.User, error) {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return database.User{}, err
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		return database.User{}, NewInternalServerError(err)
+	}
+	return *user, nil
+}
+
+// checkCurrentPassword confirms the caller knows the account's password,
+// throttled like logins. Accounts created through SSO have to set a
+// password with a reset link first.
+func (cfg *apiConfig) checkCurrentPassword(w http.ResponseWriter, r *http.Request, user database.User, password string) error {
+	if user.Password == "" {
+		return NewApiError(http.StatusForbidden, "Your account has no password yet, set one with a password reset first", nil)
+	}
+
+	accountKey := accountThrottleKey(user.Email)
+	err := cfg.reserveAttempt(w, accountKey)
+	if err != nil {
+		return err
+	}
+	err = auth.CheckPasswordHash(password, user.Password)
+	if err != nil {
+		logSecurityEvent(r, "reauthentication_failed", "user=%s", user.ID)
+		return NewApiError(http.StatusUnauthorized, "Incorrect password", err)
+	}
+	return cfg.releaseAttempt(accountKey)
+}
+
+// reauthenticate asks for everything a login would before a sensitive
+// change: the password and, if enabled, a second factor.
+func (cfg *apiConfig) reauthenticate(w http.ResponseWriter, r *http.Request, user database.User, password, code string) error {
+	err := cfg.checkCurrentPassword(w, r, user, password)
+	if err != nil {
+		return err
+	}
+	_, err = cfg.checkSecondFactor(w, user.ID, code)
+	return err
+}
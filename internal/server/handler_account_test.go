@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPasswordChangeRevokesAccessTokens(t *testing.T) {
+	cfg := newTestConfig(t)
+	signUp(t, cfg, "account@example.com", true)
+	srv, client := newTestServer(t, cfg)
+	token := logIn(t, client, srv, "account@example.com")
+	otherToken := logIn(t, newTestClient(t, srv), srv, "account@example.com")
+
+	// Token issue times are in whole seconds, so let them fall before the
+	// change.
+	time.Sleep(time.Second)
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	status := doJSON(t, client, http.MethodPut, srv.URL+"/api/users/password", token, map[string]string{
+		"current_password": "password",
+		"new_password":     "new password",
+	}, &resp)
+	if status != http.StatusOK {
+		t.Fatalf("changing the password returned %d", status)
+	}
+
+	for _, old := range []string{token, otherToken} {
+		if status := doJSON(t, client, http.MethodGet, srv.URL+"/api/videos", old, nil, nil); status != http.StatusUnauthorized {
+			t.Errorf("an access token from before the change got %d, want %d", status, http.StatusUnauthorized)
+		}
+	}
+	if status := doJSON(t, client, http.MethodGet, srv.URL+"/api/videos", resp.Token, nil, nil); status != http.StatusOK {
+		t.Errorf("the new session's access token got %d, want %d", status, http.StatusOK)
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/andycostintoma/tubely/internal/database"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAuditLogLimit = 100
+	maxAuditLogLimit     = 1000
+)
+
+func (cfg *apiConfig) handlerAdminUsersRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve users", err)
+	}
+
+	cfg.audit(r, userID, "user.list", "user", "", fmt.Sprintf("count=%d", len(users)))
+
+	respondWithJSON(w, http.StatusOK, users)
+	return nil
+}
+
+func (cfg *apiConfig) handlerAdminUserDisable(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	return cfg.setUserDisabled(w, r, userID, true)
+}
+
+func (cfg *apiConfig) handlerAdminUserEnable(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	return cfg.setUserDisabled(w, r, userID, false)
+}
+
+func (cfg *apiConfig) setUserDisabled(w http.ResponseWriter, r *http.Request, actorID uuid.UUID, disabled bool) error {
+	user, err := cfg.getTargetUser(r)
+	if err != nil {
+		return err
+	}
+	if user.ID == actorID {
+		return NewApiError(http.StatusBadRequest, "You can't disable or enable your own account", nil)
+	}
+
+	err = cfg.db.SetUserDisabled(user.ID, disabled)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't update user", err)
+	}
+
+	action := "user.enable"
+	if disabled {
+		action = "user.disable"
+	}
+	cfg.audit(r, actorID, action, "user", user.ID.String(), user.Email)
+
+	return cfg.respondWithUser(w, user.ID)
+}
+
+func (cfg *apiConfig) handlerAdminUserRoleUpdate(w http.ResponseWriter, r *http.Request, actorID uuid.UUID) error {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	user, err := cfg.getTargetUser(r)
+	if err != nil {
+		return err
+	}
+	if user.ID == actorID {
+		return NewApiError(http.StatusBadRequest, "You can't change your own role", nil)
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	role, err := database.ParseUserRole(params.Role)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Role must be one of: user, moderator, admin", err)
+	}
+
+	err = cfg.db.SetUserRole(user.ID, role)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't update user", err)
+	}
+
+	cfg.audit(r, actorID, "user.role", "user", user.ID.String(), fmt.Sprintf("%s -> %s", user.Role, role))
+
+	return cfg.respondWithUser(w, user.ID)
+}
+
+// handlerAdminVideoGet returns any user's video, including ones in the
+// trash, so moderators can review reported content.
+func (cfg *apiConfig) handlerAdminVideoGet(w http.ResponseWriter, r *http.Request, actorID uuid.UUID) error {
+	video, err := cfg.getAnyVideo(r)
+	if err != nil {
+		return err
+	}
+
+	video, err = cfg.resolveVideoURL(r.Context(), video)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	cfg.audit(r, actorID, "video.view", "video", video.ID.String(), "")
+
+	respondWithJSON(w, http.StatusOK, video)
+	return nil
+}
+
+// handlerAdminVideoDelete permanently deletes a video and its files,
+// skipping the owner's trash.
+func (cfg *apiConfig) handlerAdminVideoDelete(w http.ResponseWriter, r *http.Request, actorID uuid.UUID) error {
+	video, err := cfg.getAnyVideo(r)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.deleteVideoObjects(r.Context(), video)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't delete stored files", err)
+	}
+
+	err = cfg.db.DeleteVideo(video.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't delete video", err)
+	}
+
+	cfg.audit(r, actorID, "video.delete", "video", video.ID.String(), fmt.Sprintf("owner=%s title=%q", video.UserID, video.Title))
+	cfg.emitVideoEvent(eventVideoDeleted, video)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (cfg *apiConfig) handlerAdminAuditLogRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	limit := defaultAuditLogLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAuditLogLimit {
+			return NewApiError(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxAuditLogLimit), err)
+		}
+		limit = n
+	}
+
+	entries, err := cfg.db.GetAuditLog(limit)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve audit log", err)
+	}
+
+	// Recorded after reading, so the response doesn't include itself.
+	cfg.audit(r, userID, "audit_log.view", "audit_log", "", fmt.Sprintf("limit=%d", limit))
+
+	respondWithJSON(w, http.StatusOK, entries)
+	return nil
+}
+
+func (cfg *apiConfig) respondWithUser(w http.ResponseWriter, id uuid.UUID) error {
+	user, err := cfg.db.GetUser(id)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	respondWithJSON(w, http.StatusOK, user)
+	return nil
+}
+
+func (cfg *apiConfig) getTargetUser(r *http.Request) (*database.User, error) {
+	id, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		return nil, NewApiError(http.StatusBadRequest, "Invalid user ID", err)
+	}
+
+	user, err := cfg.db.GetUser(id)
+	if err != nil {
+		return nil, NewInternalServerError(err)
+	}
+	if user == nil {
+		return nil, NewApiError(http.StatusNotFound, "Couldn't get user", nil)
+	}
+	return user, nil
+}
+
+// getAnyVideo looks up the video in the path whoever owns it, falling back
+// to the trash.
+func (cfg *apiConfig) getAnyVideo(r *http.Request) (database.Video, error) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		return database.Video{}, NewApiError(http.StatusBadRequest, "Invalid video ID", err)
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return database.Video{}, NewInternalServerError(err)
+	}
+	if video.ID == uuid.Nil {
+		video, err = cfg.db.GetTrashedVideo(videoID)
+		if err != nil {
+			return database.Video{}, NewInternalServerError(err)
+		}
+	}
+	if video.ID == uuid.Nil {
+		return database.Video{}, NewApiError(http.StatusNotFound, "Couldn't get video", nil)
+	}
+	return video, nil
+}
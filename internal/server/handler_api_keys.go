@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/andycostintoma/tubely/internal/auth"
+	"github.com/andycostintoma/tubely/internal/database"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	type response struct {
+		database.APIKey
+		Key string `json:"key"`
+	}
+
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		return NewApiError(http.StatusBadRequest, "Name is required", nil)
+	}
+	if params.ExpiresAt != nil && params.ExpiresAt.Before(time.Now()) {
+		return NewApiError(http.StatusBadRequest, "Expiry must be in the future", nil)
+	}
+	if len(params.Scopes) == 0 {
+		return NewApiError(http.StatusBadRequest, "At least one scope is required", nil)
+	}
+	err = auth.ValidateScopes(params.Scopes)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid scope", err)
+	}
+
+	key, prefix, err := auth.MakeAPIKey()
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      params.Name,
+		Prefix:    prefix,
+		Key:       key,
+		Scopes:    params.Scopes,
+		ExpiresAt: params.ExpiresAt,
+	})
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't create API key", err)
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		APIKey: apiKey,
+		Key:    key,
+	})
+	return nil
+}
+
+func (cfg *apiConfig) handlerAPIKeysRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	keys, err := cfg.db.GetAPIKeys(userID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve API keys", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+	return nil
+}
+
+func (cfg *apiConfig) handlerAPIKeyDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid ID", err)
+	}
+
+	apiKey, err := cfg.db.GetAPIKey(keyID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if apiKey.ID == uuid.Nil || apiKey.UserID != userID {
+		return NewApiError(http.StatusNotFound, "API key not found", nil)
+	}
+
+	err = cfg.db.RevokeAPIKey(apiKey.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't revoke API key", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// requireSessionAuth rejects requests authenticated with an API key, so a
+// leaked key can't be used to see or manage the owner's other keys.
+func requireSessionAuth(r *http.Request) error {
+	if authFromContext(r.Context()).APIKeyID != uuid.Nil {
+		return NewApiError(http.StatusForbidden, "This action requires logging in with a password", nil)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/andycostintoma/tubely/internal/auth"
+	"github.com/andycostintoma/tubely/internal/database"
+)
+
+func TestAPIKeysNeedASession(t *testing.T) {
+	cfg := newTestConfig(t)
+	signUp(t, cfg, "keys@example.com", true)
+	srv, client := newTestServer(t, cfg)
+	token := logIn(t, client, srv, "keys@example.com")
+
+	var created struct {
+		database.APIKey
+		Key string `json:"key"`
+	}
+	status := doJSON(t, client, http.MethodPost, srv.URL+"/api/api_keys", token, map[string]any{
+		"name":   "ci",
+		"scopes": []string{auth.ScopeVideosRead},
+	}, &created)
+	if status != http.StatusCreated {
+		t.Fatalf("creating a key returned %d", status)
+	}
+
+	// Whoever holds the key can't list or revoke the owner's other keys.
+	withKey := func(method, url string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "ApiKey "+created.Key)
+		resp, err := newTestClient(t, srv).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	keyURL := srv.URL + "/api/api_keys/" + created.ID.String()
+	if status := withKey(http.MethodGet, srv.URL+"/api/api_keys"); status != http.StatusForbidden {
+		t.Errorf("listing keys with a key returned %d, want %d", status, http.StatusForbidden)
+	}
+	if status := withKey(http.MethodDelete, keyURL); status != http.StatusForbidden {
+		t.Errorf("revoking a key with a key returned %d, want %d", status, http.StatusForbidden)
+	}
+
+	var keys []database.APIKey
+	if status := doJSON(t, client, http.MethodGet, srv.URL+"/api/api_keys", token, nil, &keys); status != http.StatusOK || len(keys) != 1 {
+		t.Fatalf("listing keys returned %d with %d keys", status, len(keys))
+	}
+	if keys[0].RevokedAt != nil {
+		t.Error("the key was revoked")
+	}
+	if status := doJSON(t, client, http.MethodDelete, keyURL, token, nil, nil); status != http.StatusNoContent {
+		t.Errorf("revoking the key returned %d", status)
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/google/uuid"
+)
+
+// withAssetAccess serves files from the assets directory, hiding the
+// thumbnails of private videos from anyone who can't see the video. Browser
+// sessions send their cookie with image requests; API clients have to send
+// their token.
+func (cfg *apiConfig) withAssetAccess(next http.Handler) http.HandlerFunc {
+	return withApiError(func(w http.ResponseWriter, r *http.Request) error {
+		name := strings.TrimPrefix(r.URL.Path, "/assets/")
+		thumbnailURL := cfg.fsStorage().urlFor(fmt.Sprintf("%v/%v", cfg.assetsRoot, name))
+		video, err := cfg.db.GetVideoByThumbnailURL(thumbnailURL)
+		if err != nil {
+			return NewInternalServerError(err)
+		}
+
+		if video.ID != uuid.Nil && video.Visibility == database.VideoVisibilityPrivate {
+			info, err := cfg.authenticateOptional(r)
+			if err != nil {
+				return err
+			}
+			canView, err := cfg.canViewVideo(info, video)
+			if err != nil {
+				return err
+			}
+			if !canView {
+				return NewApiError(http.StatusNotFound, "Not found", nil)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+		return nil
+	})
+}
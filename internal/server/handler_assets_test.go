@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andycostintoma/tubely/internal/database"
+)
+
+func TestPrivateThumbnails(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := signUp(t, cfg, "owner@example.com", true)
+	signUp(t, cfg, "other@example.com", true)
+	srv, client := newTestServer(t, cfg)
+	token := logIn(t, client, srv, "owner@example.com")
+	otherClient := newTestClient(t, srv)
+	otherToken := logIn(t, otherClient, srv, "other@example.com")
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Video", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(cfg.assetsRoot, "thumb.png")
+	if err := os.WriteFile(filePath, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	thumbnailURL := cfg.fsStorage().urlFor(filePath)
+	video.ThumbnailURL = &thumbnailURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+
+	url := srv.URL + "/assets/thumb.png"
+	if status := doJSON(t, client, http.MethodGet, url, token, nil, nil); status != http.StatusOK {
+		t.Errorf("the owner got %d, want %d", status, http.StatusOK)
+	}
+	if status := doJSON(t, otherClient, http.MethodGet, url, otherToken, nil, nil); status != http.StatusNotFound {
+		t.Errorf("another user got %d, want %d", status, http.StatusNotFound)
+	}
+	if status := doJSON(t, newTestClient(t, srv), http.MethodGet, url, "", nil, nil); status != http.StatusNotFound {
+		t.Errorf("an anonymous request got %d, want %d", status, http.StatusNotFound)
+	}
+
+	video.Visibility = database.VideoVisibilityUnlisted
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	if status := doJSON(t, newTestClient(t, srv), http.MethodGet, url, "", nil, nil); status != http.StatusOK {
+		t.Errorf("an anonymous request for an unlisted video's thumbnail got %d, want %d", status, http.StatusOK)
+	}
+}
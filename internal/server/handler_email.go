@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/andycostintoma/tubely/internal/auth"
+	"github.com/andycostintoma/tubely/internal/database"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) error {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	token, err := cfg.db.ConsumeUserToken(params.Token, database.UserTokenEmailVerification)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if token == nil {
+		return NewApiError(http.StatusBadRequest, "Verification link is invalid or has expired", nil)
+	}
+
+	verified, err := cfg.db.SetUserEmailVerified(token.UserID, token.Email)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if !verified {
+		return NewApiError(http.StatusBadRequest, "Verification link is for an email that is no longer on the account", nil)
+	}
+
+	return cfg.respondWithUser(w, token.UserID)
+}
+
+func (cfg *apiConfig) handlerResendVerification(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		return NewInternalServerError(err)
+	}
+	if user.EmailVerifiedAt != nil {
+		return NewApiError(http.StatusConflict, "Email is already verified", nil)
+	}
+
+	err = cfg.sendEmailVerification(*user, user.Email)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// handlerPasswordResetRequest emails a reset link. It responds the same
+// whether or not the email belongs to an account, so it can't be used to
+// find out who has one.
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) error {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if user.ID != uuid.Nil && user.DisabledAt == nil {
+		err = cfg.sendPasswordReset(user)
+		if err != nil {
+			return NewInternalServerError(err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+func (cfg *apiConfig) handlerPasswordResetConfirm(w http.ResponseWriter, r *http.Request) error {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+	if params.Password == "" {
+		return NewApiError(http.StatusBadRequest, "Password is required", nil)
+	}
+
+	token, err := cfg.db.ConsumeUserToken(params.Token, database.UserTokenPasswordReset)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if token == nil {
+		return NewApiError(http.StatusBadRequest, "Reset link is invalid or has expired", nil)
+	}
+
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't hash password", err)
+	}
+	err = cfg.db.UpdateUserPassword(token.UserID, hashedPassword)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't update password", err)
+	}
+
+	// Receiving the link proves the user controls the address.
+	_, err = cfg.db.SetUserEmailVerified(token.UserID, token.Email)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
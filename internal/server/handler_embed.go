@@ -0,0 +1,277 @@
+package server
+
+import (
+	"fmt"
+	"github.com/andycostintoma/tubely/internal/database"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultEmbedWidth = 640
+	minEmbedDimension = 120
+	// hlsScriptURL plays HLS in browsers without native support, which is
+	// everything but Safari. The version is pinned so the page doesn't
+	// change under us.
+	hlsScriptURL = "https://cdn.jsdelivr.net/npm/hls.js@1.5.20/dist/hls.min.js"
+)
+
+var embedTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - Tubely</title>
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbedURL}}" title="{{.Title}}">
+<style>
+html, body { margin: 0; height: 100%; background: #000; color: #fff; font-family: sans-serif; }
+video { display: block; width: 100%; height: 100%; object-fit: contain; }
+p { margin: 0; padding: 1em; }
+</style>
+</head>
+<body>
+{{if .VideoURL}}<video controls playsinline preload="metadata"{{if .PosterURL}} poster="{{.PosterURL}}"{{end}}>
+<source src="{{.VideoURL}}" type="{{.MediaType}}">
+</video>{{else}}<p>This video isn't available yet.</p>{{end}}
+{{if .HLSScriptURL}}<script src="{{.HLSScriptURL}}" crossorigin="anonymous"></script>
+<script>
+var video = document.querySelector("video");
+if (!video.canPlayType("application/vnd.apple.mpegurl") && window.Hls && Hls.isSupported()) {
+	var hls = new Hls();
+	hls.loadSource(video.querySelector("source").src);
+	hls.attachMedia(video);
+}
+</script>{{end}}
+</body>
+</html>
+`))
+
+// handlerEmbed serves a bare player page meant to be shown in an iframe on
+// other sites. Cookies aren't sent to cross-site iframes, so in practice
+// only unlisted and public videos can be embedded.
+func (cfg *apiConfig) handlerEmbed(w http.ResponseWriter, r *http.Request) error {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid video ID", err)
+	}
+
+	video, err := cfg.getEmbeddableVideo(r, videoID)
+	if err != nil {
+		return err
+	}
+
+	video, err = cfg.resolveVideoURL(r.Context(), video)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	data := struct {
+		Title        string
+		OEmbedURL    string
+		VideoURL     template.URL
+		PosterURL    template.URL
+		MediaType    string
+		HLSScriptURL string
+	}{
+		Title:     video.Title,
+		OEmbedURL: cfg.oEmbedURL(video.ID),
+	}
+	// Both URLs were produced by our own storage, so they're trusted even
+	// when they're data URLs.
+	if video.Status == database.VideoStatusReady && video.VideoURL != nil {
+		data.VideoURL = template.URL(*video.VideoURL)
+		data.MediaType = videoMediaType(*video.VideoURL)
+		// Safari plays HLS natively; elsewhere hls.js takes over.
+		if data.MediaType == hlsMediaType {
+			data.HLSScriptURL = hlsScriptURL
+		}
+	}
+	if video.ThumbnailURL != nil {
+		data.PosterURL = template.URL(*video.ThumbnailURL)
+	}
+
+	// Presigned URLs expire, so the page mustn't be cached for longer than
+	// they last.
+	if video.Visibility == database.VideoVisibilityPrivate {
+		w.Header().Set("Cache-Control", "private, no-store")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
+	w.Header().Set("Content-Security-Policy", "frame-ancestors *")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = embedTemplate.Execute(w, data)
+	if err != nil {
+		log.Printf("Couldn't render embed page for video %s: %v", video.ID, err)
+	}
+	return nil
+}
+
+// handlerOEmbed describes an embed page following the oEmbed spec
+// (https://oembed.com), so tools that support it can turn a pasted link
+// into a player.
+func (cfg *apiConfig) handlerOEmbed(w http.ResponseWriter, r *http.Request) error {
+	type response struct {
+		Version      string `json:"version"`
+		Type         string `json:"type"`
+		Title        string `json:"title"`
+		ProviderName string `json:"provider_name"`
+		ProviderURL  string `json:"provider_url"`
+		HTML         string `json:"html"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+	}
+
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "json" {
+		return NewApiError(http.StatusNotImplemented, "Only the json format is supported", nil)
+	}
+
+	videoID, err := cfg.embedVideoID(query.Get("url"))
+	if err != nil {
+		return NewApiError(http.StatusNotFound, "Not an embeddable Tubely URL", err)
+	}
+	maxWidth, err := parseOptionalDimension(query.Get("maxwidth"))
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid maxwidth", err)
+	}
+	maxHeight, err := parseOptionalDimension(query.Get("maxheight"))
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid maxheight", err)
+	}
+
+	video, err := cfg.getEmbeddableVideo(r, videoID)
+	if err != nil {
+		return err
+	}
+
+	width, height := embedSize(video.AspectRatio, maxWidth, maxHeight)
+	html := fmt.Sprintf(
+		`<iframe src="%s" width="%d" height="%d" title="%s" frameborder="0" allow="fullscreen; picture-in-picture" allowfullscreen></iframe>`,
+		template.HTMLEscapeString(cfg.embedURL(video.ID)),
+		width,
+		height,
+		template.HTMLEscapeString(video.Title),
+	)
+
+	if video.Visibility == database.VideoVisibilityPrivate {
+		w.Header().Set("Cache-Control", "private")
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Version:      "1.0",
+		Type:         "video",
+		Title:        video.Title,
+		ProviderName: "Tubely",
+		ProviderURL:  fmt.Sprintf("%s:%s/", cfg.serverURL, cfg.port),
+		HTML:         html,
+		Width:        width,
+		Height:       height,
+	})
+	return nil
+}
+
+// getEmbeddableVideo loads a video the caller is allowed to watch, with
+// the same rules as fetching it through the API.
+func (cfg *apiConfig) getEmbeddableVideo(r *http.Request, videoID uuid.UUID) (database.Video, error) {
+	info, err := cfg.authenticateOptional(r)
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return database.Video{}, NewInternalServerError(err)
+	}
+	canView, err := cfg.canViewVideo(info, video)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if video.ID == uuid.Nil || !canView {
+		return database.Video{}, NewApiError(http.StatusNotFound, "Couldn't get video", nil)
+	}
+	return video, nil
+}
+
+func (cfg *apiConfig) embedURL(videoID uuid.UUID) string {
+	return fmt.Sprintf("%s:%s/embed/%s", cfg.serverURL, cfg.port, videoID)
+}
+
+func (cfg *apiConfig) oEmbedURL(videoID uuid.UUID) string {
+	params := url.Values{"url": {cfg.embedURL(videoID)}, "format": {"json"}}
+	return fmt.Sprintf("%s:%s/oembed?%s", cfg.serverURL, cfg.port, params.Encode())
+}
+
+// embedVideoID reads the video ID from the URL of one of this server's
+// embed pages.
+func (cfg *apiConfig) embedVideoID(rawURL string) (uuid.UUID, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	server, err := url.Parse(fmt.Sprintf("%s:%s", cfg.serverURL, cfg.port))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !strings.EqualFold(u.Host, server.Host) {
+		return uuid.Nil, fmt.Errorf("%q isn't on this server", rawURL)
+	}
+	id, found := strings.CutPrefix(u.Path, "/embed/")
+	if !found {
+		return uuid.Nil, fmt.Errorf("%q isn't an embed URL", rawURL)
+	}
+	return uuid.Parse(strings.TrimSuffix(id, "/"))
+}
+
+func parseOptionalDimension(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n < minEmbedDimension {
+		return 0, fmt.Errorf("must be at least %d", minEmbedDimension)
+	}
+	return n, nil
+}
+
+// embedSize picks the player's size from the video's aspect ratio, scaled
+// down to fit maxWidth and maxHeight when they're set. Videos whose aspect
+// ratio isn't known get a 16:9 player.
+func embedSize(aspectRatio *string, maxWidth, maxHeight int) (int, int) {
+	ratioW, ratioH := 16, 9
+	if aspectRatio != nil && *aspectRatio == "9:16" {
+		ratioW, ratioH = 9, 16
+	}
+
+	width := defaultEmbedWidth
+	if ratioH > ratioW {
+		width = defaultEmbedWidth * ratioW / ratioH
+	}
+	if maxWidth > 0 && width > maxWidth {
+		width = maxWidth
+	}
+	height := width * ratioH / ratioW
+	if maxHeight > 0 && height > maxHeight {
+		height = maxHeight
+		width = height * ratioW / ratioH
+	}
+	return width, height
+}
+
+const hlsMediaType = "application/vnd.apple.mpegurl"
+
+// videoMediaType guesses the player's source type from the file's URL.
+func videoMediaType(videoURL string) string {
+	u, err := url.Parse(videoURL)
+	if err == nil && strings.HasSuffix(u.Path, ".m3u8") {
+		return hlsMediaType
+	}
+	return "video/mp4"
+}
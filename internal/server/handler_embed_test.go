@@ -0,0 +1,95 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/andycostintoma/tubely/internal/database"
+)
+
+func TestEmbedHLS(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.s3URLMode = "cloudfront"
+	cfg.s3CfDistribution = "d111111abcdef8.cloudfront.net"
+	owner := signUp(t, cfg, "owner@example.com", true)
+	srv, client := newTestServer(t, cfg)
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:      "Video",
+		UserID:     owner.ID,
+		Visibility: database.VideoVisibilityPublic,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []database.VideoStatus{database.VideoStatusUploading, database.VideoStatusProcessing, database.VideoStatusReady} {
+		if err := cfg.db.SetVideoStatus(video.ID, status, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		url     string
+		wantHLS bool
+	}{
+		{"https://d111111abcdef8.cloudfront.net/landscape/abc.mp4", false},
+		{"https://d111111abcdef8.cloudfront.net/landscape/abc/index.m3u8", true},
+	} {
+		video.VideoURL = &tt.url
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := client.Get(srv.URL + "/embed/" + video.ID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := string(body)
+		if !strings.Contains(page, tt.url) {
+			t.Fatalf("%s: the page doesn't play the video:\n%s", tt.url, page)
+		}
+		if got := strings.Contains(page, hlsScriptURL); got != tt.wantHLS {
+			t.Errorf("%s: page includes hls.js: %v, want %v", tt.url, got, tt.wantHLS)
+		}
+	}
+}
+
+func TestOEmbedRejectsOtherHosts(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := signUp(t, cfg, "owner@example.com", true)
+	srv, client := newTestServer(t, cfg)
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:      "Video",
+		UserID:     owner.ID,
+		Visibility: database.VideoVisibilityPublic,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		url  string
+		want int
+	}{
+		{cfg.embedURL(video.ID), http.StatusOK},
+		{"https://evil.example.com/embed/" + video.ID.String(), http.StatusNotFound},
+		{"/embed/" + video.ID.String(), http.StatusNotFound},
+	} {
+		oembed := srv.URL + "/oembed?" + url.Values{"url": {tt.url}}.Encode()
+		if status := doJSON(t, client, http.MethodGet, oembed, "", nil, nil); status != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.url, status, tt.want)
+		}
+	}
+}
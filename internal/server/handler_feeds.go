@@ -0,0 +1,376 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/andycostintoma/tubely/internal/database"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxFeedItems  = 100
+	feedCacheTime = 15 * time.Minute
+	// Used for users who haven't described their feeds yet.
+	defaultFeedTitle  = "Tubely videos"
+	defaultFeedAuthor = "Tubely"
+)
+
+type atomFeed struct {
+	XMLName    xml.Name    `xml:"feed"`
+	Xmlns      string      `xml:"xmlns,attr"`
+	XmlnsMedia string      `xml:"xmlns:media,attr"`
+	ID         string      `xml:"id"`
+	Title      string      `xml:"title"`
+	Updated    string      `xml:"updated"`
+	Links      []atomLink  `xml:"link"`
+	Author     atomAuthor  `xml:"author"`
+	Generator  string      `xml:"generator"`
+	Entries    []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string          `xml:"id"`
+	Title     string          `xml:"title"`
+	Published string          `xml:"published"`
+	Updated   string          `xml:"updated"`
+	Summary   string          `xml:"summary,omitempty"`
+	Links     []atomLink      `xml:"link"`
+	Thumbnail *mediaThumbnail `xml:"media:thumbnail"`
+}
+
+type mediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+type podcastFeed struct {
+	XMLName     xml.Name       `xml:"rss"`
+	Version     string         `xml:"version,attr"`
+	XmlnsItunes string         `xml:"xmlns:itunes,attr"`
+	XmlnsAtom   string         `xml:"xmlns:atom,attr"`
+	Channel     podcastChannel `xml:"channel"`
+}
+
+type podcastChannel struct {
+	Title       string         `xml:"title"`
+	Link        string         `xml:"link"`
+	Description string         `xml:"description"`
+	Language    string         `xml:"language"`
+	Generator   string         `xml:"generator"`
+	LastBuild   string         `xml:"lastBuildDate"`
+	AtomLink    atomLink       `xml:"atom:link"`
+	Author      string         `xml:"itunes:author"`
+	Image       *itunesImage   `xml:"itunes:image"`
+	Category    itunesCategory `xml:"itunes:category"`
+	Explicit    string         `xml:"itunes:explicit"`
+	Type        string         `xml:"itunes:type"`
+	Items       []podcastItem  `xml:"item"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type itunesCategory struct {
+	Text string `xml:"text,attr"`
+}
+
+type podcastItem struct {
+	Title       string           `xml:"title"`
+	Description string           `xml:"description,omitempty"`
+	Link        string           `xml:"link"`
+	GUID        podcastGUID      `xml:"guid"`
+	PubDate     string           `xml:"pubDate"`
+	Enclosure   podcastEnclosure `xml:"enclosure"`
+	Image       *itunesImage     `xml:"itunes:image"`
+	EpisodeType string           `xml:"itunes:episodeType"`
+}
+
+type podcastGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type podcastEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr,omitempty"`
+	Type   string `xml:"type,attr"`
+}
+
+// handlerAtomFeed serves an Atom feed of the user's public videos.
+func (cfg *apiConfig) handlerAtomFeed(w http.ResponseWriter, r *http.Request) error {
+	user, videos, err := cfg.getFeedVideos(r)
+	if err != nil {
+		return err
+	}
+
+	settings := feedSettings(user)
+	feed := atomFeed{
+		Xmlns:      "http://www.w3.org/2005/Atom",
+		XmlnsMedia: "http://search.yahoo.com/mrss/",
+		ID:         "urn:uuid:" + user.ID.String(),
+		Title:      settings.Title,
+		Updated:    feedLastModified(user).Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: cfg.feedURL(user.ID, "atom.xml")},
+		},
+		Author:    atomAuthor{Name: settings.Author},
+		Generator: "Tubely",
+	}
+	for _, video := range videos {
+		entry := atomEntry{
+			ID:        "urn:uuid:" + video.ID.String(),
+			Title:     video.Title,
+			Published: video.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   video.UpdatedAt.UTC().Format(time.RFC3339),
+			Summary:   video.Description,
+			Links: []atomLink{
+				{Rel: "alternate", Type: "text/html", Href: cfg.embedURL(video.ID)},
+				{Rel: "enclosure", Type: videoMediaType(*video.VideoURL), Href: cfg.mediaURL(video.ID), Length: videoSize(video)},
+			},
+		}
+		if artwork := feedArtworkURL(video); artwork != "" {
+			entry.Thumbnail = &mediaThumbnail{URL: artwork}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return serveFeed(w, r, "application/atom+xml; charset=utf-8", feed, feedLastModified(user))
+}
+
+// handlerPodcastFeed serves the user's public videos as an RSS feed with
+// the iTunes tags podcast apps expect.
+func (cfg *apiConfig) handlerPodcastFeed(w http.ResponseWriter, r *http.Request) error {
+	user, videos, err := cfg.getFeedVideos(r)
+	if err != nil {
+		return err
+	}
+
+	settings := feedSettings(user)
+	feed := podcastFeed{
+		Version:     "2.0",
+		XmlnsItunes: "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		XmlnsAtom:   "http://www.w3.org/2005/Atom",
+		Channel: podcastChannel{
+			Title:       settings.Title,
+			Link:        fmt.Sprintf("%s:%s/", cfg.serverURL, cfg.port),
+			Description: settings.Description,
+			Language:    "en",
+			Generator:   "Tubely",
+			LastBuild:   feedLastModified(user).Format(time.RFC1123Z),
+			AtomLink:    atomLink{Rel: "self", Type: "application/rss+xml", Href: cfg.feedURL(user.ID, "podcast.xml")},
+			Author:      settings.Author,
+			Category:    itunesCategory{Text: "Education"},
+			Explicit:    "false",
+			Type:        "episodic",
+		},
+	}
+	for _, video := range videos {
+		item := podcastItem{
+			Title:       video.Title,
+			Description: video.Description,
+			Link:        cfg.embedURL(video.ID),
+			GUID:        podcastGUID{Value: video.ID.String()},
+			PubDate:     video.CreatedAt.UTC().Format(time.RFC1123Z),
+			Enclosure: podcastEnclosure{
+				URL:    cfg.mediaURL(video.ID),
+				Length: videoSize(video),
+				Type:   videoMediaType(*video.VideoURL),
+			},
+			EpisodeType: "full",
+		}
+		if artwork := feedArtworkURL(video); artwork != "" {
+			item.Image = &itunesImage{Href: artwork}
+			// The newest video's thumbnail doubles as the show's artwork.
+			if feed.Channel.Image == nil {
+				feed.Channel.Image = &itunesImage{Href: artwork}
+			}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	return serveFeed(w, r, "application/rss+xml; charset=utf-8", feed, feedLastModified(user))
+}
+
+// handlerFeedSettingsRetrieve returns how the caller's feeds describe
+// themselves, as set. Empty fields use the defaults.
+func (cfg *apiConfig) handlerFeedSettingsRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		return NewInternalServerError(err)
+	}
+	respondWithJSON(w, http.StatusOK, user.Feed)
+	return nil
+}
+
+// handlerFeedSettingsUpdate sets the title, author and description of the
+// caller's feeds. Empty fields go back to the defaults.
+func (cfg *apiConfig) handlerFeedSettingsUpdate(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	decoder := json.NewDecoder(r.Body)
+	params := database.FeedSettings{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+	params.Title = strings.TrimSpace(params.Title)
+	params.Author = strings.TrimSpace(params.Author)
+	params.Description = strings.TrimSpace(params.Description)
+
+	err = cfg.db.UpdateUserFeed(userID, params)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't update feed settings", err)
+	}
+	respondWithJSON(w, http.StatusOK, params)
+	return nil
+}
+
+// handlerVideoMedia redirects to the video's file. Feeds link here instead
+// of to the file itself, since presigned URLs expire long before podcast
+// apps get around to downloading them.
+func (cfg *apiConfig) handlerVideoMedia(w http.ResponseWriter, r *http.Request) error {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid video ID", err)
+	}
+
+	video, err := cfg.getEmbeddableVideo(r, videoID)
+	if err != nil {
+		return err
+	}
+	if video.Status != database.VideoStatusReady || video.VideoURL == nil {
+		return NewApiError(http.StatusNotFound, "Video has no file yet", nil)
+	}
+
+	video, err = cfg.resolveVideoURL(r.Context(), video)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, *video.VideoURL, http.StatusFound)
+	return nil
+}
+
+// getFeedVideos loads the user named in the path and their newest public
+// videos that are ready to watch.
+func (cfg *apiConfig) getFeedVideos(r *http.Request) (database.User, []database.Video, error) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		return database.User{}, nil, NewApiError(http.StatusBadRequest, "Invalid user ID", err)
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return database.User{}, nil, NewInternalServerError(err)
+	}
+	if user == nil || user.DisabledAt != nil {
+		return database.User{}, nil, NewApiError(http.StatusNotFound, "Feed not found", nil)
+	}
+
+	videos, err := cfg.db.GetVideos(user.ID, database.VideoFilter{
+		Statuses:     []database.VideoStatus{database.VideoStatusReady},
+		Visibilities: []database.VideoVisibility{database.VideoVisibilityPublic},
+	})
+	if err != nil {
+		return database.User{}, nil, NewApiError(http.StatusInternalServerError, "Couldn't retrieve videos", err)
+	}
+	feedVideos := []database.Video{}
+	for _, video := range videos {
+		if video.VideoURL == nil {
+			continue
+		}
+		feedVideos = append(feedVideos, video)
+		if len(feedVideos) == maxFeedItems {
+			break
+		}
+	}
+	return *user, feedVideos, nil
+}
+
+// serveFeed writes the feed as XML. http.ServeContent answers conditional
+// requests from the ETag and Last-Modified headers, so feed readers that
+// poll often only download the feed when it changed.
+func serveFeed(w http.ResponseWriter, r *http.Request, contentType string, feed any, lastModified time.Time) error {
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	body = append([]byte(xml.Header), body...)
+
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum[:16]))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(feedCacheTime.Seconds())))
+	http.ServeContent(w, r, "", lastModified, bytes.NewReader(body))
+	return nil
+}
+
+// feedLastModified returns when the user's feeds last changed. It only
+// moves forward, so readers notice videos being removed too.
+func feedLastModified(user database.User) time.Time {
+	if user.FeedChangedAt == nil {
+		return user.CreatedAt.UTC()
+	}
+	return user.FeedChangedAt.UTC()
+}
+
+// feedSettings fills in defaults for whatever the user hasn't set.
+func feedSettings(user database.User) database.FeedSettings {
+	settings := user.Feed
+	if settings.Title == "" {
+		settings.Title = defaultFeedTitle
+	}
+	if settings.Author == "" {
+		settings.Author = defaultFeedAuthor
+	}
+	if settings.Description == "" {
+		settings.Description = settings.Title
+	}
+	return settings
+}
+
+func (cfg *apiConfig) feedURL(userID uuid.UUID, name string) string {
+	return fmt.Sprintf("%s:%s/feeds/%s/%s", cfg.serverURL, cfg.port, userID, name)
+}
+
+func (cfg *apiConfig) mediaURL(videoID uuid.UUID) string {
+	return fmt.Sprintf("%s:%s/media/%s", cfg.serverURL, cfg.port, videoID)
+}
+
+// feedArtworkURL returns the video's thumbnail if feed readers can fetch
+// it. Thumbnails kept in the database are data URLs, which they can't.
+func feedArtworkURL(video database.Video) string {
+	if video.ThumbnailURL == nil {
+		return ""
+	}
+	if !strings.HasPrefix(*video.ThumbnailURL, "http://") && !strings.HasPrefix(*video.ThumbnailURL, "https://") {
+		return ""
+	}
+	return *video.ThumbnailURL
+}
+
+// videoSize returns the size of the video's file, or 0 for files uploaded
+// before sizes were recorded, in which case feeds leave the length out.
+func videoSize(video database.Video) int64 {
+	if video.VideoSize == nil {
+		return 0
+	}
+	return *video.VideoSize
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andycostintoma/tubely/internal/database"
+)
+
+func TestPodcastFeed(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := signUp(t, cfg, "owner@example.com", true)
+	srv, client := newTestServer(t, cfg)
+	token := logIn(t, client, srv, "owner@example.com")
+
+	settings := database.FeedSettings{Title: "Cooking with Ada", Author: "Ada"}
+	if status := doJSON(t, client, http.MethodPut, srv.URL+"/api/users/feed", token, settings, nil); status != http.StatusOK {
+		t.Fatalf("updating feed settings returned %d", status)
+	}
+
+	var videos []database.Video
+	for _, title := range []string{"Older", "Newer"} {
+		video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+			Title:      title,
+			UserID:     owner.ID,
+			Visibility: database.VideoVisibilityPublic,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, status := range []database.VideoStatus{database.VideoStatusUploading, database.VideoStatusProcessing, database.VideoStatusReady} {
+			if err := cfg.db.SetVideoStatus(video.ID, status, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		video, err = cfg.db.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		videoURL := "tubely-test,landscape/" + title + ".mp4"
+		video.VideoURL = &videoURL
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			t.Fatal(err)
+		}
+		videos = append(videos, video)
+	}
+
+	getFeed := func() (string, time.Time) {
+		t.Helper()
+		res, err := client.Get(srv.URL + "/feeds/" + owner.ID.String() + "/podcast.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("fetching the feed returned %d", res.StatusCode)
+		}
+		lastModified, err := http.ParseTime(res.Header.Get("Last-Modified"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body), lastModified
+	}
+
+	feed, before := getFeed()
+	for _, want := range []string{"<title>Cooking with Ada</title>", "<itunes:author>Ada</itunes:author>"} {
+		if !strings.Contains(feed, want) {
+			t.Errorf("feed doesn't contain %s:\n%s", want, feed)
+		}
+	}
+	// Neither video has a recorded size.
+	if strings.Contains(feed, `length=`) {
+		t.Errorf("feed has an enclosure length for videos of unknown size:\n%s", feed)
+	}
+
+	// Removing the newest video still has to move Last-Modified forward,
+	// or readers would keep showing it.
+	time.Sleep(time.Second)
+	if err := cfg.db.TrashVideo(videos[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	feed, after := getFeed()
+	if strings.Contains(feed, "Newer") {
+		t.Fatalf("feed still lists the trashed video:\n%s", feed)
+	}
+	if !after.After(before) {
+		t.Errorf("Last-Modified went from %v to %v after a video was removed", before, after)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"github.com/andycostintoma/tubely/internal/auth"
 	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/google/uuid"
 
 	"net/http"
 	"time"
@@ -14,11 +15,6 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) error
 		Password string `json:"password"`
 		Email    string `json:"email"`
 	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -27,20 +23,73 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) error
 		return NewApiError(http.StatusBadRequest, "Couldn't parse request body", err)
 	}
 
+	ipKey, accountKey := cfg.ipThrottleKey(r), accountThrottleKey(params.Email)
+	err = cfg.reserveAttempt(w, ipKey, accountKey)
+	if err != nil {
+		return err
+	}
+
 	user, err := cfg.db.GetUserByEmail(params.Email)
 	if err != nil {
-		return NewApiError(http.StatusUnauthorized, "Incorrect email or password", err)
+		return NewInternalServerError(err)
 	}
 
-	err = auth.CheckPasswordHash(params.Password, user.Password)
+	// Unknown emails go through the same steps as wrong passwords, so
+	// neither the response nor its timing tells them apart.
+	err = checkPasswordConstantTime(params.Password, user.Password)
 	if err != nil {
+		logSecurityEvent(r, "login_failed", "email=%q", params.Email)
 		return NewApiError(http.StatusUnauthorized, "Incorrect email or password", err)
 	}
 
+	err = cfg.clearFailures(accountKey)
+	if err != nil {
+		return err
+	}
+	err = cfg.releaseAttempt(ipKey)
+	if err != nil {
+		return err
+	}
+	if user.DisabledAt != nil {
+		return NewApiError(http.StatusForbidden, "Account is disabled", nil)
+	}
+
+	mfa, err := cfg.mfaEnabled(user.ID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if mfa {
+		return cfg.respondWithMFAChallenge(w, user.ID)
+	}
+
+	return cfg.respondWithNewSession(w, r, user)
+}
+
+// respondWithNewSession starts a session for a user who has just proved
+// who they are, responding with the user, an access token and the first
+// refresh token of the session. Browser sessions get the tokens in cookies
+// and only see the CSRF token.
+func (cfg *apiConfig) respondWithNewSession(w http.ResponseWriter, r *http.Request, user database.User) error {
+	type response struct {
+		database.User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	type cookieResponse struct {
+		database.User
+		CSRFToken string `json:"csrf_token"`
+	}
+
+	cookieSession := wantsCookieSession(r)
+	expiresIn := time.Hour * 24 * 30
+	if cookieSession {
+		expiresIn = cookieAccessTokenDuration
+	}
+
 	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour*24*30,
+		auth.AccessToken{UserID: user.ID, Scopes: auth.AllScopes, Role: string(user.Role)},
+		cfg.jwtKeys,
+		expiresIn,
 	)
 
 	if err != nil {
@@ -55,12 +104,27 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) error
 	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
 		UserID:    user.ID,
 		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		ExpiresAt: time.Now().UTC().Add(refreshTokenDuration),
+		FamilyID:  uuid.New().String(),
+		UserAgent: r.UserAgent(),
+		IPAddress: cfg.clientIP(r),
 	})
 	if err != nil {
 		return NewInternalServerError(err)
 	}
 
+	if cookieSession {
+		csrfToken, err := setSessionCookies(w, r, accessToken, refreshToken)
+		if err != nil {
+			return NewInternalServerError(err)
+		}
+		respondWithJSON(w, http.StatusOK, cookieResponse{
+			User:      user,
+			CSRFToken: csrfToken,
+		})
+		return nil
+	}
+
 	respondWithJSON(w, http.StatusOK, response{
 		User:         user,
 		Token:        accessToken,
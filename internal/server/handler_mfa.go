@@ -0,0 +1,316 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/andycostintoma/tubely/internal/auth"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer       = "Tubely"
+	mfaTokenDuration = 5 * time.Minute
+)
+
+func (cfg *apiConfig) handlerMFARetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type response struct {
+		TOTPEnabled            bool `json:"totp_enabled"`
+		RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+	}
+
+	cred, err := cfg.db.GetTOTPCredential(userID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	remaining, err := cfg.db.CountRecoveryCodes(userID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		TOTPEnabled:            cred != nil && cred.ConfirmedAt != nil,
+		RecoveryCodesRemaining: remaining,
+	})
+	return nil
+}
+
+// handlerTOTPEnroll generates a secret for the user to add to their
+// authenticator app. Two-factor authentication isn't enabled until a code
+// from the app is confirmed.
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	cred, err := cfg.db.GetTOTPCredential(userID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if cred != nil && cred.ConfirmedAt != nil {
+		return NewApiError(http.StatusConflict, "Two-factor authentication is already enabled", nil)
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		return NewInternalServerError(err)
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	err = cfg.db.SetPendingTOTPSecret(userID, secret)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+	return nil
+}
+
+// handlerTOTPConfirm enables two-factor authentication once the user shows
+// their app is set up, and hands out recovery codes. This is the only time
+// the codes are shown.
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	cred, err := cfg.db.GetTOTPCredential(userID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if cred == nil {
+		return NewApiError(http.StatusBadRequest, "Start enrollment first", nil)
+	}
+	if cred.ConfirmedAt != nil {
+		return NewApiError(http.StatusConflict, "Two-factor authentication is already enabled", nil)
+	}
+
+	step, ok := auth.ValidateTOTP(cred.Secret, params.Code, time.Now())
+	if !ok {
+		return NewApiError(http.StatusBadRequest, "Invalid code", nil)
+	}
+
+	codes, err := auth.MakeRecoveryCodes()
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	normalized := make([]string, len(codes))
+	for i, code := range codes {
+		normalized[i] = auth.NormalizeRecoveryCode(code)
+	}
+
+	err = cfg.db.ConfirmTOTP(userID, step, normalized)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{RecoveryCodes: codes})
+	return nil
+}
+
+// handlerTOTPDisable turns two-factor authentication off. A stolen access
+// token isn't enough: the user signs in again with their password, if they
+// have one, and a current code.
+func (cfg *apiConfig) handlerTOTPDisable(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		return NewInternalServerError(err)
+	}
+	if user.Password != "" {
+		accountKey := accountThrottleKey(user.Email)
+		err = cfg.reserveAttempt(w, accountKey)
+		if err != nil {
+			return err
+		}
+		err = auth.CheckPasswordHash(params.Password, user.Password)
+		if err != nil {
+			return NewApiError(http.StatusUnauthorized, "Incorrect password", err)
+		}
+		err = cfg.releaseAttempt(accountKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	enabled, err := cfg.checkSecondFactor(w, userID, params.Code)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return NewApiError(http.StatusBadRequest, "Two-factor authentication is not enabled", nil)
+	}
+
+	err = cfg.db.DeleteTOTP(userID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handlerLoginMFA finishes a login started by handlerLogin, exchanging the
+// MFA token and a TOTP or recovery code for a session.
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) error {
+	type parameters struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't parse request body", err)
+	}
+
+	userID, err := auth.ValidateMFAToken(params.MFAToken, cfg.jwtKeys)
+	if err != nil {
+		return NewApiError(http.StatusUnauthorized, "Login expired, please log in again", err)
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if user == nil {
+		return NewApiError(http.StatusUnauthorized, "Login expired, please log in again", nil)
+	}
+	if user.DisabledAt != nil {
+		return NewApiError(http.StatusForbidden, "Account is disabled", nil)
+	}
+
+	enabled, err := cfg.checkSecondFactor(w, userID, params.Code)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		// 2FA was turned off since the password was checked, which
+		// needed the second factor anyway.
+		return NewApiError(http.StatusUnauthorized, "Login expired, please log in again", nil)
+	}
+
+	return cfg.respondWithNewSession(w, r, *user)
+}
+
+// respondWithMFAChallenge is sent instead of a session when the password
+// was right but the user still has to enter a second factor.
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, userID uuid.UUID) error {
+	type response struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	token, err := auth.MakeMFAToken(userID, cfg.jwtKeys, mfaTokenDuration)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		MFARequired: true,
+		MFAToken:    token,
+	})
+	return nil
+}
+
+// mfaEnabled reports whether the user has confirmed a TOTP credential.
+func (cfg *apiConfig) mfaEnabled(userID uuid.UUID) (bool, error) {
+	cred, err := cfg.db.GetTOTPCredential(userID)
+	if err != nil {
+		return false, err
+	}
+	return cred != nil && cred.ConfirmedAt != nil, nil
+}
+
+// checkSecondFactor verifies a TOTP code or, failing that, a recovery code.
+// It reports false without checking anything if the user doesn't have
+// two-factor authentication enabled, and returns an ApiError if the code
+// is wrong.
+func (cfg *apiConfig) checkSecondFactor(w http.ResponseWriter, userID uuid.UUID, code string) (bool, error) {
+	cred, err := cfg.db.GetTOTPCredential(userID)
+	if err != nil {
+		return false, NewInternalServerError(err)
+	}
+	if cred == nil || cred.ConfirmedAt == nil {
+		return false, nil
+	}
+
+	// Six digits are easy to guess given enough tries.
+	throttle := mfaThrottleKey(userID)
+	err = cfg.reserveAttempt(w, throttle)
+	if err != nil {
+		return true, err
+	}
+
+	ok := false
+	if _, err := strconv.Atoi(code); err == nil {
+		step, valid := auth.ValidateTOTP(cred.Secret, code, time.Now())
+		if valid {
+			ok, err = cfg.db.UseTOTPStep(userID, step)
+			if err != nil {
+				return true, NewInternalServerError(err)
+			}
+		}
+	} else {
+		ok, err = cfg.db.UseRecoveryCode(userID, auth.NormalizeRecoveryCode(code))
+		if err != nil {
+			return true, NewInternalServerError(err)
+		}
+	}
+
+	if !ok {
+		return true, NewApiError(http.StatusUnauthorized, "Invalid code", nil)
+	}
+
+	err = cfg.clearFailures(throttle)
+	if err != nil {
+		return true, err
+	}
+	return true, nil
+}
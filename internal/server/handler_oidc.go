@@ -0,0 +1,200 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/andycostintoma/tubely/internal/oidc"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	oidcLoginDuration = 10 * time.Minute
+
+	// oidcStateCookie ties a login to the browser that started it, so
+	// nobody can finish their own login in someone else's browser. Lax
+	// rather than Strict, since the browser arrives back from the provider.
+	oidcStateCookie = "tubely_oidc_state"
+)
+
+// handlerOIDCLogin sends the browser to the identity provider. The state,
+// nonce and PKCE verifier are kept server side until the callback, and the
+// state is also set in a cookie.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) error {
+	if cfg.oidcProvider == nil {
+		return NewApiError(http.StatusNotFound, "SSO is not configured", nil)
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	authURL, err := cfg.oidcProvider.AuthCodeURL(r.Context(), state, nonce, oidc.S256Challenge(verifier))
+	if err != nil {
+		return NewApiError(http.StatusBadGateway, "Couldn't reach the identity provider", err)
+	}
+
+	err = cfg.db.CreateOIDCLogin(state, database.OIDCLogin{
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().UTC().Add(oidcLoginDuration),
+	})
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc/",
+		MaxAge:   int(oidcLoginDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return nil
+}
+
+// handlerOIDCCallback completes an SSO login with the code and state the
+// identity provider redirected back with. Users are matched by their
+// identity at the provider, or else linked by verified email, creating an
+// account if there's none yet.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) error {
+	type parameters struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	if cfg.oidcProvider == nil {
+		return NewApiError(http.StatusNotFound, "SSO is not configured", nil)
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't parse request body", err)
+	}
+	if params.Code == "" || params.State == "" {
+		return NewApiError(http.StatusBadRequest, "code and state are required", nil)
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(params.State)) != 1 {
+		logSecurityEvent(r, "oidc_state_mismatch", "callback without a matching state cookie")
+		return NewApiError(http.StatusBadRequest, "Login wasn't started in this browser, please try again", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     "/api/oidc/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	login, err := cfg.db.TakeOIDCLogin(params.State)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if login == nil || time.Now().After(login.ExpiresAt) {
+		return NewApiError(http.StatusBadRequest, "Login expired or already used, please try again", nil)
+	}
+
+	claims, err := cfg.oidcProvider.Exchange(r.Context(), params.Code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		logSecurityEvent(r, "oidc_login_failed", "%v", err)
+		return NewApiError(http.StatusUnauthorized, "Couldn't verify your identity", err)
+	}
+
+	user, err := cfg.db.GetUserByIdentity(claims.Issuer, claims.Subject)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if user == nil {
+		user, err = cfg.linkOIDCIdentity(claims)
+		if err != nil {
+			return err
+		}
+	}
+
+	if user.DisabledAt != nil {
+		return NewApiError(http.StatusForbidden, "Account is disabled", nil)
+	}
+
+	// The provider only stands in for the password; users who turned on
+	// two-factor authentication still have to enter a code.
+	mfa, err := cfg.mfaEnabled(user.ID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if mfa {
+		return cfg.respondWithMFAChallenge(w, user.ID)
+	}
+
+	return cfg.respondWithNewSession(w, r, *user)
+}
+
+// linkOIDCIdentity attaches a new identity to the account with the same
+// email. Only emails verified both by the provider and on our side are
+// trusted, since anyone could otherwise take over an account by claiming
+// its email.
+func (cfg *apiConfig) linkOIDCIdentity(claims oidc.Claims) (*database.User, error) {
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, NewApiError(http.StatusForbidden, "Your identity provider hasn't verified your email", nil)
+	}
+
+	existing, err := cfg.db.GetUserByEmail(claims.Email)
+	if err != nil {
+		return nil, NewInternalServerError(err)
+	}
+
+	// An unverified account may have been registered by someone who
+	// doesn't own the email, and linking would leave their password
+	// working on it.
+	if existing.ID != uuid.Nil && existing.EmailVerifiedAt == nil {
+		return nil, NewApiError(http.StatusConflict, "An account with this email exists but its email isn't verified. Log in with your password and verify it first", nil)
+	}
+
+	user := &existing
+	if existing.ID == uuid.Nil {
+		// Accounts created through SSO have no password, so they can only
+		// sign in through the provider.
+		user, err = cfg.db.CreateUser(database.CreateUserParams{Email: claims.Email})
+		if err != nil {
+			return nil, NewApiError(http.StatusInternalServerError, "Couldn't create user", err)
+		}
+	}
+
+	err = cfg.db.CreateUserIdentity(database.UserIdentity{
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		UserID:  user.ID,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		return nil, NewInternalServerError(err)
+	}
+
+	// The provider vouched for the email, which is as good as the user
+	// following a verification link.
+	_, err = cfg.db.SetUserEmailVerified(user.ID, claims.Email)
+	if err != nil {
+		return nil, NewInternalServerError(err)
+	}
+	return cfg.db.GetUser(user.ID)
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/andycostintoma/tubely/internal/oidc"
+	"github.com/andycostintoma/tubely/internal/oidc/oidctest"
+	"github.com/google/uuid"
+)
+
+const testRedirectURL = "https://tubely.example/app/"
+
+type ssoTest struct {
+	cfg      *apiConfig
+	srv      *httptest.Server
+	client   *http.Client
+	provider *oidctest.Provider
+}
+
+func newSSOTest(t *testing.T) *ssoTest {
+	t.Helper()
+	provider, providerSrv, err := oidctest.NewServer("tubely", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(providerSrv.Close)
+
+	cfg := newTestConfig(t)
+	cfg.oidcProvider = oidc.NewProvider(oidc.Config{
+		Issuer:      provider.Issuer,
+		ClientID:    "tubely",
+		RedirectURL: testRedirectURL,
+	})
+	srv, client := newTestServer(t, cfg)
+	return &ssoTest{cfg: cfg, srv: srv, client: client, provider: provider}
+}
+
+// authorize starts a login and approves it at the provider as email,
+// returning the code and state the provider redirected back with.
+func (st *ssoTest) authorize(t *testing.T, email string, emailVerified bool) (code, state string) {
+	t.Helper()
+	resp, err := st.client.Get(st.srv.URL + "/api/oidc/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login returned %d, want a redirect", resp.StatusCode)
+	}
+	authURL, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := authURL.Query()
+	if !strings.HasPrefix(authURL.String(), st.provider.Issuer+"/authorize") {
+		t.Fatalf("redirected to %s, want the discovered authorization endpoint", authURL)
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization request %s has no S256 PKCE challenge", authURL)
+	}
+	if q.Get("state") == "" || q.Get("nonce") == "" {
+		t.Fatalf("authorization request %s has no state or nonce", authURL)
+	}
+
+	// The mock provider approves a login_hint in the query as verified,
+	// and one posted from its login form as whatever the checkbox says.
+	noRedirect := &http.Client{CheckRedirect: st.client.CheckRedirect}
+	form := url.Values{"login_hint": {email}}
+	if emailVerified {
+		form.Set("email_verified", "true")
+	}
+	resp, err = noRedirect.PostForm(authURL.String(), form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(callback.String(), testRedirectURL) {
+		t.Fatalf("provider redirected to %s, want %s", callback, testRedirectURL)
+	}
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+type ssoResponse struct {
+	ID            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	EmailVerified *string   `json:"email_verified_at"`
+	Token         string    `json:"token"`
+	MFARequired   bool      `json:"mfa_required"`
+	Error         string    `json:"error"`
+}
+
+func (st *ssoTest) callback(t *testing.T, client *http.Client, code, state string) (int, ssoResponse) {
+	t.Helper()
+	var resp ssoResponse
+	status := doJSON(t, client, http.MethodPost, st.srv.URL+"/api/oidc/callback", "", map[string]string{
+		"code":  code,
+		"state": state,
+	}, &resp)
+	return status, resp
+}
+
+func (st *ssoTest) login(t *testing.T, email string, emailVerified bool) (int, ssoResponse) {
+	t.Helper()
+	code, state := st.authorize(t, email, emailVerified)
+	return st.callback(t, st.client, code, state)
+}
+
+func TestOIDCLoginCreatesAccount(t *testing.T) {
+	st := newSSOTest(t)
+
+	status, first := st.login(t, "new@example.com", true)
+	if status != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", status, first.Error)
+	}
+	if first.Token == "" || first.Email != "new@example.com" || first.EmailVerified == nil {
+		t.Errorf("got %+v, want a session for a verified new@example.com", first)
+	}
+
+	// The identity is remembered, so the next login finds the same user.
+	status, second := st.login(t, "new@example.com", true)
+	if status != http.StatusOK {
+		t.Fatalf("second callback returned %d: %s", status, second.Error)
+	}
+	if second.ID != first.ID {
+		t.Errorf("second login got user %v, want %v", second.ID, first.ID)
+	}
+}
+
+func TestOIDCLoginLinksVerifiedAccount(t *testing.T) {
+	st := newSSOTest(t)
+	user := signUp(t, st.cfg, "linked@example.com", true)
+
+	status, resp := st.login(t, "linked@example.com", true)
+	if status != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", status, resp.Error)
+	}
+	if resp.ID != user.ID {
+		t.Errorf("logged in as %v, want the existing account %v", resp.ID, user.ID)
+	}
+}
+
+func TestOIDCLoginRefusesUnverifiedAccount(t *testing.T) {
+	st := newSSOTest(t)
+	user := signUp(t, st.cfg, "squatted@example.com", false)
+
+	// Whoever registered the address without verifying it mustn't end up
+	// sharing an account with its real owner. Nothing is linked, so trying
+	// again is refused too.
+	for range 2 {
+		status, resp := st.login(t, "squatted@example.com", true)
+		if status != http.StatusConflict {
+			t.Fatalf("callback returned %d, want %d", status, http.StatusConflict)
+		}
+		if resp.Token != "" {
+			t.Fatal("got a session for the unverified account")
+		}
+	}
+	unchanged, err := st.cfg.db.GetUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.EmailVerifiedAt != nil {
+		t.Error("the unverified account's email was marked verified")
+	}
+}
+
+func TestOIDCLoginRejectsUnverifiedEmail(t *testing.T) {
+	st := newSSOTest(t)
+	signUp(t, st.cfg, "victim@example.com", true)
+
+	for _, email := range []string{"victim@example.com", "nobody@example.com"} {
+		status, resp := st.login(t, email, false)
+		if status != http.StatusForbidden {
+			t.Errorf("%s: callback returned %d, want %d", email, status, http.StatusForbidden)
+		}
+		if resp.Token != "" {
+			t.Errorf("%s: got a session for an email the provider didn't verify", email)
+		}
+	}
+
+	user, err := st.cfg.db.GetUserByEmail("nobody@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != uuid.Nil {
+		t.Error("an account was created for an unverified email")
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	st := newSSOTest(t)
+
+	// Someone else's code and state, replayed in a browser that didn't
+	// start the login, are refused before they're used.
+	code, state := st.authorize(t, "attacker@example.com", true)
+	victim := newTestClient(t, st.srv)
+	status, _ := st.callback(t, victim, code, state)
+	if status != http.StatusBadRequest {
+		t.Errorf("callback without the state cookie returned %d, want %d", status, http.StatusBadRequest)
+	}
+
+	status, _ = st.callback(t, st.client, code, "forged")
+	if status != http.StatusBadRequest {
+		t.Errorf("callback with the wrong state returned %d, want %d", status, http.StatusBadRequest)
+	}
+
+	// The state is single use.
+	code, state = st.authorize(t, "user@example.com", true)
+	if status, resp := st.callback(t, st.client, code, state); status != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", status, resp.Error)
+	}
+	if status, _ := st.callback(t, st.client, code, state); status != http.StatusBadRequest {
+		t.Errorf("replayed callback returned %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCLoginRequiresSecondFactor(t *testing.T) {
+	st := newSSOTest(t)
+	user := signUp(t, st.cfg, "mfa@example.com", true)
+	if err := st.cfg.db.SetPendingTOTPSecret(user.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.cfg.db.ConfirmTOTP(user.ID, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	status, resp := st.login(t, "mfa@example.com", true)
+	if status != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", status, resp.Error)
+	}
+	if !resp.MFARequired || resp.Token != "" {
+		t.Errorf("got %+v, want an MFA challenge instead of a session", resp)
+	}
+}
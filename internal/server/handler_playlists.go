@@ -0,0 +1,281 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andycostintoma/tubely/internal/database"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const maxPlaylistTitleLength = 200
+
+func (cfg *apiConfig) handlerPlaylistCreate(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	title, err := validatePlaylistTitle(params.Title)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, err.Error(), err)
+	}
+
+	playlist, err := cfg.db.CreatePlaylist(database.CreatePlaylistParams{
+		Title:       title,
+		Description: params.Description,
+		UserID:      userID,
+	})
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't create playlist", err)
+	}
+
+	respondWithJSON(w, http.StatusCreated, playlist)
+	return nil
+}
+
+func (cfg *apiConfig) handlerPlaylistsRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	playlists, err := cfg.db.GetPlaylists(userID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve playlists", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, playlists)
+	return nil
+}
+
+func (cfg *apiConfig) handlerPlaylistGet(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	playlist, err := cfg.getPlaylistForUser(r, userID)
+	if err != nil {
+		return err
+	}
+	return cfg.respondWithPlaylist(w, r, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistUpdate(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+	}
+
+	playlist, err := cfg.getPlaylistForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	if params.Title != nil {
+		playlist.Title, err = validatePlaylistTitle(*params.Title)
+		if err != nil {
+			return NewApiError(http.StatusBadRequest, err.Error(), err)
+		}
+	}
+	if params.Description != nil {
+		playlist.Description = *params.Description
+	}
+
+	err = cfg.db.UpdatePlaylist(playlist)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't update playlist", err)
+	}
+
+	playlist, err = cfg.db.GetPlaylist(playlist.ID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	respondWithJSON(w, http.StatusOK, playlist)
+	return nil
+}
+
+func (cfg *apiConfig) handlerPlaylistDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	playlist, err := cfg.getPlaylistForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.db.DeletePlaylist(playlist.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't delete playlist", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (cfg *apiConfig) handlerPlaylistItemAdd(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		VideoID  uuid.UUID `json:"video_id"`
+		Position *int      `json:"position"`
+	}
+
+	playlist, err := cfg.getPlaylistForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	video, err := cfg.db.GetVideo(params.VideoID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if video.ID == uuid.Nil {
+		return NewApiError(http.StatusNotFound, "Couldn't get video", nil)
+	}
+	access, err := cfg.videoAccessFor(userID, video)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if access < videoAccessView {
+		return NewApiError(http.StatusNotFound, "Couldn't get video", nil)
+	}
+
+	position := -1
+	if params.Position != nil {
+		if *params.Position < 0 {
+			return NewApiError(http.StatusBadRequest, "Position must not be negative", nil)
+		}
+		position = *params.Position
+	}
+
+	err = cfg.db.InsertPlaylistItem(playlist.ID, video.ID, position)
+	if errors.Is(err, database.ErrPlaylistItemExists) {
+		return NewApiError(http.StatusConflict, "Video is already in the playlist", err)
+	}
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't add video to playlist", err)
+	}
+
+	return cfg.respondWithPlaylist(w, r, http.StatusOK, playlist)
+}
+
+func (cfg *apiConfig) handlerPlaylistItemDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	playlist, err := cfg.getPlaylistForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid video ID", err)
+	}
+
+	err = cfg.db.RemovePlaylistItem(playlist.ID, videoID)
+	if errors.Is(err, database.ErrPlaylistItemNotFound) {
+		return NewApiError(http.StatusNotFound, "Video is not in the playlist", err)
+	}
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't remove video from playlist", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (cfg *apiConfig) handlerPlaylistReorder(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		VideoIDs []uuid.UUID `json:"video_ids"`
+	}
+
+	playlist, err := cfg.getPlaylistForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	err = cfg.db.ReorderPlaylistItems(playlist.ID, params.VideoIDs)
+	if errors.Is(err, database.ErrPlaylistOrder) {
+		return NewApiError(http.StatusBadRequest, "video_ids must list every video in the playlist exactly once", err)
+	}
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't reorder playlist", err)
+	}
+
+	return cfg.respondWithPlaylist(w, r, http.StatusOK, playlist)
+}
+
+// respondWithPlaylist writes the playlist with its videos in order, their
+// URLs resolved the same way as in the videos endpoints.
+func (cfg *apiConfig) respondWithPlaylist(w http.ResponseWriter, r *http.Request, code int, playlist database.Playlist) error {
+	type response struct {
+		database.Playlist
+		Videos []database.Video `json:"videos"`
+	}
+
+	playlist, err := cfg.db.GetPlaylist(playlist.ID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	videos, err := cfg.db.GetPlaylistVideos(playlist.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve playlist videos", err)
+	}
+
+	videos, err = cfg.resolveVideoURLs(r.Context(), videos)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	respondWithJSON(w, code, response{
+		Playlist: playlist,
+		Videos:   videos,
+	})
+	return nil
+}
+
+func (cfg *apiConfig) getPlaylistForUser(r *http.Request, userID uuid.UUID) (database.Playlist, error) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		return database.Playlist{}, NewApiError(http.StatusBadRequest, "Invalid playlist ID", err)
+	}
+
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		return database.Playlist{}, NewInternalServerError(err)
+	}
+	if playlist.ID == uuid.Nil || playlist.UserID != userID {
+		return database.Playlist{}, NewApiError(http.StatusNotFound, "Couldn't get playlist", nil)
+	}
+	return playlist, nil
+}
+
+func validatePlaylistTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "", errors.New("Title is required")
+	}
+	if utf8.RuneCountInString(title) > maxPlaylistTitleLength {
+		return "", fmt.Errorf("Title must be at most %d characters", maxPlaylistTitleLength)
+	}
+	return title, nil
+}
@@ -1,51 +1,126 @@
 package server
 
 import (
+	"errors"
 	"github.com/andycostintoma/tubely/internal/auth"
+	"github.com/andycostintoma/tubely/internal/database"
 	"net/http"
 	"time"
 )
 
+const refreshTokenDuration = time.Hour * 24 * 60
+
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) error {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	refreshToken, fromCookie, err := refreshTokenFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	storedToken, err := cfg.db.GetRefreshToken(refreshToken)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if storedToken.TokenHash == "" {
+		return NewApiError(http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+	}
+	if storedToken.RotatedAt != nil {
+		return cfg.handleRefreshTokenReuse(r, storedToken)
+	}
+	if storedToken.RevokedAt != nil {
+		return NewApiError(http.StatusUnauthorized, "Refresh token has been revoked", nil)
+	}
+	if time.Now().After(storedToken.ExpiresAt) {
+		return NewApiError(http.StatusUnauthorized, "Refresh token has expired", nil)
+	}
+
+	user, err := cfg.db.GetUser(storedToken.UserID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if user == nil || user.DisabledAt != nil {
+		return NewApiError(http.StatusUnauthorized, "Account is disabled", nil)
 	}
 
-	refreshToken, err := auth.GetBearerToken(r.Header)
+	newRefreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		return NewApiError(http.StatusUnauthorized, "Unauthorized: Missing or invalid token", err)
+		return NewInternalServerError(err)
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	_, err = cfg.db.RotateRefreshToken(refreshToken, database.CreateRefreshTokenParams{
+		Token:     newRefreshToken,
+		UserID:    storedToken.UserID,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenDuration),
+		FamilyID:  storedToken.FamilyID,
+		UserAgent: r.UserAgent(),
+		IPAddress: cfg.clientIP(r),
+	})
+	if errors.Is(err, database.ErrRefreshTokenReused) {
+		return cfg.handleRefreshTokenReuse(r, storedToken)
+	}
 	if err != nil {
-		return NewApiError(http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		return NewInternalServerError(err)
 	}
 
+	expiresIn := time.Hour
+	if fromCookie {
+		expiresIn = cookieAccessTokenDuration
+	}
 	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
+		auth.AccessToken{UserID: user.ID, Scopes: auth.AllScopes, Role: string(user.Role)},
+		cfg.jwtKeys,
+		expiresIn,
 	)
 	if err != nil {
 		return NewApiError(http.StatusUnauthorized, "Couldn't validate token", err)
 	}
 
+	if fromCookie {
+		_, err = setSessionCookies(w, r, accessToken, newRefreshToken)
+		if err != nil {
+			return NewInternalServerError(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
 	})
 	return nil
 }
 
+// handleRefreshTokenReuse revokes every token in the family of a refresh
+// token that was presented after it had already been rotated, since either
+// the legitimate client or an attacker is holding a stolen copy.
+func (cfg *apiConfig) handleRefreshTokenReuse(r *http.Request, token database.RefreshToken) error {
+	logSecurityEvent(r, "refresh_token_reuse", "user %v presented a rotated refresh token, revoking family %v", token.UserID, token.FamilyID)
+
+	err := cfg.db.RevokeRefreshTokenFamily(token.FamilyID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	return NewApiError(http.StatusUnauthorized, "Refresh token has already been used", database.ErrRefreshTokenReused)
+}
+
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) error {
-	refreshToken, err := auth.GetBearerToken(r.Header)
+	refreshToken, fromCookie, err := refreshTokenFromRequest(r)
 	if err != nil {
-		return NewApiError(http.StatusUnauthorized, "Couldn't find token", err)
+		return err
 	}
 
 	err = cfg.db.RevokeRefreshToken(refreshToken)
 	if err != nil {
 		return NewApiError(http.StatusUnauthorized, "Couldn't revoke token", err)
 	}
+	if fromCookie {
+		clearSessionCookies(w)
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 	return nil
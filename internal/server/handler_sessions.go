@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerSessionsRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := cfg.db.GetSessions(userID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve sessions", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+	return nil
+}
+
+func (cfg *apiConfig) handlerSessionDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	found, err := cfg.db.RevokeSession(userID, r.PathValue("sessionID"))
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't revoke session", err)
+	}
+	if !found {
+		return NewApiError(http.StatusNotFound, "Session not found", nil)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (cfg *apiConfig) handlerSessionsDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.db.RevokeAllSessions(userID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't revoke sessions", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
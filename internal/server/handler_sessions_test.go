@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/andycostintoma/tubely/internal/database"
+)
+
+func TestSessionDelete(t *testing.T) {
+	cfg := newTestConfig(t)
+	signUp(t, cfg, "sessions@example.com", true)
+	srv, client := newTestServer(t, cfg)
+	token := logIn(t, client, srv, "sessions@example.com")
+	logIn(t, newTestClient(t, srv), srv, "sessions@example.com")
+
+	var sessions []database.Session
+	if status := doJSON(t, client, http.MethodGet, srv.URL+"/api/sessions", token, nil, &sessions); status != http.StatusOK {
+		t.Fatalf("listing sessions returned %d", status)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+
+	url := srv.URL + "/api/sessions/" + sessions[1].ID
+	if status := doJSON(t, client, http.MethodDelete, url, token, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("revoking a session returned %d", status)
+	}
+	// It's gone, so revoking it again finds nothing.
+	if status := doJSON(t, client, http.MethodDelete, url, token, nil, nil); status != http.StatusNotFound {
+		t.Errorf("revoking the session again returned %d, want %d", status, http.StatusNotFound)
+	}
+	if status := doJSON(t, client, http.MethodGet, srv.URL+"/api/sessions", token, nil, &sessions); status != http.StatusOK || len(sessions) != 1 {
+		t.Errorf("got %d sessions after revoking one, want 1", len(sessions))
+	}
+}
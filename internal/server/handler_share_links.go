@@ -0,0 +1,332 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/andycostintoma/tubely/internal/auth"
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/andycostintoma/tubely/internal/utils"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultShareLinkLifetime = 7 * 24 * time.Hour
+	maxShareLinkLifetime     = 30 * 24 * time.Hour
+	// shareLinkURLExpiry bounds how long a presigned URL handed out through
+	// a share link keeps working, so revoking the link takes effect soon.
+	shareLinkURLExpiry = 5 * time.Minute
+	// shareSessionDuration is how long a browser can keep watching after
+	// opening a link, without counting another view.
+	shareSessionDuration = 4 * time.Hour
+)
+
+func (cfg *apiConfig) handlerShareLinkCreate(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+		MaxViews  *int       `json:"max_views"`
+		Password  string     `json:"password"`
+	}
+	type response struct {
+		database.ShareLink
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}
+
+	video, err := cfg.getVideoForUser(r, userID, videoAccessManage)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	expiresAt := time.Now().Add(defaultShareLinkLifetime)
+	if params.ExpiresAt != nil {
+		expiresAt = *params.ExpiresAt
+	}
+	if !expiresAt.After(time.Now()) {
+		return NewApiError(http.StatusBadRequest, "Expiry must be in the future", nil)
+	}
+	if expiresAt.After(time.Now().Add(maxShareLinkLifetime)) {
+		return NewApiError(http.StatusBadRequest, "Share links can last at most 30 days", nil)
+	}
+	if params.MaxViews != nil && *params.MaxViews < 1 {
+		return NewApiError(http.StatusBadRequest, "Max views must be at least 1", nil)
+	}
+
+	passwordHash := ""
+	if params.Password != "" {
+		passwordHash, err = auth.HashPassword(params.Password)
+		if err != nil {
+			return NewApiError(http.StatusInternalServerError, "Couldn't hash password", err)
+		}
+	}
+
+	tokenBytes, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	link, err := cfg.db.CreateShareLink(database.CreateShareLinkParams{
+		VideoID:      video.ID,
+		UserID:       userID,
+		Token:        token,
+		PasswordHash: passwordHash,
+		ExpiresAt:    expiresAt,
+		MaxViews:     params.MaxViews,
+	})
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't create share link", err)
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		ShareLink: link,
+		Token:     token,
+		URL:       cfg.appURL(url.Values{"share": {token}}),
+	})
+	return nil
+}
+
+func (cfg *apiConfig) handlerShareLinksRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	video, err := cfg.getVideoForUser(r, userID, videoAccessManage)
+	if err != nil {
+		return err
+	}
+
+	links, err := cfg.db.GetShareLinks(video.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve share links", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, links)
+	return nil
+}
+
+func (cfg *apiConfig) handlerShareLinkDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	video, err := cfg.getVideoForUser(r, userID, videoAccessManage)
+	if err != nil {
+		return err
+	}
+
+	linkID, err := uuid.Parse(r.PathValue("linkID"))
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid ID", err)
+	}
+
+	link, err := cfg.db.GetShareLink(linkID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if link.ID == uuid.Nil || link.VideoID != video.ID {
+		return NewApiError(http.StatusNotFound, "Share link not found", nil)
+	}
+
+	err = cfg.db.RevokeShareLink(link.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't revoke share link", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handlerShareLinkOpen lets anyone holding a share link watch its video.
+// The token travels in the body rather than the path so it doesn't end up
+// in logs. Opening the link counts a view and starts a share session, kept
+// in a cookie; opening it again during the session counts nothing more.
+// The video URL handed out points at handlerShareLinkMedia rather than the
+// file itself.
+func (cfg *apiConfig) handlerShareLinkOpen(w http.ResponseWriter, r *http.Request) error {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	type response struct {
+		Video          database.Video `json:"video"`
+		ExpiresAt      time.Time      `json:"expires_at"`
+		ViewsRemaining *int           `json:"views_remaining"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	link, err := cfg.db.GetShareLinkByToken(params.Token)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if link.ID == uuid.Nil {
+		return NewApiError(http.StatusNotFound, "Share link not found", nil)
+	}
+
+	session, err := cfg.getShareSession(r, link.ID)
+	if err != nil {
+		return err
+	}
+	resumed := session.LinkID == link.ID
+	if !link.Usable(time.Now()) && !(resumed && link.Active(time.Now())) {
+		return NewApiError(http.StatusGone, "Share link is no longer available", nil)
+	}
+
+	if link.HasPassword && !resumed {
+		if params.Password == "" {
+			return NewApiError(http.StatusUnauthorized, "Password required", nil)
+		}
+		throttle := shareLinkThrottleKey(link.ID)
+		err = cfg.reserveAttempt(w, throttle)
+		if err != nil {
+			return err
+		}
+		err = auth.CheckPasswordHash(params.Password, link.PasswordHash)
+		if err != nil {
+			logSecurityEvent(r, "share_link_password_failed", "link=%s", link.ID)
+			return NewApiError(http.StatusUnauthorized, "Incorrect password", err)
+		}
+		err = cfg.releaseAttempt(throttle)
+		if err != nil {
+			return err
+		}
+	}
+
+	video, err := cfg.db.GetVideo(link.VideoID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if video.ID == uuid.Nil {
+		return NewApiError(http.StatusNotFound, "Share link not found", nil)
+	}
+
+	if !resumed {
+		tokenBytes, err := utils.GenerateRandomBytes(32)
+		if err != nil {
+			return NewInternalServerError(err)
+		}
+		sessionToken := base64.RawURLEncoding.EncodeToString(tokenBytes)
+		expiresAt := time.Now().Add(shareSessionDuration)
+		if link.ExpiresAt.Before(expiresAt) {
+			expiresAt = link.ExpiresAt
+		}
+
+		opened, err := cfg.db.OpenShareSession(link.ID, sessionToken, expiresAt)
+		if err != nil {
+			return NewInternalServerError(err)
+		}
+		if !opened {
+			return NewApiError(http.StatusGone, "Share link is no longer available", nil)
+		}
+		link.ViewCount++
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     shareSessionCookie(link.ID),
+			Value:    sessionToken,
+			Path:     "/",
+			Expires:  expiresAt,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	if video.VideoURL != nil {
+		mediaURL := cfg.shareMediaURL(link.ID)
+		video.VideoURL = &mediaURL
+	}
+
+	var remaining *int
+	if link.MaxViews != nil {
+		n := *link.MaxViews - link.ViewCount
+		remaining = &n
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response{
+		Video:          video,
+		ExpiresAt:      link.ExpiresAt,
+		ViewsRemaining: remaining,
+	})
+	return nil
+}
+
+// handlerShareLinkMedia redirects a share session to a short-lived URL of
+// the video's file. The link is checked again on every request, so revoking
+// it or letting it expire stops playback the next time the player asks for
+// the file.
+func (cfg *apiConfig) handlerShareLinkMedia(w http.ResponseWriter, r *http.Request) error {
+	linkID, err := uuid.Parse(r.PathValue("linkID"))
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid ID", err)
+	}
+
+	session, err := cfg.getShareSession(r, linkID)
+	if err != nil {
+		return err
+	}
+	if session.LinkID != linkID {
+		return NewApiError(http.StatusNotFound, "Share link not found", nil)
+	}
+
+	link, err := cfg.db.GetShareLink(linkID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if link.ID == uuid.Nil {
+		return NewApiError(http.StatusNotFound, "Share link not found", nil)
+	}
+	if !link.Active(time.Now()) {
+		return NewApiError(http.StatusGone, "Share link is no longer available", nil)
+	}
+
+	video, err := cfg.db.GetVideo(link.VideoID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if video.ID == uuid.Nil || video.VideoURL == nil {
+		return NewApiError(http.StatusNotFound, "Video has no file yet", nil)
+	}
+
+	video, err = cfg.dbVideoToSignedVideo(r.Context(), video, shareLinkURLExpiry)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, *video.VideoURL, http.StatusFound)
+	return nil
+}
+
+// getShareSession returns the request's share session for the link, or a
+// zero ShareSession if it has none.
+func (cfg *apiConfig) getShareSession(r *http.Request, linkID uuid.UUID) (database.ShareSession, error) {
+	cookie, err := r.Cookie(shareSessionCookie(linkID))
+	if err != nil || cookie.Value == "" {
+		return database.ShareSession{}, nil
+	}
+	session, err := cfg.db.GetShareSession(cookie.Value)
+	if err != nil {
+		return database.ShareSession{}, NewInternalServerError(err)
+	}
+	return session, nil
+}
+
+// shareSessionCookie names the cookie per link, so a browser can watch
+// several links at once.
+func shareSessionCookie(linkID uuid.UUID) string {
+	return "tubely_share_" + linkID.String()
+}
+
+func (cfg *apiConfig) shareMediaURL(linkID uuid.UUID) string {
+	return fmt.Sprintf("%s:%s/share/%s/media", cfg.serverURL, cfg.port, linkID)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andycostintoma/tubely/internal/database"
+)
+
+func TestShareLinkSession(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner := signUp(t, cfg, "owner@example.com", true)
+	srv, client := newTestServer(t, cfg)
+	token := logIn(t, client, srv, "owner@example.com")
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Video", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoURL := "tubely-test,landscape/video.mp4"
+	video.VideoURL = &videoURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+
+	var link struct {
+		database.ShareLink
+		Token string `json:"token"`
+	}
+	url := srv.URL + "/api/videos/" + video.ID.String() + "/share_links"
+	if status := doJSON(t, client, http.MethodPost, url, token, map[string]any{"max_views": 1}, &link); status != http.StatusCreated {
+		t.Fatalf("creating a share link returned %d", status)
+	}
+
+	type opened struct {
+		Video          database.Video `json:"video"`
+		ViewsRemaining *int           `json:"views_remaining"`
+	}
+	viewer := newTestClient(t, srv)
+	var resp opened
+	if status := doJSON(t, viewer, http.MethodPost, srv.URL+"/api/share", "", map[string]string{"token": link.Token}, &resp); status != http.StatusOK {
+		t.Fatalf("opening the link returned %d", status)
+	}
+	if resp.ViewsRemaining == nil || *resp.ViewsRemaining != 0 {
+		t.Errorf("got %v views remaining, want 0", resp.ViewsRemaining)
+	}
+	mediaURL := srv.URL + "/share/" + link.ID.String() + "/media"
+	if resp.Video.VideoURL == nil || !strings.HasSuffix(*resp.Video.VideoURL, "/share/"+link.ID.String()+"/media") {
+		t.Fatalf("got video URL %v, want the share media URL", resp.Video.VideoURL)
+	}
+
+	// The session keeps playing, and reopening the link counts no more
+	// views, even though the link has used up its one view.
+	for i := 0; i < 3; i++ {
+		res, err := viewer.Get(mediaURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusFound || !strings.Contains(res.Header.Get("Location"), "landscape/video.mp4") {
+			t.Fatalf("media request %d returned %d to %q, want a redirect to the file", i, res.StatusCode, res.Header.Get("Location"))
+		}
+	}
+	if status := doJSON(t, viewer, http.MethodPost, srv.URL+"/api/share", "", map[string]string{"token": link.Token}, &resp); status != http.StatusOK {
+		t.Errorf("reopening the link in the same session returned %d", status)
+	}
+	stored, err := cfg.db.GetShareLink(link.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ViewCount != 1 {
+		t.Errorf("counted %d views, want 1", stored.ViewCount)
+	}
+
+	// Anyone else is out of views and can't fetch the file.
+	other := newTestClient(t, srv)
+	if status := doJSON(t, other, http.MethodPost, srv.URL+"/api/share", "", map[string]string{"token": link.Token}, nil); status != http.StatusGone {
+		t.Errorf("opening a used-up link returned %d, want %d", status, http.StatusGone)
+	}
+	if res, err := other.Get(mediaURL); err != nil || res.StatusCode != http.StatusNotFound {
+		t.Errorf("media request without a session got %v, %v; want %d", res.StatusCode, err, http.StatusNotFound)
+	}
+
+	// Revoking the link stops the session at its next request.
+	if status := doJSON(t, client, http.MethodDelete, url+"/"+link.ID.String(), token, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("revoking the link returned %d", status)
+	}
+	if res, err := viewer.Get(mediaURL); err != nil || res.StatusCode != http.StatusGone {
+		t.Errorf("media request after revoking got %v, %v; want %d", res.StatusCode, err, http.StatusGone)
+	}
+}
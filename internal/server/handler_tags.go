@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/andycostintoma/tubely/internal/database"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerTagsRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	counts, err := cfg.db.GetTagCounts(userID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve tags", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, counts)
+	return nil
+}
+
+func (cfg *apiConfig) handlerVideoTagsRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	video, err := cfg.getVideoForUser(r, userID, videoAccessView)
+	if err != nil {
+		return err
+	}
+
+	tags, err := cfg.db.GetVideoTags(video.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve tags", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, tags)
+	return nil
+}
+
+func (cfg *apiConfig) handlerVideoTagsAdd(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Tags []string `json:"tags"`
+	}
+
+	video, err := cfg.getVideoForUser(r, userID, videoAccessEdit)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+	if len(params.Tags) == 0 {
+		return NewApiError(http.StatusBadRequest, "At least one tag is required", nil)
+	}
+	for _, name := range params.Tags {
+		if _, err := database.NormalizeTagName(name); err != nil {
+			return NewApiError(http.StatusBadRequest, "Invalid tag name", err)
+		}
+	}
+
+	err = cfg.db.AddVideoTags(video.UserID, video.ID, params.Tags)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't add tags", err)
+	}
+
+	tags, err := cfg.db.GetVideoTags(video.ID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	respondWithJSON(w, http.StatusOK, tags)
+	return nil
+}
+
+func (cfg *apiConfig) handlerVideoTagDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	video, err := cfg.getVideoForUser(r, userID, videoAccessEdit)
+	if err != nil {
+		return err
+	}
+
+	tag, err := database.NormalizeTagName(r.PathValue("tag"))
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid tag name", err)
+	}
+
+	err = cfg.db.RemoveVideoTag(video.ID, tag)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't remove tag", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"github.com/andycostintoma/tubely/internal/database"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// handlerTrashRetrieve lists the user's personal trash, or a workspace's
+// trash with ?workspace_id=.
+func (cfg *apiConfig) handlerTrashRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	workspaceID, err := cfg.workspaceFromQuery(r, userID, database.WorkspaceRoleViewer)
+	if err != nil {
+		return err
+	}
+
+	var videos []database.Video
+	if workspaceID != nil {
+		videos, err = cfg.db.GetTrashedWorkspaceVideos(*workspaceID)
+	} else {
+		videos, err = cfg.db.GetTrashedVideos(userID)
+	}
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve trash", err)
+	}
+	if videos == nil {
+		videos = []database.Video{}
+	}
+
+	respondWithJSON(w, http.StatusOK, videos)
+	return nil
+}
+
+func (cfg *apiConfig) handlerTrashRestore(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	video, err := cfg.getTrashedVideoForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.db.RestoreVideo(video.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't restore video", err)
+	}
+
+	restored, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	respondWithJSON(w, http.StatusOK, restored)
+	return nil
+}
+
+func (cfg *apiConfig) handlerTrashDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	video, err := cfg.getTrashedVideoForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.deleteVideoObjects(r.Context(), video)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't delete stored files", err)
+	}
+
+	err = cfg.db.DeleteVideo(video.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't delete video", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (cfg *apiConfig) getTrashedVideoForUser(r *http.Request, userID uuid.UUID) (database.Video, error) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		return database.Video{}, NewApiError(http.StatusBadRequest, "Invalid ID", err)
+	}
+
+	video, err := cfg.db.GetTrashedVideo(videoID)
+	if err != nil {
+		return database.Video{}, NewInternalServerError(err)
+	}
+	if video.ID == uuid.Nil {
+		return database.Video{}, NewApiError(http.StatusNotFound, "Video not found in trash", nil)
+	}
+	access, err := cfg.videoAccessFor(userID, video)
+	if err != nil {
+		return database.Video{}, NewInternalServerError(err)
+	}
+	if access < videoAccessManage {
+		return database.Video{}, NewApiError(http.StatusNotFound, "Video not found in trash", nil)
+	}
+	return video, nil
+}
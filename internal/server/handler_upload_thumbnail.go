@@ -2,7 +2,6 @@ package server
 
 import (
 	"fmt"
-	"github.com/andycostintoma/tubely/internal/database"
 	"mime"
 	"net/http"
 	"time"
@@ -22,8 +21,12 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	if err != nil {
 		return NewInternalServerError(err)
 	}
-	if videoMetadata.UserID != userID {
-		return NewApiError(http.StatusUnauthorized, "You do not have permission to upload this video", err)
+	if videoMetadata.ID == uuid.Nil {
+		return NewApiError(http.StatusNotFound, "Couldn't get video", nil)
+	}
+	err = cfg.requireVideoAccess(userID, videoMetadata, videoAccessEdit)
+	if err != nil {
+		return err
 	}
 
 	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
@@ -57,7 +60,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	var storage Storage
 	switch cfg.thumbnailsStorage {
 	case "db":
-		storage = &FSStorage{AssetsRoot: cfg.assetsRoot, ServerURL: cfg.serverURL, Port: cfg.port}
+		storage = cfg.fsStorage()
 	case "fs":
 		storage = &DBStorage{}
 	default:
@@ -69,14 +72,9 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return NewInternalServerError(err)
 	}
 
-	updatedVideo := database.Video{
-		ID:                videoMetadata.ID,
-		CreatedAt:         videoMetadata.CreatedAt,
-		UpdatedAt:         time.Now(),
-		ThumbnailURL:      &thumbnailURL,
-		VideoURL:          videoMetadata.VideoURL,
-		CreateVideoParams: videoMetadata.CreateVideoParams,
-	}
+	updatedVideo := videoMetadata
+	updatedVideo.UpdatedAt = time.Now()
+	updatedVideo.ThumbnailURL = &thumbnailURL
 
 	err = cfg.db.UpdateVideo(updatedVideo)
 	if err != nil {
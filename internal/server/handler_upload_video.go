@@ -1,15 +1,17 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/andycostintoma/tubely/internal/database"
 	"github.com/andycostintoma/tubely/internal/utils"
 	"github.com/google/uuid"
+	"log"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
-	"time"
 )
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
@@ -23,48 +25,127 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request,
 	if err != nil {
 		return NewInternalServerError(err)
 	}
-	if videoMetadata.UserID != userID {
-		return NewApiError(http.StatusUnauthorized, "You do not have permission to upload this video", err)
+	if videoMetadata.ID == uuid.Nil {
+		return NewApiError(http.StatusNotFound, "Couldn't get video", nil)
+	}
+	err = cfg.requireVideoAccess(userID, videoMetadata, videoAccessEdit)
+	if err != nil {
+		return err
 	}
 
 	fmt.Println("uploading video for video", videoID, "by user", userID)
 
+	err = cfg.db.SetVideoStatus(videoID, database.VideoStatusUploading, "")
+	if errors.Is(err, database.ErrInvalidStatusTransition) {
+		return NewApiError(http.StatusConflict, "Video is already being uploaded or processed", err)
+	}
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	stored, err := cfg.storeUploadedVideo(r, videoID)
+	if err != nil {
+		cfg.failUpload(videoID, err)
+		return err
+	}
+
+	previousURL, err := cfg.db.CompleteVideoUpload(videoID, database.CompleteVideoUploadParams{
+		VideoURL:    stored.URL,
+		AspectRatio: stored.AspectRatio,
+		VideoSize:   stored.Size,
+	})
+	if err != nil {
+		// The video still points at its old file, if any, so the new one
+		// would be left behind.
+		cfg.deleteVideoFile(context.WithoutCancel(r.Context()), videoID, stored.URL)
+		cfg.failUpload(videoID, err)
+		if errors.Is(err, database.ErrInvalidStatusTransition) {
+			return NewApiError(http.StatusConflict, "Upload took too long, please try again", err)
+		}
+		return NewApiError(http.StatusInternalServerError, "Couldn't update video", err)
+	}
+	cfg.emitVideoStatusEvent(eventVideoReady, videoID)
+
+	if previousURL != nil && *previousURL != stored.URL {
+		cfg.deleteVideoFile(context.WithoutCancel(r.Context()), videoID, *previousURL)
+	}
+
+	return nil
+}
+
+// failUpload marks the video as failed after an upload error. It's also
+// how a video gets out of uploading or processing, so every error after
+// the upload has started must end up here.
+func (cfg *apiConfig) failUpload(videoID uuid.UUID, err error) {
+	statusErr := cfg.db.SetVideoStatus(videoID, database.VideoStatusFailed, failureReason(err))
+	if statusErr != nil {
+		log.Printf("Couldn't mark video %v as failed: %v", videoID, statusErr)
+		return
+	}
+	cfg.emitVideoStatusEvent(eventVideoFailed, videoID)
+}
+
+// deleteVideoFile removes a stored video file nothing points at anymore.
+// Failing to is only logged, since the upload itself went through.
+func (cfg *apiConfig) deleteVideoFile(ctx context.Context, videoID uuid.UUID, url string) {
+	err := cfg.videoStorage("").Delete(ctx, url)
+	if err != nil {
+		log.Printf("Couldn't delete unused file of video %v: %v", videoID, err)
+	}
+}
+
+// storedVideo describes a video file once it has been saved.
+type storedVideo struct {
+	URL         string
+	AspectRatio string
+	Size        int64
+}
+
+// storeUploadedVideo reads the uploaded file, processes it for fast start
+// and saves it to S3, moving the video to the processing status once the
+// file has been received.
+func (cfg *apiConfig) storeUploadedVideo(r *http.Request, videoID uuid.UUID) (storedVideo, error) {
 	const maxMemory = 1 << 30 // 1 GB
-	err = r.ParseMultipartForm(maxMemory)
+	err := r.ParseMultipartForm(maxMemory)
 	if err != nil {
-		return NewApiError(http.StatusBadRequest, "Error parsing multipart form", err)
+		return storedVideo{}, NewApiError(http.StatusBadRequest, "Error parsing multipart form", err)
 	}
 
 	file, header, err := r.FormFile("video")
 	if err != nil {
-		return NewApiError(http.StatusBadRequest, "Error parsing form file", err)
+		return storedVideo{}, NewApiError(http.StatusBadRequest, "Error parsing form file", err)
 	}
 	defer file.Close()
 
 	contentType := header.Header.Get("Content-Type")
 	if contentType == "" {
-		return NewApiError(http.StatusBadRequest, "Error getting content type", nil)
+		return storedVideo{}, NewApiError(http.StatusBadRequest, "Error getting content type", nil)
 	}
 
 	mediaType, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		return NewApiError(http.StatusBadRequest, "Error parsing media type", err)
+		return storedVideo{}, NewApiError(http.StatusBadRequest, "Error parsing media type", err)
 	}
 
 	if mediaType != "video/mp4" {
-		return NewApiError(http.StatusBadRequest, "Invalid content type", err)
+		return storedVideo{}, NewApiError(http.StatusBadRequest, "Invalid content type", err)
 	}
 
 	temp, err := utils.CreateTempFile(file, "mp4")
 	if err != nil {
-		return NewInternalServerError(err)
+		return storedVideo{}, NewInternalServerError(err)
 	}
 	defer os.Remove(temp.Name())
 	defer temp.Close()
 
+	err = cfg.db.SetVideoStatus(videoID, database.VideoStatusProcessing, "")
+	if err != nil {
+		return storedVideo{}, NewInternalServerError(err)
+	}
+
 	ratio, err := utils.GetVideoAspectRatio(temp.Name())
 	if err != nil {
-		return NewInternalServerError(err)
+		return storedVideo{}, NewInternalServerError(err)
 	}
 
 	filename := filepath.Base(temp.Name())
@@ -79,43 +160,37 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request,
 
 	processedFileName, err := utils.ProcessVideoForFastStart(temp.Name())
 	if err != nil {
-		return NewInternalServerError(err)
+		return storedVideo{}, NewInternalServerError(err)
 	}
 	processedFile, err := os.Open(processedFileName)
 	if err != nil {
-		return NewInternalServerError(err)
+		return storedVideo{}, NewInternalServerError(err)
 	}
 	defer processedFile.Close()
 	defer os.Remove(processedFile.Name())
-
-	storage := &S3Storage{
-		Client:        cfg.s3Client,
-		Region:        cfg.s3Region,
-		Bucket:        cfg.s3Bucket,
-		Key:           filename,
-		URLMode:       cfg.s3URLMode,
-		LocalstackURL: cfg.localstackURL,
-		CloudFrontURL: cfg.s3CfDistribution,
+	info, err := processedFile.Stat()
+	if err != nil {
+		return storedVideo{}, NewInternalServerError(err)
 	}
 
+	storage := cfg.videoStorage(filename)
+
 	videoURL, err := storage.Save(r.Context(), processedFile, mediaType)
 	if err != nil {
-		return NewInternalServerError(err)
+		return storedVideo{}, NewInternalServerError(err)
 	}
 
-	updatedVideo := database.Video{
-		ID:                videoMetadata.ID,
-		CreatedAt:         videoMetadata.CreatedAt,
-		UpdatedAt:         time.Now(),
-		ThumbnailURL:      videoMetadata.ThumbnailURL,
-		VideoURL:          &videoURL,
-		CreateVideoParams: videoMetadata.CreateVideoParams,
-	}
+	return storedVideo{URL: videoURL, AspectRatio: ratio, Size: info.Size()}, nil
+}
 
-	err = cfg.db.UpdateVideo(updatedVideo)
-	if err != nil {
-		return NewApiError(http.StatusInternalServerError, "Couldn't update video", err)
+// failureReason describes an upload error for the video's failure_reason.
+func failureReason(err error) string {
+	var apiErr *ApiError
+	if errors.As(err, &apiErr) {
+		if apiErr.Err != nil && apiErr.Code == http.StatusInternalServerError {
+			return apiErr.Err.Error()
+		}
+		return apiErr.Message
 	}
-
-	return nil
+	return err.Error()
 }
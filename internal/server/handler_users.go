@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"github.com/andycostintoma/tubely/internal/auth"
 	"github.com/andycostintoma/tubely/internal/database"
+	"log"
 	"net/http"
 )
 
@@ -24,19 +25,29 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return NewApiError(http.StatusBadRequest, "Email and password are required", nil)
 	}
 
+	email, err := validateEmail(params.Email)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, err.Error(), err)
+	}
+
 	hashedPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
 		return NewApiError(http.StatusInternalServerError, "Couldn't hash password", err)
 	}
 
 	user, err := cfg.db.CreateUser(database.CreateUserParams{
-		Email:    params.Email,
+		Email:    email,
 		Password: hashedPassword,
 	})
 	if err != nil {
 		return NewApiError(http.StatusInternalServerError, "Couldn't create user", err)
 	}
 
+	err = cfg.sendEmailVerification(*user, user.Email)
+	if err != nil {
+		log.Printf("Couldn't send verification email to user %s: %v", user.ID, err)
+	}
+
 	respondWithJSON(w, http.StatusCreated, user)
 	return nil
 }
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/andycostintoma/tubely/internal/mail"
+	"github.com/andycostintoma/tubely/internal/utils"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const videoInviteDuration = 7 * 24 * time.Hour
+
+// handlerVideoCollaboratorSet invites someone by email to view or edit a
+// video, or changes the access of an existing collaborator. Invites are
+// sent whether or not the email has an account, so the response doesn't
+// tell which addresses are signed up.
+func (cfg *apiConfig) handlerVideoCollaboratorSet(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Email  string `json:"email"`
+		Access string `json:"access"`
+	}
+
+	video, err := cfg.getVideoForUser(r, userID, videoAccessManage)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+	email, err := validateEmail(params.Email)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, err.Error(), err)
+	}
+	access, err := database.ParseCollaboratorAccess(params.Access)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid access", err)
+	}
+
+	// Whoever manages the video already sees its collaborators, so their
+	// access can be changed directly.
+	collaborator, err := cfg.db.GetUserByEmail(email)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if collaborator.ID != uuid.Nil {
+		if collaborator.ID == video.UserID {
+			return NewApiError(http.StatusBadRequest, "The video's owner already has access", nil)
+		}
+		grant, err := cfg.db.GetVideoCollaborator(video.ID, collaborator.ID)
+		if err != nil {
+			return NewInternalServerError(err)
+		}
+		if grant.UserID != uuid.Nil {
+			grant, err = cfg.db.SetVideoCollaborator(database.SetVideoCollaboratorParams{
+				VideoID:   video.ID,
+				UserID:    collaborator.ID,
+				Access:    access,
+				GrantedBy: userID,
+			})
+			if err != nil {
+				return NewApiError(http.StatusInternalServerError, "Couldn't change collaborator", err)
+			}
+			cfg.audit(r, userID, "video.collaborator", "video", video.ID.String(), fmt.Sprintf("user=%s access=%s", collaborator.ID, access))
+			respondWithJSON(w, http.StatusOK, grant)
+			return nil
+		}
+	}
+
+	tokenBytes, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	invite, err := cfg.db.CreateVideoInvite(database.CreateVideoInviteParams{
+		VideoID:   video.ID,
+		Email:     email,
+		Access:    access,
+		InvitedBy: userID,
+		Token:     token,
+		ExpiresAt: time.Now().Add(videoInviteDuration),
+	})
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't create invite", err)
+	}
+
+	cfg.sendMail(mail.Message{
+		To:      email,
+		Subject: fmt.Sprintf("%q was shared with you on Tubely", video.Title),
+		Body: fmt.Sprintf(
+			"You've been invited to %s the video %q on Tubely. To accept, sign in with this email address and open this link:\n\n%s\n\nThe link expires in %s.\n",
+			access,
+			video.Title,
+			cfg.appURL(url.Values{"video_invite": {token}}),
+			formatHours(videoInviteDuration),
+		),
+	})
+
+	cfg.audit(r, userID, "video.invite", "video", video.ID.String(), fmt.Sprintf("email=%s access=%s", email, access))
+	respondWithJSON(w, http.StatusCreated, invite)
+	return nil
+}
+
+func (cfg *apiConfig) handlerVideoInvitesRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	video, err := cfg.getVideoForUser(r, userID, videoAccessManage)
+	if err != nil {
+		return err
+	}
+
+	invites, err := cfg.db.GetPendingVideoInvites(video.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve invites", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, invites)
+	return nil
+}
+
+func (cfg *apiConfig) handlerVideoInviteDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	video, err := cfg.getVideoForUser(r, userID, videoAccessManage)
+	if err != nil {
+		return err
+	}
+
+	inviteID, err := uuid.Parse(r.PathValue("inviteID"))
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid ID", err)
+	}
+	invite, err := cfg.db.GetVideoInvite(inviteID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if invite.ID == uuid.Nil || invite.VideoID != video.ID {
+		return NewApiError(http.StatusNotFound, "Invite not found", nil)
+	}
+
+	err = cfg.db.RevokeVideoInvite(invite.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't revoke invite", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handlerVideoInviteAccept makes the caller a collaborator on the invite's
+// video. The invite only works for the address it was sent to, and only
+// once that address has been verified.
+func (cfg *apiConfig) handlerVideoInviteAccept(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		return NewInternalServerError(err)
+	}
+	if user.EmailVerifiedAt == nil {
+		return NewApiError(http.StatusForbidden, "Verify your email before accepting invites", nil)
+	}
+
+	invite, err := cfg.db.AcceptVideoInvite(params.Token, user.ID, user.Email)
+	if errors.Is(err, database.ErrInviteEmailMismatch) {
+		return NewApiError(http.StatusForbidden, "This invite was sent to a different email", err)
+	}
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't accept invite", err)
+	}
+	if invite.ID == uuid.Nil {
+		return NewApiError(http.StatusNotFound, "Invite not found or expired", nil)
+	}
+
+	grant, err := cfg.db.GetVideoCollaborator(invite.VideoID, user.ID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	cfg.audit(r, user.ID, "video.collaborator_join", "video", invite.VideoID.String(), fmt.Sprintf("invite=%s", invite.ID))
+	respondWithJSON(w, http.StatusOK, grant)
+	return nil
+}
+
+func (cfg *apiConfig) handlerVideoCollaboratorsRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	video, err := cfg.getVideoForUser(r, userID, videoAccessManage)
+	if err != nil {
+		return err
+	}
+
+	collaborators, err := cfg.db.GetVideoCollaborators(video.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve collaborators", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, collaborators)
+	return nil
+}
+
+// handlerVideoCollaboratorDelete revokes a collaborator's access. Besides
+// whoever manages the video, collaborators can remove themselves.
+func (cfg *apiConfig) handlerVideoCollaboratorDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	collaboratorID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid ID", err)
+	}
+
+	need := videoAccessManage
+	if collaboratorID == userID {
+		need = videoAccessView
+	}
+	video, err := cfg.getVideoForUser(r, userID, need)
+	if err != nil {
+		return err
+	}
+
+	grant, err := cfg.db.GetVideoCollaborator(video.ID, collaboratorID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if grant.UserID == uuid.Nil {
+		return NewApiError(http.StatusNotFound, "Collaborator not found", nil)
+	}
+
+	err = cfg.db.RemoveVideoCollaborator(video.ID, collaboratorID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't remove collaborator", err)
+	}
+
+	cfg.audit(r, userID, "video.collaborator_remove", "video", video.ID.String(), fmt.Sprintf("user=%s", collaboratorID))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/andycostintoma/tubely/internal/mail"
+)
+
+// chanMailer hands sent messages to the test.
+type chanMailer chan mail.Message
+
+func (m chanMailer) Send(_ context.Context, msg mail.Message) error {
+	m <- msg
+	return nil
+}
+
+func (m chanMailer) next(t *testing.T) mail.Message {
+	t.Helper()
+	select {
+	case msg := <-m:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail was sent")
+		return mail.Message{}
+	}
+}
+
+var videoInviteLink = regexp.MustCompile(`video_invite=([\w-]+)`)
+
+func TestVideoInvite(t *testing.T) {
+	cfg := newTestConfig(t)
+	mailer := make(chanMailer, 10)
+	cfg.mailer = mailer
+	owner := signUp(t, cfg, "owner@example.com", true)
+	signUp(t, cfg, "member@example.com", true)
+	srv, client := newTestServer(t, cfg)
+	token := logIn(t, client, srv, "owner@example.com")
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Video", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := srv.URL + "/api/videos/" + video.ID.String() + "/collaborators"
+
+	// Emails with and without an account get the same answer.
+	var invites []database.VideoInvite
+	for _, email := range []string{"member@example.com", "nobody@example.com"} {
+		var invite database.VideoInvite
+		status := doJSON(t, client, http.MethodPut, url, token, map[string]string{"email": email, "access": "view"}, &invite)
+		if status != http.StatusCreated || invite.Email != email || invite.AcceptedAt != nil {
+			t.Fatalf("inviting %s returned %d %+v, want a pending invite", email, status, invite)
+		}
+		invites = append(invites, invite)
+	}
+	// Mail is sent in the background, in no particular order.
+	memberMail := mailer.next(t)
+	if memberMail.To != "member@example.com" {
+		memberMail = mailer.next(t)
+	}
+	match := videoInviteLink.FindStringSubmatch(memberMail.Body)
+	if match == nil {
+		t.Fatalf("no invite link in %q", memberMail.Body)
+	}
+
+	var collaborators []database.VideoCollaborator
+	doJSON(t, client, http.MethodGet, url, token, nil, &collaborators)
+	if len(collaborators) != 0 {
+		t.Errorf("got %d collaborators before anyone accepted, want 0", len(collaborators))
+	}
+
+	// Only the invited address can accept.
+	signUp(t, cfg, "other@example.com", true)
+	otherClient := newTestClient(t, srv)
+	otherToken := logIn(t, otherClient, srv, "other@example.com")
+	accept := srv.URL + "/api/video_invites/accept"
+	if status := doJSON(t, otherClient, http.MethodPost, accept, otherToken, map[string]string{"token": match[1]}, nil); status != http.StatusForbidden {
+		t.Errorf("accepting someone else's invite returned %d, want %d", status, http.StatusForbidden)
+	}
+
+	memberClient := newTestClient(t, srv)
+	memberToken := logIn(t, memberClient, srv, "member@example.com")
+	var grant database.VideoCollaborator
+	if status := doJSON(t, memberClient, http.MethodPost, accept, memberToken, map[string]string{"token": match[1]}, &grant); status != http.StatusOK {
+		t.Fatalf("accepting the invite returned %d", status)
+	}
+	if grant.Access != database.CollaboratorAccessView || grant.Email != "member@example.com" {
+		t.Errorf("got %+v, want view access for the member", grant)
+	}
+	if status := doJSON(t, memberClient, http.MethodGet, srv.URL+"/api/videos/"+video.ID.String(), memberToken, nil, nil); status != http.StatusOK {
+		t.Errorf("the new collaborator got %d for the private video", status)
+	}
+	if status := doJSON(t, memberClient, http.MethodPost, accept, memberToken, map[string]string{"token": match[1]}, nil); status != http.StatusNotFound {
+		t.Errorf("accepting the invite twice returned %d, want %d", status, http.StatusNotFound)
+	}
+
+	// Now that they're a collaborator, their access is changed directly.
+	if status := doJSON(t, client, http.MethodPut, url, token, map[string]string{"email": "member@example.com", "access": "edit"}, &grant); status != http.StatusOK || grant.Access != database.CollaboratorAccessEdit {
+		t.Errorf("changing access returned %d %+v, want edit access", status, grant)
+	}
+
+	var pending []database.VideoInvite
+	doJSON(t, client, http.MethodGet, srv.URL+"/api/videos/"+video.ID.String()+"/invites", token, nil, &pending)
+	if len(pending) != 1 || pending[0].ID != invites[1].ID {
+		t.Errorf("got pending invites %+v, want only the one to nobody@example.com", pending)
+	}
+}
@@ -2,12 +2,24 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andycostintoma/tubely/internal/auth"
 	"github.com/andycostintoma/tubely/internal/database"
+	"mime"
 	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
 
+const (
+	maxVideoTitleLength      = 200
+	maxVideoDescriptionBytes = 5000
+)
+
 func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
 	type parameters struct {
 		database.CreateVideoParams
@@ -20,11 +32,33 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 		return NewApiError(http.StatusInternalServerError, "Couldn't decode parameters", err)
 	}
 	params.UserID = userID
+	if params.WorkspaceID != nil {
+		_, err = cfg.requireWorkspaceRole(*params.WorkspaceID, userID, database.WorkspaceRoleEditor)
+		if err != nil {
+			return err
+		}
+	}
+	if params.Visibility != "" {
+		_, err = database.ParseVideoVisibility(string(params.Visibility))
+		if err != nil {
+			return NewApiError(http.StatusBadRequest, "Visibility must be private, unlisted or public", err)
+		}
+	}
+	for _, t := range []*time.Time{params.PublishAt, params.UnpublishAt} {
+		if t != nil && !t.After(time.Now()) {
+			return NewApiError(http.StatusBadRequest, "Scheduled times must be in the future", nil)
+		}
+	}
+	err = validateVideoSchedule(params.CreateVideoParams)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, err.Error(), err)
+	}
 
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
 		return NewApiError(http.StatusInternalServerError, "Couldn't create video", err)
 	}
+	cfg.emitVideoEvent(eventVideoCreated, video)
 
 	respondWithJSON(w, http.StatusCreated, video)
 	return nil
@@ -39,16 +73,21 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		return NewApiError(http.StatusNotFound, "Couldn't get video", err)
+		return NewInternalServerError(err)
 	}
-	if video.UserID != userID {
-		return NewApiError(http.StatusForbidden, "You can't delete this video", err)
+	if video.ID == uuid.Nil {
+		return NewApiError(http.StatusNotFound, "Couldn't get video", nil)
+	}
+	err = cfg.requireVideoAccess(userID, video, videoAccessManage)
+	if err != nil {
+		return err
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	err = cfg.db.TrashVideo(videoID)
 	if err != nil {
 		return NewApiError(http.StatusInternalServerError, "Couldn't delete video", err)
 	}
+	cfg.emitVideoEvent(eventVideoDeleted, video)
 
 	w.WriteHeader(http.StatusNoContent)
 	return nil
@@ -61,16 +100,32 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) er
 		return NewApiError(http.StatusBadRequest, "Invalid video ID", err)
 	}
 
+	info, err := cfg.authenticateOptional(r)
+	if err != nil {
+		return err
+	}
+
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		return NewApiError(http.StatusNotFound, "Couldn't get video", err)
+		return NewInternalServerError(err)
+	}
+	// Private videos look the same as missing ones to anyone who can't
+	// see them, so their IDs can't be probed.
+	canView, err := cfg.canViewVideo(info, video)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil || !canView {
+		return NewApiError(http.StatusNotFound, "Couldn't get video", nil)
 	}
+	if video.Visibility == database.VideoVisibilityPrivate {
+		w.Header().Set("Cache-Control", "private")
+	}
+	w.Header().Set("ETag", videoETag(video))
 
-	if cfg.s3URLMode == "signed" {
-		video, err = cfg.dbVideoToSignedVideo(r.Context(), video)
-		if err != nil {
-			return NewInternalServerError(err)
-		}
+	video, err = cfg.resolveVideoURL(r.Context(), video)
+	if err != nil {
+		return NewInternalServerError(err)
 	}
 
 	respondWithJSON(w, http.StatusOK, video)
@@ -78,23 +133,255 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) er
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
-	videos, err := cfg.db.GetVideos(userID)
+	workspaceID, err := cfg.workspaceFromQuery(r, userID, database.WorkspaceRoleViewer)
 	if err != nil {
-		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return err
+	}
+	filter := database.VideoFilter{
+		WorkspaceID: workspaceID,
+		Shared:      r.URL.Query().Get("shared") == "true",
+	}
+	if filter.WorkspaceID != nil && filter.Shared {
+		return NewApiError(http.StatusBadRequest, "Can't combine workspace_id and shared", nil)
+	}
+	for _, value := range r.URL.Query()["status"] {
+		for _, s := range strings.Split(value, ",") {
+			status, err := database.ParseVideoStatus(strings.TrimSpace(s))
+			if err != nil {
+				return NewApiError(http.StatusBadRequest, "Invalid status filter", err)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
 	}
 
-	if cfg.s3URLMode == "signed" {
-		signedVideos := make([]database.Video, 0, len(videos))
-		for _, video := range videos {
-			signedVideo, err := cfg.dbVideoToSignedVideo(r.Context(), video)
+	seenTags := map[string]bool{}
+	for _, value := range r.URL.Query()["tag"] {
+		for _, name := range strings.Split(value, ",") {
+			tag, err := database.NormalizeTagName(name)
 			if err != nil {
-				return NewInternalServerError(err)
+				return NewApiError(http.StatusBadRequest, "Invalid tag filter", err)
+			}
+			if !seenTags[tag] {
+				seenTags[tag] = true
+				filter.Tags = append(filter.Tags, tag)
 			}
-			signedVideos = append(signedVideos, signedVideo)
 		}
-		videos = signedVideos
+	}
+	switch r.URL.Query().Get("tag_mode") {
+	case "", "and":
+	case "or":
+		filter.MatchAnyTag = true
+	default:
+		return NewApiError(http.StatusBadRequest, "tag_mode must be and or or", nil)
+	}
+
+	videos, err := cfg.db.GetVideos(userID, filter)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve videos", err)
+	}
+
+	videos, err = cfg.resolveVideoURLs(r.Context(), videos)
+	if err != nil {
+		return NewInternalServerError(err)
 	}
 
 	respondWithJSON(w, http.StatusOK, videos)
 	return nil
 }
+
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid ID", err)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/merge-patch+json" && mediaType != "application/json") {
+		return NewApiError(http.StatusUnsupportedMediaType, "Content type must be application/merge-patch+json", err)
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if video.ID == uuid.Nil {
+		return NewApiError(http.StatusNotFound, "Couldn't get video", nil)
+	}
+	access, err := cfg.videoAccessFor(userID, video)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if access < videoAccessEdit {
+		return NewApiError(http.StatusForbidden, "You don't have access to this video", nil)
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, videoETag(video)) {
+		return NewApiError(http.StatusPreconditionFailed, "Video has been modified", nil)
+	}
+
+	patch := map[string]json.RawMessage{}
+	err = json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode merge patch", err)
+	}
+	// Collaborators can edit the video but not decide who gets to see it.
+	if access < videoAccessManage {
+		for _, field := range managedVideoFields {
+			if _, ok := patch[field]; ok {
+				return NewApiError(http.StatusForbidden, fmt.Sprintf("Only the video's owner can change %s", field), nil)
+			}
+		}
+	}
+
+	err = applyVideoMergePatch(&video.CreateVideoParams, patch)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, err.Error(), err)
+	}
+
+	lastUpdatedAt := video.UpdatedAt
+	video.UpdatedAt = time.Now().UTC()
+	err = cfg.db.UpdateVideoIfUnmodified(video, lastUpdatedAt)
+	if errors.Is(err, database.ErrVideoModified) {
+		return NewApiError(http.StatusPreconditionFailed, "Video has been modified", err)
+	}
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't update video", err)
+	}
+
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video)
+	return nil
+}
+
+// managedVideoFields control who can see a video, so changing them needs
+// videoAccessManage.
+var managedVideoFields = []string{"visibility", "publish_at", "unpublish_at"}
+
+// applyVideoMergePatch applies an RFC 7396 merge patch to the editable
+// metadata fields of a video and validates the result.
+func applyVideoMergePatch(params *database.CreateVideoParams, patch map[string]json.RawMessage) error {
+	for field, raw := range patch {
+		isNull := string(raw) == "null"
+		switch field {
+		case "title":
+			if isNull {
+				return errors.New("Title is required")
+			}
+			if err := json.Unmarshal(raw, &params.Title); err != nil {
+				return errors.New("Title must be a string")
+			}
+		case "visibility":
+			var visibility string
+			if isNull || json.Unmarshal(raw, &visibility) != nil {
+				return errors.New("Visibility must be private, unlisted or public")
+			}
+			parsed, err := database.ParseVideoVisibility(visibility)
+			if err != nil {
+				return errors.New("Visibility must be private, unlisted or public")
+			}
+			params.Visibility = parsed
+		case "description":
+			if isNull {
+				params.Description = ""
+				continue
+			}
+			if err := json.Unmarshal(raw, &params.Description); err != nil {
+				return errors.New("Description must be a string")
+			}
+		case "publish_at", "unpublish_at":
+			target := &params.PublishAt
+			if field == "unpublish_at" {
+				target = &params.UnpublishAt
+			}
+			if isNull {
+				*target = nil
+				continue
+			}
+			var t time.Time
+			if err := json.Unmarshal(raw, &t); err != nil {
+				return fmt.Errorf("%s must be an RFC 3339 timestamp", field)
+			}
+			if !t.After(time.Now()) {
+				return fmt.Errorf("%s must be in the future", field)
+			}
+			*target = &t
+		default:
+			return fmt.Errorf("Field %s cannot be modified", field)
+		}
+	}
+
+	params.Title = strings.TrimSpace(params.Title)
+	if params.Title == "" {
+		return errors.New("Title is required")
+	}
+	if utf8.RuneCountInString(params.Title) > maxVideoTitleLength {
+		return fmt.Errorf("Title must be at most %d characters", maxVideoTitleLength)
+	}
+	if len(params.Description) > maxVideoDescriptionBytes {
+		return fmt.Errorf("Description must be at most %d bytes", maxVideoDescriptionBytes)
+	}
+	return validateVideoSchedule(*params)
+}
+
+func validateVideoSchedule(params database.CreateVideoParams) error {
+	if params.PublishAt != nil && params.UnpublishAt != nil && !params.UnpublishAt.After(*params.PublishAt) {
+		return errors.New("unpublish_at must be after publish_at")
+	}
+	return nil
+}
+
+func videoETag(video database.Video) string {
+	return fmt.Sprintf(`"%x"`, video.UpdatedAt.UnixNano())
+}
+
+// etagMatches reports whether an If-Match header matches etag using the
+// strong comparison required by RFC 9110.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// canViewVideo reports whether the caller may fetch a video. Anyone can see
+// public and unlisted videos; private ones need credentials with read
+// access from a user who can see the video.
+func (cfg *apiConfig) canViewVideo(info authInfo, video database.Video) (bool, error) {
+	if video.Visibility != database.VideoVisibilityPrivate {
+		return true, nil
+	}
+	if !auth.HasScope(info.Scopes, auth.ScopeVideosRead) {
+		return false, nil
+	}
+	access, err := cfg.videoAccessFor(info.UserID, video)
+	if err != nil {
+		return false, NewInternalServerError(err)
+	}
+	return access >= videoAccessView, nil
+}
+
+// getVideoForUser loads the video named by the videoID path value and
+// checks that the user may do at least need with it.
+func (cfg *apiConfig) getVideoForUser(r *http.Request, userID uuid.UUID, need videoAccess) (database.Video, error) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		return database.Video{}, NewApiError(http.StatusBadRequest, "Invalid ID", err)
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return database.Video{}, NewInternalServerError(err)
+	}
+	if video.ID == uuid.Nil {
+		return database.Video{}, NewApiError(http.StatusNotFound, "Couldn't get video", nil)
+	}
+	err = cfg.requireVideoAccess(userID, video, need)
+	if err != nil {
+		return database.Video{}, err
+	}
+	return video, nil
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/andycostintoma/tubely/internal/utils"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxWebhooksPerUser   = 10
+	maxWebhookDeliveries = 100
+)
+
+func (cfg *apiConfig) handlerWebhookCreate(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		URL         string   `json:"url"`
+		Events      []string `json:"events"`
+		Description string   `json:"description"`
+	}
+	type response struct {
+		database.Webhook
+		Secret string `json:"secret"`
+	}
+
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	err = validateWebhookURL(params.URL)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, err.Error(), err)
+	}
+	if len(params.Events) == 0 {
+		return NewApiError(http.StatusBadRequest, "At least one event is required", nil)
+	}
+	for _, event := range params.Events {
+		if !isWebhookEvent(event) {
+			return NewApiError(http.StatusBadRequest, fmt.Sprintf("Unknown event %q", event), nil)
+		}
+	}
+
+	webhooks, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if len(webhooks) >= maxWebhooksPerUser {
+		return NewApiError(http.StatusConflict, fmt.Sprintf("You can have at most %d webhooks", maxWebhooksPerUser), nil)
+	}
+
+	secretBytes, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	secret := "whsec_" + base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	webhook, err := cfg.db.CreateWebhook(database.CreateWebhookParams{
+		UserID:      userID,
+		URL:         params.URL,
+		Description: strings.TrimSpace(params.Description),
+		Events:      params.Events,
+		Secret:      secret,
+	})
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't create webhook", err)
+	}
+
+	cfg.audit(r, userID, "webhook.create", "webhook", webhook.ID.String(), webhook.URL)
+
+	// The secret is only ever shown here; receivers need it to verify
+	// signatures.
+	respondWithJSON(w, http.StatusCreated, response{
+		Webhook: webhook,
+		Secret:  secret,
+	})
+	return nil
+}
+
+func (cfg *apiConfig) handlerWebhooksRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	webhooks, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve webhooks", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, webhooks)
+	return nil
+}
+
+func (cfg *apiConfig) handlerWebhookGet(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	webhook, err := cfg.getWebhookForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	respondWithJSON(w, http.StatusOK, webhook)
+	return nil
+}
+
+func (cfg *apiConfig) handlerWebhookDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	webhook, err := cfg.getWebhookForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.db.DeleteWebhook(webhook.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't delete webhook", err)
+	}
+
+	cfg.audit(r, userID, "webhook.delete", "webhook", webhook.ID.String(), webhook.URL)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handlerWebhookPing queues a ping event to the webhook, whatever events
+// it subscribes to, so its owner can check the endpoint is reachable.
+func (cfg *apiConfig) handlerWebhookPing(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	webhook, err := cfg.getWebhookForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := cfg.enqueueEvent(eventPing, map[string]any{"webhook_id": webhook.ID}, webhook)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't queue ping", err)
+	}
+
+	respondWithJSON(w, http.StatusAccepted, deliveries[0])
+	return nil
+}
+
+func (cfg *apiConfig) handlerWebhookDeliveriesRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	webhook, err := cfg.getWebhookForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := cfg.db.GetWebhookDeliveries(webhook.ID, maxWebhookDeliveries)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve deliveries", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, deliveries)
+	return nil
+}
+
+func (cfg *apiConfig) handlerWebhookDeliveryGet(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	_, delivery, err := cfg.getWebhookDeliveryForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	respondWithJSON(w, http.StatusOK, delivery)
+	return nil
+}
+
+// handlerWebhookRedeliver sends a delivery's payload again as a new
+// delivery. The event ID stays the same, so receivers that already
+// handled it can tell.
+func (cfg *apiConfig) handlerWebhookRedeliver(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	webhook, delivery, err := cfg.getWebhookDeliveryForUser(r, userID)
+	if err != nil {
+		return err
+	}
+
+	redelivery, err := cfg.db.CreateWebhookDelivery(database.CreateWebhookDeliveryParams{
+		WebhookID:    webhook.ID,
+		EventID:      delivery.EventID,
+		Event:        delivery.Event,
+		Payload:      delivery.Payload,
+		RedeliveryOf: &delivery.ID,
+	})
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't queue redelivery", err)
+	}
+	cfg.wakeWebhookDispatcher()
+
+	respondWithJSON(w, http.StatusAccepted, redelivery)
+	return nil
+}
+
+// getWebhookForUser looks up the webhook in the path. Webhooks and their
+// delivery logs carry full event payloads and aren't covered by any API key
+// scope, so only sessions may use them.
+func (cfg *apiConfig) getWebhookForUser(r *http.Request, userID uuid.UUID) (database.Webhook, error) {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return database.Webhook{}, err
+	}
+
+	webhookID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		return database.Webhook{}, NewApiError(http.StatusBadRequest, "Invalid webhook ID", err)
+	}
+
+	webhook, err := cfg.db.GetWebhook(webhookID)
+	if err != nil {
+		return database.Webhook{}, NewInternalServerError(err)
+	}
+	if webhook.ID == uuid.Nil || webhook.UserID != userID {
+		return database.Webhook{}, NewApiError(http.StatusNotFound, "Couldn't find webhook", nil)
+	}
+	return webhook, nil
+}
+
+func (cfg *apiConfig) getWebhookDeliveryForUser(r *http.Request, userID uuid.UUID) (database.Webhook, database.WebhookDelivery, error) {
+	webhook, err := cfg.getWebhookForUser(r, userID)
+	if err != nil {
+		return database.Webhook{}, database.WebhookDelivery{}, err
+	}
+
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		return database.Webhook{}, database.WebhookDelivery{}, NewApiError(http.StatusBadRequest, "Invalid delivery ID", err)
+	}
+
+	delivery, err := cfg.db.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		return database.Webhook{}, database.WebhookDelivery{}, NewInternalServerError(err)
+	}
+	if delivery.ID == uuid.Nil || delivery.WebhookID != webhook.ID {
+		return database.Webhook{}, database.WebhookDelivery{}, NewApiError(http.StatusNotFound, "Couldn't find delivery", nil)
+	}
+	return webhook, delivery, nil
+}
+
+func validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("URL must be absolute")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL must use http or https")
+	}
+	if u.User != nil {
+		return fmt.Errorf("URL must not contain credentials")
+	}
+	return nil
+}
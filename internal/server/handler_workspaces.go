@@ -0,0 +1,430 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/andycostintoma/tubely/internal/mail"
+	"github.com/andycostintoma/tubely/internal/utils"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxWorkspaceNameLength  = 100
+	workspaceInviteDuration = 7 * 24 * time.Hour
+)
+
+func validateWorkspaceName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("Name is required")
+	}
+	if utf8.RuneCountInString(name) > maxWorkspaceNameLength {
+		return "", fmt.Errorf("Name must be at most %d characters", maxWorkspaceNameLength)
+	}
+	return name, nil
+}
+
+func (cfg *apiConfig) handlerWorkspaceCreate(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	name, err := validateWorkspaceName(params.Name)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, err.Error(), err)
+	}
+
+	workspace, err := cfg.db.CreateWorkspace(name, userID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't create workspace", err)
+	}
+
+	respondWithJSON(w, http.StatusCreated, database.WorkspaceMembership{
+		Workspace: workspace,
+		Role:      database.WorkspaceRoleOwner,
+	})
+	return nil
+}
+
+func (cfg *apiConfig) handlerWorkspacesRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	workspaces, err := cfg.db.GetWorkspacesForUser(userID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve workspaces", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, workspaces)
+	return nil
+}
+
+func (cfg *apiConfig) handlerWorkspaceGet(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type response struct {
+		database.WorkspaceMembership
+		Members []database.WorkspaceMember `json:"members"`
+	}
+
+	// Member lists include everyone's email, which API keys have no scope
+	// for.
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	workspace, role, err := cfg.getWorkspaceForUser(r, userID, database.WorkspaceRoleViewer)
+	if err != nil {
+		return err
+	}
+
+	members, err := cfg.db.GetWorkspaceMembers(workspace.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve members", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		WorkspaceMembership: database.WorkspaceMembership{Workspace: workspace, Role: role},
+		Members:             members,
+	})
+	return nil
+}
+
+// handlerWorkspaceDelete deletes an empty workspace. Its videos, including
+// those in its trash, have to be deleted first so nothing is lost by
+// accident.
+func (cfg *apiConfig) handlerWorkspaceDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	workspace, _, err := cfg.getWorkspaceForUser(r, userID, database.WorkspaceRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.db.DeleteWorkspace(workspace.ID)
+	if errors.Is(err, database.ErrWorkspaceNotEmpty) {
+		return NewApiError(http.StatusConflict, "Delete the workspace's videos first", err)
+	}
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't delete workspace", err)
+	}
+
+	cfg.audit(r, userID, "workspace.delete", "workspace", workspace.ID.String(), workspace.Name)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (cfg *apiConfig) handlerWorkspaceMemberUpdate(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	workspace, _, err := cfg.getWorkspaceForUser(r, userID, database.WorkspaceRoleOwner)
+	if err != nil {
+		return err
+	}
+	memberID, err := cfg.getWorkspaceMemberID(r, workspace.ID)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+	role, err := database.ParseWorkspaceRole(params.Role)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid role", err)
+	}
+
+	err = cfg.db.SetWorkspaceMemberRole(workspace.ID, memberID, role)
+	if errors.Is(err, database.ErrLastWorkspaceOwner) {
+		return NewApiError(http.StatusConflict, "A workspace needs at least one owner", err)
+	}
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't update member", err)
+	}
+
+	cfg.audit(r, userID, "workspace.member_role", "workspace", workspace.ID.String(), fmt.Sprintf("user=%s role=%s", memberID, role))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handlerWorkspaceMemberDelete removes a member. Owners can remove anyone;
+// other members can only leave.
+func (cfg *apiConfig) handlerWorkspaceMemberDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	workspace, role, err := cfg.getWorkspaceForUser(r, userID, database.WorkspaceRoleViewer)
+	if err != nil {
+		return err
+	}
+	memberID, err := cfg.getWorkspaceMemberID(r, workspace.ID)
+	if err != nil {
+		return err
+	}
+	if memberID != userID && !role.AtLeast(database.WorkspaceRoleOwner) {
+		return NewApiError(http.StatusForbidden, "Forbidden: insufficient workspace role", nil)
+	}
+
+	err = cfg.db.RemoveWorkspaceMember(workspace.ID, memberID)
+	if errors.Is(err, database.ErrLastWorkspaceOwner) {
+		return NewApiError(http.StatusConflict, "A workspace needs at least one owner", err)
+	}
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't remove member", err)
+	}
+
+	cfg.audit(r, userID, "workspace.member_remove", "workspace", workspace.ID.String(), fmt.Sprintf("user=%s", memberID))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handlerWorkspaceInviteCreate emails a single-use link that adds whoever
+// signs in with the invited address to the workspace.
+func (cfg *apiConfig) handlerWorkspaceInviteCreate(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	workspace, _, err := cfg.getWorkspaceForUser(r, userID, database.WorkspaceRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+	email, err := validateEmail(params.Email)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, err.Error(), err)
+	}
+	if params.Role == "" {
+		params.Role = string(database.WorkspaceRoleViewer)
+	}
+	role, err := database.ParseWorkspaceRole(params.Role)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid role", err)
+	}
+
+	tokenBytes, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	invite, err := cfg.db.CreateWorkspaceInvite(database.CreateWorkspaceInviteParams{
+		WorkspaceID: workspace.ID,
+		Email:       email,
+		Role:        role,
+		InvitedBy:   userID,
+		Token:       token,
+		ExpiresAt:   time.Now().Add(workspaceInviteDuration),
+	})
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't create invite", err)
+	}
+
+	cfg.sendMail(mail.Message{
+		To:      email,
+		Subject: fmt.Sprintf("You're invited to %s on Tubely", workspace.Name),
+		Body: fmt.Sprintf(
+			"You've been invited to join the workspace %q on Tubely as %s. To accept, sign in with this email address and open this link:\n\n%s\n\nThe link expires in %s.\n",
+			workspace.Name,
+			role,
+			cfg.appURL(url.Values{"workspace_invite": {token}}),
+			formatHours(workspaceInviteDuration),
+		),
+	})
+
+	cfg.audit(r, userID, "workspace.invite", "workspace", workspace.ID.String(), fmt.Sprintf("email=%s role=%s", email, role))
+	respondWithJSON(w, http.StatusCreated, invite)
+	return nil
+}
+
+func (cfg *apiConfig) handlerWorkspaceInvitesRetrieve(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	workspace, _, err := cfg.getWorkspaceForUser(r, userID, database.WorkspaceRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	invites, err := cfg.db.GetPendingWorkspaceInvites(workspace.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't retrieve invites", err)
+	}
+
+	respondWithJSON(w, http.StatusOK, invites)
+	return nil
+}
+
+func (cfg *apiConfig) handlerWorkspaceInviteDelete(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	workspace, _, err := cfg.getWorkspaceForUser(r, userID, database.WorkspaceRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	inviteID, err := uuid.Parse(r.PathValue("inviteID"))
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Invalid ID", err)
+	}
+	invite, err := cfg.db.GetWorkspaceInvite(inviteID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if invite.ID == uuid.Nil || invite.WorkspaceID != workspace.ID {
+		return NewApiError(http.StatusNotFound, "Invite not found", nil)
+	}
+
+	err = cfg.db.RevokeWorkspaceInvite(invite.ID)
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't revoke invite", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handlerWorkspaceInviteAccept adds the caller to the invite's workspace.
+// The invite only works for the address it was sent to, and only once that
+// address has been verified.
+func (cfg *apiConfig) handlerWorkspaceInviteAccept(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	err := requireSessionAuth(r)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		return NewApiError(http.StatusBadRequest, "Couldn't decode parameters", err)
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		return NewInternalServerError(err)
+	}
+	if user.EmailVerifiedAt == nil {
+		return NewApiError(http.StatusForbidden, "Verify your email before accepting invites", nil)
+	}
+
+	invite, err := cfg.db.AcceptWorkspaceInvite(params.Token, user.ID, user.Email)
+	if errors.Is(err, database.ErrInviteEmailMismatch) {
+		return NewApiError(http.StatusForbidden, "This invite was sent to a different email", err)
+	}
+	if err != nil {
+		return NewApiError(http.StatusInternalServerError, "Couldn't accept invite", err)
+	}
+	if invite.ID == uuid.Nil {
+		return NewApiError(http.StatusNotFound, "Invite not found or expired", nil)
+	}
+
+	workspace, err := cfg.db.GetWorkspace(invite.WorkspaceID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	role, err := cfg.db.GetWorkspaceRole(workspace.ID, user.ID)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+
+	cfg.audit(r, user.ID, "workspace.join", "workspace", workspace.ID.String(), fmt.Sprintf("invite=%s", invite.ID))
+	respondWithJSON(w, http.StatusOK, database.WorkspaceMembership{Workspace: workspace, Role: role})
+	return nil
+}
+
+// getWorkspaceForUser loads the workspace named in the path, as long as
+// the user holds at least min in it.
+func (cfg *apiConfig) getWorkspaceForUser(r *http.Request, userID uuid.UUID, min database.WorkspaceRole) (database.Workspace, database.WorkspaceRole, error) {
+	workspaceID, err := uuid.Parse(r.PathValue("workspaceID"))
+	if err != nil {
+		return database.Workspace{}, "", NewApiError(http.StatusBadRequest, "Invalid ID", err)
+	}
+	role, err := cfg.requireWorkspaceRole(workspaceID, userID, min)
+	if err != nil {
+		return database.Workspace{}, "", err
+	}
+	workspace, err := cfg.db.GetWorkspace(workspaceID)
+	if err != nil {
+		return database.Workspace{}, "", NewInternalServerError(err)
+	}
+	if workspace.ID == uuid.Nil {
+		return database.Workspace{}, "", NewApiError(http.StatusNotFound, "Workspace not found", nil)
+	}
+	return workspace, role, nil
+}
+
+// getWorkspaceMemberID reads the member named in the path, who has to
+// belong to the workspace.
+func (cfg *apiConfig) getWorkspaceMemberID(r *http.Request, workspaceID uuid.UUID) (uuid.UUID, error) {
+	memberID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		return uuid.Nil, NewApiError(http.StatusBadRequest, "Invalid ID", err)
+	}
+	role, err := cfg.db.GetWorkspaceRole(workspaceID, memberID)
+	if err != nil {
+		return uuid.Nil, NewInternalServerError(err)
+	}
+	if role == "" {
+		return uuid.Nil, NewApiError(http.StatusNotFound, "Member not found", nil)
+	}
+	return memberID, nil
+}
@@ -1,12 +1,15 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/andycostintoma/tubely/internal/auth"
+	"github.com/andycostintoma/tubely/internal/database"
 	"github.com/google/uuid"
 	"log"
 	"net/http"
+	"time"
 )
 
 type ApiError struct {
@@ -74,18 +77,168 @@ func withApiError(handler ApiErrorHandlerFunc) http.HandlerFunc {
 
 type AuthenticatedHandlerFunc func(http.ResponseWriter, *http.Request, uuid.UUID) error
 
+// authInfo describes how the caller of an authenticated request proved who
+// they are and what they may do. APIKeyID is uuid.Nil unless an API key was
+// used, and CookieSession is set when the access token came from a cookie.
+type authInfo struct {
+	UserID        uuid.UUID
+	APIKeyID      uuid.UUID
+	Scopes        []string
+	Role          database.UserRole
+	EmailVerified bool
+	CookieSession bool
+	// IssuedAt is when the access token was issued, and zero for API keys.
+	IssuedAt time.Time
+}
+
+type authContextKey struct{}
+
+func authFromContext(ctx context.Context) authInfo {
+	info, _ := ctx.Value(authContextKey{}).(authInfo)
+	return info
+}
+
+// withAuth accepts either a Bearer JWT or an ApiKey in the Authorization
+// header, or an access token cookie from a browser session.
 func (cfg *apiConfig) withAuth(handler AuthenticatedHandlerFunc) http.HandlerFunc {
 	return withApiError(func(w http.ResponseWriter, r *http.Request) error {
-		token, err := auth.GetBearerToken(r.Header)
+		info, err := cfg.authenticate(r)
+		if err != nil {
+			return err
+		}
+		info, err = cfg.checkAccount(info)
 		if err != nil {
-			return NewApiError(http.StatusUnauthorized, "Unauthorized: Missing or invalid token", err)
+			return err
 		}
 
-		userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+		r = r.WithContext(context.WithValue(r.Context(), authContextKey{}, info))
+		return handler(w, r, info.UserID)
+	})
+}
+
+// authenticateOptional is for routes anyone may call but that show more to
+// signed-in users. It returns a zero authInfo when the request carries no
+// credentials at all, and an error when the credentials are bad.
+func (cfg *apiConfig) authenticateOptional(r *http.Request) (authInfo, error) {
+	if r.Header.Get("Authorization") == "" {
+		if _, err := r.Cookie(accessTokenCookie); err != nil {
+			return authInfo{}, nil
+		}
+	}
+	info, err := cfg.authenticate(r)
+	if err != nil {
+		return authInfo{}, err
+	}
+	return cfg.checkAccount(info)
+}
+
+// checkAccount loads the authenticated user on every request so that
+// disabling the account, changing its role or signing it out everywhere
+// takes effect before existing tokens expire.
+func (cfg *apiConfig) checkAccount(info authInfo) (authInfo, error) {
+	user, err := cfg.db.GetUser(info.UserID)
+	if err != nil {
+		return authInfo{}, NewInternalServerError(err)
+	}
+	if user == nil {
+		return authInfo{}, NewApiError(http.StatusUnauthorized, "Unauthorized: Unknown user", nil)
+	}
+	if user.DisabledAt != nil {
+		return authInfo{}, NewApiError(http.StatusForbidden, "Account is disabled", nil)
+	}
+	if info.APIKeyID == uuid.Nil && user.TokensValidAfter != nil && info.IssuedAt.Before(*user.TokensValidAfter) {
+		return authInfo{}, NewApiError(http.StatusUnauthorized, "Unauthorized: Token has been revoked", nil)
+	}
+	info.Role = user.Role
+	info.EmailVerified = user.EmailVerifiedAt != nil
+	return info, nil
+}
+
+func (cfg *apiConfig) authenticate(r *http.Request) (authInfo, error) {
+	if key, err := auth.GetAPIKey(r.Header); err == nil {
+		return cfg.authenticateAPIKey(key)
+	}
+
+	fromCookie := false
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		cookie, cookieErr := r.Cookie(accessTokenCookie)
+		if cookieErr != nil || cookie.Value == "" {
+			return authInfo{}, NewApiError(http.StatusUnauthorized, "Unauthorized: Missing or invalid token", err)
+		}
+		err = checkCSRF(r)
 		if err != nil {
-			return NewApiError(http.StatusUnauthorized, "Unauthorized: Invalid token", err)
+			return authInfo{}, err
+		}
+		token, fromCookie = cookie.Value, true
+	}
+
+	accessToken, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		return authInfo{}, NewApiError(http.StatusUnauthorized, "Unauthorized: Invalid token", err)
+	}
+
+	return authInfo{UserID: accessToken.UserID, Scopes: accessToken.Scopes, CookieSession: fromCookie, IssuedAt: accessToken.IssuedAt}, nil
+}
+
+func (cfg *apiConfig) authenticateAPIKey(key string) (authInfo, error) {
+	_, err := auth.ParseAPIKey(key)
+	if err != nil {
+		return authInfo{}, NewApiError(http.StatusUnauthorized, "Unauthorized: Invalid API key", err)
+	}
+
+	apiKey, err := cfg.db.GetAPIKeyByKey(key)
+	if err != nil {
+		return authInfo{}, NewInternalServerError(err)
+	}
+	if apiKey.ID == uuid.Nil || apiKey.RevokedAt != nil {
+		return authInfo{}, NewApiError(http.StatusUnauthorized, "Unauthorized: Invalid API key", nil)
+	}
+	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+		return authInfo{}, NewApiError(http.StatusUnauthorized, "Unauthorized: API key has expired", nil)
+	}
+
+	err = cfg.db.TouchAPIKey(apiKey.ID)
+	if err != nil {
+		return authInfo{}, NewInternalServerError(err)
+	}
+
+	return authInfo{UserID: apiKey.UserID, APIKeyID: apiKey.ID, Scopes: apiKey.Scopes}, nil
+}
+
+// withScope authenticates the request like withAuth and then rejects it
+// unless the token or API key was granted scope.
+func (cfg *apiConfig) withScope(scope string, handler AuthenticatedHandlerFunc) http.HandlerFunc {
+	return cfg.withAuth(func(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+		if !auth.HasScope(authFromContext(r.Context()).Scopes, scope) {
+			return NewApiError(http.StatusForbidden, fmt.Sprintf("Forbidden: missing scope %s", scope), nil)
 		}
+		return handler(w, r, userID)
+	})
+}
 
+// withRole only lets users holding at least role through. Privileged
+// routes can't be used with API keys.
+func (cfg *apiConfig) withRole(role database.UserRole, handler AuthenticatedHandlerFunc) http.HandlerFunc {
+	return cfg.withAuth(func(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+		err := requireSessionAuth(r)
+		if err != nil {
+			return err
+		}
+		if !authFromContext(r.Context()).Role.AtLeast(role) {
+			return NewApiError(http.StatusForbidden, "Forbidden: insufficient role", nil)
+		}
 		return handler(w, r, userID)
 	})
 }
+
+// withVerifiedEmail rejects users who haven't verified their email, when
+// REQUIRE_VERIFIED_EMAIL is set. It goes inside withAuth or withScope.
+func (cfg *apiConfig) withVerifiedEmail(handler AuthenticatedHandlerFunc) AuthenticatedHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
+		if cfg.requireVerified && !authFromContext(r.Context()).EmailVerified {
+			return NewApiError(http.StatusForbidden, "Please verify your email first", nil)
+		}
+		return handler(w, r, userID)
+	}
+}
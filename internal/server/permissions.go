@@ -0,0 +1,108 @@
+package server
+
+import (
+	"github.com/andycostintoma/tubely/internal/database"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// videoAccess is what a user may do with a video, each level including the
+// ones before it. Managing covers deciding who can see it, through its
+// visibility, schedule or collaborators, and deleting it, which
+// collaborators can't do.
+type videoAccess int
+
+const (
+	videoAccessNone videoAccess = iota
+	videoAccessView
+	videoAccessEdit
+	videoAccessManage
+)
+
+// videoAccessFor works out what the user may do with a video. Personal
+// videos are managed by the user who made them and videos in a workspace
+// follow the user's role there. On top of that, the video may have been
+// shared with the user as a collaborator.
+func (cfg *apiConfig) videoAccessFor(userID uuid.UUID, video database.Video) (videoAccess, error) {
+	if userID == uuid.Nil {
+		return videoAccessNone, nil
+	}
+
+	access := videoAccessNone
+	if video.WorkspaceID == nil {
+		if video.UserID == userID {
+			return videoAccessManage, nil
+		}
+	} else {
+		role, err := cfg.db.GetWorkspaceRole(*video.WorkspaceID, userID)
+		if err != nil {
+			return videoAccessNone, err
+		}
+		switch {
+		case role.AtLeast(database.WorkspaceRoleEditor):
+			return videoAccessManage, nil
+		case role.AtLeast(database.WorkspaceRoleViewer):
+			access = videoAccessView
+		}
+	}
+
+	granted, err := cfg.db.GetCollaboratorAccess(video.ID, userID)
+	if err != nil {
+		return videoAccessNone, err
+	}
+	switch granted {
+	case database.CollaboratorAccessEdit:
+		access = max(access, videoAccessEdit)
+	case database.CollaboratorAccessView:
+		access = max(access, videoAccessView)
+	}
+	return access, nil
+}
+
+// requireVideoAccess returns an ApiError unless the user may do at least
+// need with the video.
+func (cfg *apiConfig) requireVideoAccess(userID uuid.UUID, video database.Video, need videoAccess) error {
+	access, err := cfg.videoAccessFor(userID, video)
+	if err != nil {
+		return NewInternalServerError(err)
+	}
+	if access < need {
+		return NewApiError(http.StatusForbidden, "You don't have access to this video", nil)
+	}
+	return nil
+}
+
+// requireWorkspaceRole returns an ApiError unless the user holds at least
+// min in the workspace. Non-members are told it doesn't exist.
+func (cfg *apiConfig) requireWorkspaceRole(workspaceID, userID uuid.UUID, min database.WorkspaceRole) (database.WorkspaceRole, error) {
+	role, err := cfg.db.GetWorkspaceRole(workspaceID, userID)
+	if err != nil {
+		return "", NewInternalServerError(err)
+	}
+	if role == "" {
+		return "", NewApiError(http.StatusNotFound, "Workspace not found", nil)
+	}
+	if !role.AtLeast(min) {
+		return "", NewApiError(http.StatusForbidden, "Forbidden: insufficient workspace role", nil)
+	}
+	return role, nil
+}
+
+// workspaceFromQuery reads the optional workspace_id query parameter used
+// to switch a listing from the user's personal videos to a workspace's.
+func (cfg *apiConfig) workspaceFromQuery(r *http.Request, userID uuid.UUID, min database.WorkspaceRole) (*uuid.UUID, error) {
+	value := r.URL.Query().Get("workspace_id")
+	if value == "" {
+		return nil, nil
+	}
+	workspaceID, err := uuid.Parse(value)
+	if err != nil {
+		return nil, NewApiError(http.StatusBadRequest, "Invalid workspace ID", err)
+	}
+	_, err = cfg.requireWorkspaceRole(workspaceID, userID, min)
+	if err != nil {
+		return nil, err
+	}
+	return &workspaceID, nil
+}
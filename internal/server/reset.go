@@ -2,13 +2,18 @@ package server
 
 import (
 	"net/http"
+
+	"github.com/google/uuid"
 )
 
-func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) error {
+func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request, userID uuid.UUID) error {
 	if cfg.platform != "dev" {
 		return NewApiError(http.StatusForbidden, "Reset is only allowed in dev environment.", nil)
 	}
 
+	// Logged before resetting since the audit log is kept across resets.
+	cfg.audit(r, userID, "database.reset", "database", "", "")
+
 	err := cfg.db.Reset()
 	if err != nil {
 		return NewInternalServerError(err)
@@ -1,6 +1,10 @@
 package server
 
-import "net/http"
+import (
+	"github.com/andycostintoma/tubely/internal/auth"
+	"github.com/andycostintoma/tubely/internal/database"
+	"net/http"
+)
 
 func (cfg *apiConfig) RegisterRoutes() http.Handler {
 	mux := http.NewServeMux()
@@ -8,20 +12,107 @@ func (cfg *apiConfig) RegisterRoutes() http.Handler {
 	mux.Handle("/app/", appHandler)
 
 	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(cfg.assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.Handle("/assets/", noCacheMiddleware(cfg.withAssetAccess(assetsHandler)))
+
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
 
 	mux.HandleFunc("POST /api/users", withApiError(cfg.handlerUsersCreate))
+	mux.HandleFunc("POST /api/users/verify_email", withApiError(cfg.handlerVerifyEmail))
+	mux.HandleFunc("POST /api/password_reset", withApiError(cfg.handlerPasswordResetRequest))
+	mux.HandleFunc("POST /api/password_reset/confirm", withApiError(cfg.handlerPasswordResetConfirm))
 	mux.HandleFunc("POST /api/login", withApiError(cfg.handlerLogin))
+	mux.HandleFunc("GET /api/oidc/login", withApiError(cfg.handlerOIDCLogin))
+	mux.HandleFunc("POST /api/oidc/callback", withApiError(cfg.handlerOIDCCallback))
+	mux.HandleFunc("POST /api/login/mfa", withApiError(cfg.handlerLoginMFA))
 	mux.HandleFunc("POST /api/refresh", withApiError(cfg.handlerRefresh))
 	mux.HandleFunc("POST /api/revoke", withApiError(cfg.handlerRevoke))
 	mux.HandleFunc("GET /api/videos/{videoID}", withApiError(cfg.handlerVideoGet))
-	mux.HandleFunc("POST /admin/reset", withApiError(cfg.handlerReset))
+	mux.HandleFunc("POST /api/share", withApiError(cfg.handlerShareLinkOpen))
+	mux.HandleFunc("GET /share/{linkID}/media", withApiError(cfg.handlerShareLinkMedia))
+	mux.HandleFunc("GET /embed/{videoID}", withApiError(cfg.handlerEmbed))
+	mux.HandleFunc("GET /oembed", withApiError(cfg.handlerOEmbed))
+	mux.HandleFunc("GET /media/{videoID}", withApiError(cfg.handlerVideoMedia))
+	mux.HandleFunc("GET /feeds/{userID}/atom.xml", withApiError(cfg.handlerAtomFeed))
+	mux.HandleFunc("GET /feeds/{userID}/podcast.xml", withApiError(cfg.handlerPodcastFeed))
+
+	mux.HandleFunc("POST /api/videos", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerVideoMetaCreate))
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.withScope(auth.ScopeUploadsWrite, cfg.withVerifiedEmail(cfg.handlerUploadThumbnail)))
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.withScope(auth.ScopeUploadsWrite, cfg.withVerifiedEmail(cfg.handlerUploadVideo)))
+	mux.HandleFunc("GET /api/videos", cfg.withScope(auth.ScopeVideosRead, cfg.handlerVideosRetrieve))
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerVideoMetaUpdate))
+	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.withScope(auth.ScopeVideosDelete, cfg.handlerVideoMetaDelete))
+	mux.HandleFunc("GET /api/videos/{videoID}/tags", cfg.withScope(auth.ScopeVideosRead, cfg.handlerVideoTagsRetrieve))
+	mux.HandleFunc("POST /api/videos/{videoID}/tags", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerVideoTagsAdd))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/tags/{tag}", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerVideoTagDelete))
+	mux.HandleFunc("POST /api/videos/{videoID}/share_links", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerShareLinkCreate))
+	mux.HandleFunc("GET /api/videos/{videoID}/share_links", cfg.withScope(auth.ScopeVideosRead, cfg.handlerShareLinksRetrieve))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/share_links/{linkID}", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerShareLinkDelete))
+	mux.HandleFunc("PUT /api/videos/{videoID}/collaborators", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerVideoCollaboratorSet))
+	mux.HandleFunc("GET /api/videos/{videoID}/collaborators", cfg.withScope(auth.ScopeVideosRead, cfg.handlerVideoCollaboratorsRetrieve))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/collaborators/{userID}", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerVideoCollaboratorDelete))
+	mux.HandleFunc("GET /api/videos/{videoID}/invites", cfg.withScope(auth.ScopeVideosRead, cfg.handlerVideoInvitesRetrieve))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/invites/{inviteID}", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerVideoInviteDelete))
+	mux.HandleFunc("POST /api/video_invites/accept", cfg.withAuth(cfg.handlerVideoInviteAccept))
+	mux.HandleFunc("GET /api/tags", cfg.withScope(auth.ScopeVideosRead, cfg.handlerTagsRetrieve))
+	mux.HandleFunc("POST /api/playlists", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerPlaylistCreate))
+	mux.HandleFunc("GET /api/playlists", cfg.withScope(auth.ScopeVideosRead, cfg.handlerPlaylistsRetrieve))
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.withScope(auth.ScopeVideosRead, cfg.handlerPlaylistGet))
+	mux.HandleFunc("PATCH /api/playlists/{playlistID}", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerPlaylistUpdate))
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerPlaylistDelete))
+	mux.HandleFunc("POST /api/playlists/{playlistID}/items", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerPlaylistItemAdd))
+	mux.HandleFunc("PUT /api/playlists/{playlistID}/items", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerPlaylistReorder))
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}/items/{videoID}", cfg.withScope(auth.ScopeVideosWrite, cfg.handlerPlaylistItemDelete))
+	mux.HandleFunc("GET /api/trash", cfg.withScope(auth.ScopeVideosRead, cfg.handlerTrashRetrieve))
+	mux.HandleFunc("POST /api/trash/{videoID}/restore", cfg.withScope(auth.ScopeVideosDelete, cfg.handlerTrashRestore))
+	mux.HandleFunc("DELETE /api/trash/{videoID}", cfg.withScope(auth.ScopeVideosDelete, cfg.handlerTrashDelete))
+
+	// Account management isn't covered by scopes; API keys are rejected
+	// where that matters by requireSessionAuth.
+	mux.HandleFunc("PUT /api/users/password", cfg.withAuth(cfg.handlerPasswordChange))
+	mux.HandleFunc("PUT /api/users/email", cfg.withAuth(cfg.handlerEmailChange))
+	mux.HandleFunc("DELETE /api/users", cfg.withAuth(cfg.handlerUserDelete))
+	mux.HandleFunc("POST /api/users/resend_verification", cfg.withAuth(cfg.handlerResendVerification))
+	mux.HandleFunc("GET /api/users/feed", cfg.withAuth(cfg.handlerFeedSettingsRetrieve))
+	mux.HandleFunc("PUT /api/users/feed", cfg.withAuth(cfg.handlerFeedSettingsUpdate))
+	mux.HandleFunc("GET /api/mfa", cfg.withAuth(cfg.handlerMFARetrieve))
+	mux.HandleFunc("POST /api/mfa/totp", cfg.withAuth(cfg.handlerTOTPEnroll))
+	mux.HandleFunc("POST /api/mfa/totp/confirm", cfg.withAuth(cfg.handlerTOTPConfirm))
+	mux.HandleFunc("DELETE /api/mfa/totp", cfg.withAuth(cfg.handlerTOTPDisable))
+	mux.HandleFunc("GET /api/sessions", cfg.withAuth(cfg.handlerSessionsRetrieve))
+	mux.HandleFunc("DELETE /api/sessions", cfg.withAuth(cfg.handlerSessionsDelete))
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.withAuth(cfg.handlerSessionDelete))
+	mux.HandleFunc("POST /api/api_keys", cfg.withAuth(cfg.handlerAPIKeyCreate))
+	mux.HandleFunc("GET /api/api_keys", cfg.withAuth(cfg.handlerAPIKeysRetrieve))
+	mux.HandleFunc("DELETE /api/api_keys/{keyID}", cfg.withAuth(cfg.handlerAPIKeyDelete))
+	mux.HandleFunc("POST /api/workspaces", cfg.withAuth(cfg.handlerWorkspaceCreate))
+	mux.HandleFunc("GET /api/workspaces", cfg.withAuth(cfg.handlerWorkspacesRetrieve))
+	mux.HandleFunc("GET /api/workspaces/{workspaceID}", cfg.withAuth(cfg.handlerWorkspaceGet))
+	mux.HandleFunc("DELETE /api/workspaces/{workspaceID}", cfg.withAuth(cfg.handlerWorkspaceDelete))
+	mux.HandleFunc("PUT /api/workspaces/{workspaceID}/members/{userID}", cfg.withAuth(cfg.handlerWorkspaceMemberUpdate))
+	mux.HandleFunc("DELETE /api/workspaces/{workspaceID}/members/{userID}", cfg.withAuth(cfg.handlerWorkspaceMemberDelete))
+	mux.HandleFunc("POST /api/workspaces/{workspaceID}/invites", cfg.withAuth(cfg.handlerWorkspaceInviteCreate))
+	mux.HandleFunc("GET /api/workspaces/{workspaceID}/invites", cfg.withAuth(cfg.handlerWorkspaceInvitesRetrieve))
+	mux.HandleFunc("DELETE /api/workspaces/{workspaceID}/invites/{inviteID}", cfg.withAuth(cfg.handlerWorkspaceInviteDelete))
+	mux.HandleFunc("POST /api/workspace_invites/accept", cfg.withAuth(cfg.handlerWorkspaceInviteAccept))
+	mux.HandleFunc("POST /api/webhooks", cfg.withAuth(cfg.handlerWebhookCreate))
+	mux.HandleFunc("GET /api/webhooks", cfg.withAuth(cfg.handlerWebhooksRetrieve))
+	mux.HandleFunc("GET /api/webhooks/{webhookID}", cfg.withAuth(cfg.handlerWebhookGet))
+	mux.HandleFunc("DELETE /api/webhooks/{webhookID}", cfg.withAuth(cfg.handlerWebhookDelete))
+	mux.HandleFunc("POST /api/webhooks/{webhookID}/ping", cfg.withAuth(cfg.handlerWebhookPing))
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", cfg.withAuth(cfg.handlerWebhookDeliveriesRetrieve))
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries/{deliveryID}", cfg.withAuth(cfg.handlerWebhookDeliveryGet))
+	mux.HandleFunc("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver", cfg.withAuth(cfg.handlerWebhookRedeliver))
 
-	mux.HandleFunc("POST /api/videos", cfg.withAuth(cfg.handlerVideoMetaCreate))
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.withAuth(cfg.handlerUploadThumbnail))
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.withAuth(cfg.handlerUploadVideo))
-	mux.HandleFunc("GET /api/videos", cfg.withAuth(cfg.handlerVideosRetrieve))
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.withAuth(cfg.handlerVideoMetaDelete))
+	// Moderators can review and remove anyone's videos; managing accounts
+	// requires an admin.
+	mux.HandleFunc("POST /admin/reset", cfg.withRole(database.UserRoleAdmin, cfg.handlerReset))
+	mux.HandleFunc("GET /admin/users", cfg.withRole(database.UserRoleAdmin, cfg.handlerAdminUsersRetrieve))
+	mux.HandleFunc("POST /admin/users/{userID}/disable", cfg.withRole(database.UserRoleAdmin, cfg.handlerAdminUserDisable))
+	mux.HandleFunc("POST /admin/users/{userID}/enable", cfg.withRole(database.UserRoleAdmin, cfg.handlerAdminUserEnable))
+	mux.HandleFunc("PUT /admin/users/{userID}/role", cfg.withRole(database.UserRoleAdmin, cfg.handlerAdminUserRoleUpdate))
+	mux.HandleFunc("GET /admin/videos/{videoID}", cfg.withRole(database.UserRoleModerator, cfg.handlerAdminVideoGet))
+	mux.HandleFunc("DELETE /admin/videos/{videoID}", cfg.withRole(database.UserRoleModerator, cfg.handlerAdminVideoDelete))
+	mux.HandleFunc("GET /admin/audit_log", cfg.withRole(database.UserRoleAdmin, cfg.handlerAdminAuditLogRetrieve))
 
 	// Wrap the mux with CORS middleware
 	return corsMiddleware(mux)
@@ -32,7 +123,8 @@ func corsMiddleware(next http.Handler) http.Handler {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Replace "*" with specific origins if needed
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token, If-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 		w.Header().Set("Access-Control-Allow-Credentials", "false") // Set to "true" if credentials are required
 
 		// Handle preflight OPTIONS requests
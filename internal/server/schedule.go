@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/google/uuid"
+)
+
+// runVideoScheduler applies scheduled visibility changes. Schedules are
+// stored with the videos, so changes that came due while the server was
+// down are applied on the first pass after it starts.
+func (cfg *apiConfig) runVideoScheduler(ctx context.Context) {
+	ticker := time.NewTicker(cfg.scheduleEvery)
+	defer ticker.Stop()
+
+	for {
+		cfg.applyVideoSchedules()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyVideoSchedules publishes before unpublishing, so a video whose whole
+// window passed while the server was down still ends up private.
+func (cfg *apiConfig) applyVideoSchedules() {
+	now := time.Now()
+
+	published, err := cfg.db.PublishDueVideos(now)
+	if err != nil {
+		log.Printf("Couldn't publish scheduled videos: %v", err)
+	}
+	for _, video := range published {
+		cfg.auditScheduledChange(eventVideoPublished, video)
+		cfg.emitVideoEvent(eventVideoPublished, video)
+	}
+
+	unpublished, err := cfg.db.UnpublishDueVideos(now)
+	if err != nil {
+		log.Printf("Couldn't unpublish scheduled videos: %v", err)
+	}
+	for _, video := range unpublished {
+		cfg.auditScheduledChange(eventVideoUnpublished, video)
+		cfg.emitVideoEvent(eventVideoUnpublished, video)
+	}
+}
+
+// auditScheduledChange records a visibility change with no request behind
+// it. It shows up in the audit log with no actor.
+func (cfg *apiConfig) auditScheduledChange(action string, video database.Video) {
+	err := cfg.db.CreateAuditLogEntry(database.CreateAuditLogEntryParams{
+		ActorID:    uuid.Nil,
+		Action:     action,
+		TargetType: "video",
+		TargetID:   video.ID.String(),
+		Details:    string(video.Visibility),
+	})
+	if err != nil {
+		log.Printf("Couldn't write audit log entry %s video/%s: %v", action, video.ID, err)
+	}
+}
@@ -3,13 +3,21 @@ package server
 import (
 	"context"
 	"fmt"
+	"github.com/andycostintoma/tubely/internal/auth"
 	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/andycostintoma/tubely/internal/mail"
+	"github.com/andycostintoma/tubely/internal/oidc"
 	"github.com/andycostintoma/tubely/internal/utils"
+	"github.com/andycostintoma/tubely/internal/webhook"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,7 +26,10 @@ type apiConfig struct {
 	port              string
 	platform          string
 	db                database.Client
-	jwtSecret         string
+	jwtKeys           *auth.KeySet
+	oidcProvider      *oidc.Provider
+	mailer            mail.Mailer
+	requireVerified   bool
 	filepathRoot      string
 	assetsRoot        string
 	thumbnailsStorage string
@@ -29,6 +40,15 @@ type apiConfig struct {
 	s3Bucket          string
 	s3Region          string
 	s3CfDistribution  string
+	trashRetention    time.Duration
+	trashPurgeEvery   time.Duration
+	scheduleEvery     time.Duration
+	uploadTimeout     time.Duration
+	trustedProxies    []netip.Prefix
+
+	webhookClient        *webhook.Client
+	webhookDispatchEvery time.Duration
+	webhookWake          chan struct{}
 }
 
 func newApiConfig() (*apiConfig, error) {
@@ -58,6 +78,33 @@ func newApiConfig() (*apiConfig, error) {
 		return nil, fmt.Errorf("could not connect to database: %v", err)
 	}
 
+	// ADMIN_EMAILS bootstraps the first admins, since only admins can grant
+	// roles through the API. Once there is an admin it's ignored.
+	var adminEmails []string
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		email = strings.TrimSpace(email)
+		if email != "" {
+			adminEmails = append(adminEmails, email)
+		}
+	}
+	promoted, err := db.BootstrapAdmins(adminEmails)
+	if err != nil {
+		return nil, fmt.Errorf("could not promote admins: %v", err)
+	}
+	for _, user := range promoted {
+		log.Printf("Promoted %s to admin from ADMIN_EMAILS", user.Email)
+		err = db.CreateAuditLogEntry(database.CreateAuditLogEntryParams{
+			ActorID:    uuid.Nil,
+			Action:     "user.role",
+			TargetType: "user",
+			TargetID:   user.ID.String(),
+			Details:    fmt.Sprintf("%s -> %s (ADMIN_EMAILS)", user.Role, database.UserRoleAdmin),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not audit admin promotion: %v", err)
+		}
+	}
+
 	thumbnailStorage := os.Getenv("THUMBNAILS_STORAGE")
 	if thumbnailStorage == "" {
 		return nil, fmt.Errorf("environment variable THUMBNAILS_STORAGE is not set")
@@ -66,9 +113,40 @@ func newApiConfig() (*apiConfig, error) {
 		return nil, fmt.Errorf("THUMBNAILS_STORAGE %s is not allowed. Must be one of: db, fs", thumbnailStorage)
 	}
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return nil, fmt.Errorf("environment variable JWT_SECRET is not set")
+	jwtKeys, err := loadJWTKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var oidcProvider *oidc.Provider
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		clientID := os.Getenv("OIDC_CLIENT_ID")
+		if clientID == "" {
+			return nil, fmt.Errorf("environment variable OIDC_CLIENT_ID is not set")
+		}
+		redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if redirectURL == "" {
+			return nil, fmt.Errorf("environment variable OIDC_REDIRECT_URL is not set")
+		}
+		oidcProvider = oidc.NewProvider(oidc.Config{
+			Issuer:       issuer,
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+		})
+	}
+
+	mailer, err := newMailer()
+	if err != nil {
+		return nil, err
+	}
+
+	requireVerified := false
+	if value := os.Getenv("REQUIRE_VERIFIED_EMAIL"); value != "" {
+		requireVerified, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable REQUIRE_VERIFIED_EMAIL is not a valid boolean: %v", err)
+		}
 	}
 
 	filepathRoot := os.Getenv("FILEPATH_ROOT")
@@ -114,6 +192,46 @@ func newApiConfig() (*apiConfig, error) {
 		}
 	}
 
+	trashRetention, err := getEnvDuration("TRASH_RETENTION", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	trashPurgeEvery, err := getEnvDuration("TRASH_PURGE_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduleEvery, err := getEnvDuration("SCHEDULE_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadTimeout, err := getEnvDuration("UPLOAD_TIMEOUT", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	webhookDispatchEvery, err := getEnvDuration("WEBHOOK_DISPATCH_INTERVAL", 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	// Webhooks may only reach the public internet, except in development
+	// where receivers usually run on localhost.
+	webhookAllowPrivate := platform == "dev"
+	if value := os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS"); value != "" {
+		webhookAllowPrivate, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable WEBHOOK_ALLOW_PRIVATE_NETWORKS is not a valid boolean: %v", err)
+		}
+	}
+
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, err
+	}
+
 	awsConfig, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
 
 	s3Client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
@@ -129,7 +247,10 @@ func newApiConfig() (*apiConfig, error) {
 		port:              port,
 		platform:          platform,
 		db:                db,
-		jwtSecret:         jwtSecret,
+		jwtKeys:           jwtKeys,
+		oidcProvider:      oidcProvider,
+		mailer:            mailer,
+		requireVerified:   requireVerified,
 		filepathRoot:      filepathRoot,
 		assetsRoot:        assetsRoot,
 		thumbnailsStorage: thumbnailStorage,
@@ -139,6 +260,15 @@ func newApiConfig() (*apiConfig, error) {
 		s3Bucket:          s3Bucket,
 		s3Region:          s3Region,
 		s3CfDistribution:  s3CfDistribution,
+		trashRetention:    trashRetention,
+		trashPurgeEvery:   trashPurgeEvery,
+		scheduleEvery:     scheduleEvery,
+		uploadTimeout:     uploadTimeout,
+		trustedProxies:    trustedProxies,
+
+		webhookClient:        webhook.NewClient(webhookTimeout, webhookAllowPrivate),
+		webhookDispatchEvery: webhookDispatchEvery,
+		webhookWake:          make(chan struct{}, 1),
 	}
 
 	return &cfg, nil
@@ -156,6 +286,12 @@ func NewServer() (*http.Server, error) {
 		return nil, err
 	}
 
+	go cfg.runTrashPurger(context.Background())
+	go cfg.runVideoScheduler(context.Background())
+	go cfg.runStalledUploadCheck(context.Background())
+	go cfg.runWebhookDispatcher(context.Background())
+	go cfg.runLoginAttemptCleanup(context.Background())
+
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%v", cfg.port),
 		Handler:      cfg.RegisterRoutes(),
@@ -168,3 +304,117 @@ func NewServer() (*http.Server, error) {
 
 	return server, nil
 }
+
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("environment variable %s is not a valid duration: %v", key, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("environment variable %s must be positive", key)
+	}
+	return d, nil
+}
+
+// parseTrustedProxies parses a comma-separated list of the addresses or
+// CIDR ranges of reverse proxies whose forwarding headers are believed.
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES entry %q is not an address or CIDR range: %v", entry, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES entry %q is not an address or CIDR range: %v", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// loadJWTKeys builds the access token key set. JWT_SIGNING_KEY_FILE is the
+// PEM private key tokens are signed with. To rotate it, move the old key to
+// JWT_VERIFICATION_KEY_FILES until the tokens it signed have expired. Without
+// a signing key, tokens are signed with JWT_SECRET as before; with one,
+// JWT_SECRET only keeps existing HS256 tokens valid and can be unset once
+// they have expired.
+func loadJWTKeys() (*auth.KeySet, error) {
+	var signingKey *auth.SigningKey
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		key, err := auth.LoadSigningKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not load JWT_SIGNING_KEY_FILE: %v", err)
+		}
+		signingKey = key
+	}
+
+	var verificationKeys []*auth.SigningKey
+	for _, path := range strings.Split(os.Getenv("JWT_VERIFICATION_KEY_FILES"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		key, err := auth.LoadSigningKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not load JWT_VERIFICATION_KEY_FILES: %v", err)
+		}
+		verificationKeys = append(verificationKeys, key)
+	}
+
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if signingKey == nil && jwtSecret == "" {
+		return nil, fmt.Errorf("environment variable JWT_SECRET or JWT_SIGNING_KEY_FILE must be set")
+	}
+
+	return auth.NewKeySet(signingKey, verificationKeys, jwtSecret)
+}
+
+// newMailer picks how emails are delivered with MAILER: "log" (the default)
+// and "file" write them out for development, "smtp" sends them.
+func newMailer() (mail.Mailer, error) {
+	switch mailer := os.Getenv("MAILER"); mailer {
+	case "", "log":
+		return mail.NewLogMailer(), nil
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("environment variable MAIL_FILE is not set")
+		}
+		return mail.NewFileMailer(path)
+	case "smtp":
+		m := &mail.SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+		if m.Host == "" {
+			return nil, fmt.Errorf("environment variable SMTP_HOST is not set")
+		}
+		if m.From == "" {
+			return nil, fmt.Errorf("environment variable MAIL_FROM is not set")
+		}
+		if m.Port == "" {
+			m.Port = "587"
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("MAILER %s is not allowed. Must be one of: log, file, smtp", mailer)
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andycostintoma/tubely/internal/auth"
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/andycostintoma/tubely/internal/mail"
+	"github.com/andycostintoma/tubely/internal/webhook"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// newTestConfig returns a config backed by a fresh database in a temporary
+// directory. No background jobs are started; tests run them by hand.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	db, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtKeys, err := auth.NewKeySet(nil, nil, "test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &apiConfig{
+		serverURL:            "http://localhost",
+		port:                 "8091",
+		platform:             "dev",
+		db:                   db,
+		jwtKeys:              jwtKeys,
+		mailer:               mail.NewLogMailer(),
+		assetsRoot:           t.TempDir(),
+		thumbnailsStorage:    "db",
+		s3Client:             newTestS3Client(),
+		s3URLMode:            "presigned",
+		s3Bucket:             "tubely-test",
+		s3Region:             "us-east-1",
+		trashRetention:       time.Hour,
+		trashPurgeEvery:      time.Hour,
+		scheduleEvery:        time.Hour,
+		webhookClient:        webhook.NewClient(time.Second, true),
+		webhookDispatchEvery: time.Hour,
+		webhookWake:          make(chan struct{}, 1),
+	}
+}
+
+// newTestS3Client can presign URLs, which works offline, but not reach S3.
+func newTestS3Client() *s3.Client {
+	return s3.New(s3.Options{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+	})
+}
+
+// newTestServer serves cfg's routes over TLS, so the Secure cookies the
+// server sets come back. The client keeps cookies and doesn't follow
+// redirects.
+func newTestServer(t *testing.T, cfg *apiConfig) (*httptest.Server, *http.Client) {
+	t.Helper()
+	srv := httptest.NewTLSServer(cfg.RegisterRoutes())
+	t.Cleanup(srv.Close)
+	return srv, newTestClient(t, srv)
+}
+
+func newTestClient(t *testing.T, srv *httptest.Server) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// srv.Client is shared, so each client gets its own copy.
+	return &http.Client{
+		Transport: srv.Client().Transport,
+		Jar:       jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// doJSON sends body as JSON and decodes the response into out, if given,
+// returning the status code.
+func doJSON(t *testing.T, client *http.Client, method, url, token string, body, out any) int {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%s %s: couldn't decode %q: %v", method, url, data, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// signUp creates a password account, verifying its email if asked to.
+func signUp(t *testing.T, cfg *apiConfig, email string, verified bool) database.User {
+	t.Helper()
+	hash, err := auth.HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified {
+		if _, err := cfg.db.SetUserEmailVerified(user.ID, email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return *user
+}
+
+// logIn signs in with the password signUp sets and returns the access
+// token.
+func logIn(t *testing.T, client *http.Client, srv *httptest.Server, email string) string {
+	t.Helper()
+	var resp struct {
+		Token string `json:"token"`
+		Error string `json:"error"`
+	}
+	status := doJSON(t, client, http.MethodPost, srv.URL+"/api/login", "", map[string]string{
+		"email":    email,
+		"password": "password",
+	}, &resp)
+	if status != http.StatusOK {
+		t.Fatalf("login returned %d: %s", status, resp.Error)
+	}
+	return resp.Token
+}
@@ -9,13 +9,16 @@ import (
 	"github.com/andycostintoma/tubely/internal/utils"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"io"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
 type Storage interface {
 	Save(ctx context.Context, r io.Reader, contentType string) (string, error)
+	Delete(ctx context.Context, url string) error
 }
 
 type FSStorage struct {
@@ -45,7 +48,30 @@ func (fs *FSStorage) Save(_ context.Context, r io.Reader, mediaType string) (str
 		return "", err
 	}
 
-	return fmt.Sprintf("%v:%v/%v", fs.ServerURL, fs.Port, filePath), nil
+	return fs.urlFor(filePath), nil
+}
+
+// urlFor returns the URL a file saved at filePath is served from.
+func (fs *FSStorage) urlFor(filePath string) string {
+	return fmt.Sprintf("%v:%v/%v", fs.ServerURL, fs.Port, filePath)
+}
+
+func (fs *FSStorage) Delete(_ context.Context, url string) error {
+	filePath, found := strings.CutPrefix(url, fmt.Sprintf("%v:%v/", fs.ServerURL, fs.Port))
+	if !found {
+		return fmt.Errorf("url %s is not served from this server", url)
+	}
+
+	rel, err := filepath.Rel(filepath.Clean(fs.AssetsRoot), filepath.Clean(filePath))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("path %s is outside the assets root", filePath)
+	}
+
+	err = os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 type DBStorage struct{}
@@ -59,6 +85,11 @@ func (db *DBStorage) Save(_ context.Context, r io.Reader, mediaType string) (str
 	return fmt.Sprintf("data:%v;base64,%v", mediaType, encoded), nil
 }
 
+// Delete is a no-op since the data URL is stored inline with the video.
+func (db *DBStorage) Delete(_ context.Context, _ string) error {
+	return nil
+}
+
 type S3Storage struct {
 	Client        *s3.Client
 	Region        string
@@ -95,6 +126,109 @@ func (s *S3Storage) Save(ctx context.Context, r io.Reader, mediaType string) (st
 	}
 }
 
+func (s *S3Storage) Delete(ctx context.Context, url string) error {
+	bucket, key, err := s.objectFromURL(url)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete from S3: %w", err)
+	}
+	return nil
+}
+
+// objectFromURL reverses the URL formats produced by Save. Every format is
+// recognized whatever the current URL mode, since objects keep the URL they
+// were saved with when the mode changes.
+func (s *S3Storage) objectFromURL(rawURL string) (bucket, key string, err error) {
+	// Presigned mode stores "bucket,key" rather than a URL.
+	if !strings.Contains(rawURL, "://") {
+		bucket, key, found := strings.Cut(rawURL, ",")
+		if !found || bucket == "" || key == "" {
+			return "", "", errors.New("invalid video URL")
+		}
+		return bucket, key, nil
+	}
+
+	if s.LocalstackURL != "" {
+		rest, found := strings.CutPrefix(rawURL, strings.TrimSuffix(s.LocalstackURL, "/")+"/")
+		if found {
+			bucket, key, _ := strings.Cut(rest, "/")
+			if bucket == "" || key == "" {
+				return "", "", fmt.Errorf("url %s has no object key", rawURL)
+			}
+			return bucket, key, nil
+		}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", err
+	}
+	key = strings.TrimPrefix(u.Path, "/")
+	if key == "" {
+		return "", "", fmt.Errorf("url %s has no object key", rawURL)
+	}
+	// Public URLs name the bucket in the host: bucket.s3.region.amazonaws.com.
+	if host, found := strings.CutSuffix(u.Host, ".amazonaws.com"); found {
+		if bucket, _, found := strings.Cut(host, ".s3."); found && bucket != "" {
+			return bucket, key, nil
+		}
+	}
+	if s.CloudFrontURL != "" && u.Host == s.CloudFrontURL {
+		return s.Bucket, key, nil
+	}
+	return "", "", fmt.Errorf("url %s does not belong to a known bucket", rawURL)
+}
+
+func (cfg *apiConfig) videoStorage(key string) *S3Storage {
+	return &S3Storage{
+		Client:        cfg.s3Client,
+		Region:        cfg.s3Region,
+		Bucket:        cfg.s3Bucket,
+		Key:           key,
+		URLMode:       cfg.s3URLMode,
+		LocalstackURL: cfg.localstackURL,
+		CloudFrontURL: cfg.s3CfDistribution,
+	}
+}
+
+// thumbnailStorageFor picks the backend a thumbnail URL was saved with,
+// which may differ from the one currently configured.
+func (cfg *apiConfig) thumbnailStorageFor(url string) Storage {
+	if strings.HasPrefix(url, "data:") {
+		return &DBStorage{}
+	}
+	return cfg.fsStorage()
+}
+
+func (cfg *apiConfig) fsStorage() *FSStorage {
+	return &FSStorage{AssetsRoot: cfg.assetsRoot, ServerURL: cfg.serverURL, Port: cfg.port}
+}
+
+// deleteVideoObjects removes the video file and thumbnail of a video from
+// the storage backends they were saved to.
+func (cfg *apiConfig) deleteVideoObjects(ctx context.Context, video database.Video) error {
+	if video.ThumbnailURL != nil {
+		err := cfg.thumbnailStorageFor(*video.ThumbnailURL).Delete(ctx, *video.ThumbnailURL)
+		if err != nil {
+			return fmt.Errorf("couldn't delete thumbnail: %w", err)
+		}
+	}
+	if video.VideoURL != nil {
+		err := cfg.videoStorage("").Delete(ctx, *video.VideoURL)
+		if err != nil {
+			return fmt.Errorf("couldn't delete video file: %w", err)
+		}
+	}
+	return nil
+}
+
 func generatePreSignedURL(context context.Context, s3Client *s3.Client, bucket, key string, expireTime time.Duration) (string, error) {
 	preSignClient := s3.NewPresignClient(s3Client)
 
@@ -109,24 +243,47 @@ func generatePreSignedURL(context context.Context, s3Client *s3.Client, bucket,
 	return req.URL, nil
 }
 
-func (cfg *apiConfig) dbVideoToSignedVideo(context context.Context, video database.Video) (database.Video, error) {
-	videoURL := video.VideoURL
-	parts := strings.Split(*videoURL, ",")
-	if len(parts) != 2 {
-		return database.Video{}, errors.New("invalid video URL")
+// resolveVideoURL turns the stored video URL into one a client can play.
+// In presigned mode the database holds "bucket,key" and a short-lived
+// signed URL is generated on every read. Private videos get a signed URL in
+// every mode, so the permanent URL of their file is never handed out.
+func (cfg *apiConfig) resolveVideoURL(ctx context.Context, video database.Video) (database.Video, error) {
+	if video.VideoURL == nil {
+		return video, nil
+	}
+	// Links to private videos expire sooner, since anyone holding one can
+	// play the video until it does.
+	switch {
+	case video.Visibility == database.VideoVisibilityPrivate:
+		return cfg.dbVideoToSignedVideo(ctx, video, 5*time.Minute)
+	case cfg.s3URLMode == "presigned":
+		return cfg.dbVideoToSignedVideo(ctx, video, 15*time.Minute)
+	default:
+		return video, nil
+	}
+}
+
+func (cfg *apiConfig) resolveVideoURLs(ctx context.Context, videos []database.Video) ([]database.Video, error) {
+	resolved := make([]database.Video, 0, len(videos))
+	for _, video := range videos {
+		video, err := cfg.resolveVideoURL(ctx, video)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, video)
+	}
+	return resolved, nil
+}
+
+func (cfg *apiConfig) dbVideoToSignedVideo(context context.Context, video database.Video, expireTime time.Duration) (database.Video, error) {
+	bucket, key, err := cfg.videoStorage("").objectFromURL(*video.VideoURL)
+	if err != nil {
+		return database.Video{}, err
 	}
-	bucket := parts[0]
-	key := parts[1]
-	signedUrl, err := generatePreSignedURL(context, cfg.s3Client, bucket, key, 15*time.Minute)
+	signedUrl, err := generatePreSignedURL(context, cfg.s3Client, bucket, key, expireTime)
 	if err != nil {
 		return database.Video{}, err
 	}
-	return database.Video{
-		ID:                video.ID,
-		CreatedAt:         video.CreatedAt,
-		UpdatedAt:         time.Now(),
-		ThumbnailURL:      video.ThumbnailURL,
-		VideoURL:          &signedUrl,
-		CreateVideoParams: video.CreateVideoParams,
-	}, nil
+	video.VideoURL = &signedUrl
+	return video, nil
 }
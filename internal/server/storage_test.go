@@ -0,0 +1,47 @@
+package server
+
+import "testing"
+
+func TestS3ObjectFromURL(t *testing.T) {
+	// The storage is configured for CloudFront now, but objects saved under
+	// any earlier mode must still be found.
+	s := &S3Storage{
+		Region:        "eu-west-1",
+		Bucket:        "tubely-videos",
+		URLMode:       "cloudfront",
+		LocalstackURL: "http://localhost:4566",
+		CloudFrontURL: "d111111abcdef8.cloudfront.net",
+	}
+
+	tests := []struct {
+		url    string
+		bucket string
+		key    string
+	}{
+		{"old-bucket,landscape/abc.mp4", "old-bucket", "landscape/abc.mp4"},
+		{"http://localhost:4566/local-bucket/portrait/abc.mp4", "local-bucket", "portrait/abc.mp4"},
+		{"https://old-bucket.s3.us-east-1.amazonaws.com/other/abc.mp4", "old-bucket", "other/abc.mp4"},
+		{"https://d111111abcdef8.cloudfront.net/landscape/abc.mp4", "tubely-videos", "landscape/abc.mp4"},
+	}
+	for _, tt := range tests {
+		bucket, key, err := s.objectFromURL(tt.url)
+		if err != nil {
+			t.Errorf("%s: %v", tt.url, err)
+			continue
+		}
+		if bucket != tt.bucket || key != tt.key {
+			t.Errorf("%s: got %s/%s, want %s/%s", tt.url, bucket, key, tt.bucket, tt.key)
+		}
+	}
+
+	for _, url := range []string{
+		"no-key,",
+		"https://example.com/landscape/abc.mp4",
+		"https://d111111abcdef8.cloudfront.net/",
+		"http://localhost:4566/local-bucket",
+	} {
+		if bucket, key, err := s.objectFromURL(url); err == nil {
+			t.Errorf("%s: got %s/%s, want an error", url, bucket, key)
+		}
+	}
+}
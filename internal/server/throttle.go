@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/andycostintoma/tubely/internal/auth"
+	"github.com/andycostintoma/tubely/internal/database"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// throttlePolicy slows down repeated failures. The first freeAttempts
+// failures cost nothing; after that each one locks the key for twice as
+// long as the last, starting at baseDelay, up to maxDelay.
+type throttlePolicy struct {
+	freeAttempts int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+}
+
+// forgetFailuresAfter is how long a key has to go without failures for its
+// count to start over.
+const forgetFailuresAfter = 24 * time.Hour
+
+var (
+	// A single address may legitimately serve many users, so it gets more
+	// attempts than a single account.
+	ipThrottle      = throttlePolicy{freeAttempts: 20, baseDelay: time.Second, maxDelay: 15 * time.Minute}
+	accountThrottle = throttlePolicy{freeAttempts: 5, baseDelay: time.Second, maxDelay: 15 * time.Minute}
+	mfaThrottle     = throttlePolicy{freeAttempts: 3, baseDelay: 30 * time.Second, maxDelay: 15 * time.Minute}
+)
+
+func (p throttlePolicy) delay(failures int) time.Duration {
+	if failures < p.freeAttempts {
+		return 0
+	}
+	d := p.baseDelay
+	for i := p.freeAttempts; i < failures; i++ {
+		d *= 2
+		if d >= p.maxDelay {
+			return p.maxDelay
+		}
+	}
+	return d
+}
+
+type throttleKey struct {
+	key    string
+	policy throttlePolicy
+}
+
+// runLoginAttemptCleanup deletes failure counts that would be forgotten
+// anyway, so the table doesn't grow with every address that ever failed.
+func (cfg *apiConfig) runLoginAttemptCleanup(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		err := cfg.db.DeleteLoginAttemptsBefore(time.Now().Add(-forgetFailuresAfter))
+		if err != nil {
+			log.Printf("Couldn't clean up login attempts: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) ipThrottleKey(r *http.Request) throttleKey {
+	return throttleKey{"ip:" + cfg.clientIP(r), ipThrottle}
+}
+
+// accountThrottleKey is keyed by the email typed in rather than the user,
+// so emails without an account are throttled exactly like ones with one.
+func accountThrottleKey(email string) throttleKey {
+	return throttleKey{"account:" + strings.ToLower(strings.TrimSpace(email)), accountThrottle}
+}
+
+func mfaThrottleKey(userID uuid.UUID) throttleKey {
+	return throttleKey{"mfa:" + userID.String(), mfaThrottle}
+}
+
+// shareLinkThrottleKey guards the password of a share link, which may be
+// weaker than an account password.
+func shareLinkThrottleKey(linkID uuid.UUID) throttleKey {
+	return throttleKey{"share:" + linkID.String(), accountThrottle}
+}
+
+// reserveAttempt counts the attempt about to be made as a failure for each
+// key, or refuses it with a 429 and Retry-After if any of them is locked.
+// Counting up front means parallel attempts can't all get in before the
+// first failure is recorded. It runs before any password is checked, so
+// locked out clients can't make the server spend time on bcrypt either.
+// Attempts that succeed are given back with releaseAttempt or clearFailures.
+func (cfg *apiConfig) reserveAttempt(w http.ResponseWriter, keys ...throttleKey) error {
+	var retryAfter time.Duration
+	for i, k := range keys {
+		wait, err := cfg.db.ReserveLoginAttempt(k.key, forgetFailuresAfter, func(attempts database.LoginAttempts) time.Duration {
+			return time.Until(attempts.LastFailedAt.Add(k.policy.delay(attempts.Failures)))
+		})
+		if err != nil {
+			return NewInternalServerError(err)
+		}
+		if wait > 0 {
+			// The attempt won't be made, so don't count it against the
+			// keys that weren't locked.
+			err = cfg.releaseAttempt(keys[:i]...)
+			if err != nil {
+				return err
+			}
+			retryAfter = wait
+			break
+		}
+	}
+	if retryAfter <= 0 {
+		return nil
+	}
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return NewApiError(http.StatusTooManyRequests, "Too many failed attempts, try again later", nil)
+}
+
+func (cfg *apiConfig) releaseAttempt(keys ...throttleKey) error {
+	for _, k := range keys {
+		err := cfg.db.ReleaseLoginAttempt(k.key)
+		if err != nil {
+			return NewInternalServerError(err)
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) clearFailures(keys ...throttleKey) error {
+	for _, k := range keys {
+		err := cfg.db.ClearLoginFailures(k.key)
+		if err != nil {
+			return NewInternalServerError(err)
+		}
+	}
+	return nil
+}
+
+var (
+	dummyPasswordHashOnce sync.Once
+	dummyPasswordHash     string
+)
+
+// checkPasswordConstantTime checks password against hash like
+// auth.CheckPasswordHash, but still does the work of a bcrypt comparison
+// when there's no hash, because the user doesn't exist or has no password.
+// Otherwise the response time would reveal which emails have accounts.
+func checkPasswordConstantTime(password, hash string) error {
+	if hash != "" {
+		return auth.CheckPasswordHash(password, hash)
+	}
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = auth.HashPassword("not the password of any user")
+	})
+	auth.CheckPasswordHash(password, dummyPasswordHash)
+	return fmt.Errorf("no password set")
+}
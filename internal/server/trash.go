@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+func (cfg *apiConfig) runTrashPurger(ctx context.Context) {
+	ticker := time.NewTicker(cfg.trashPurgeEvery)
+	defer ticker.Stop()
+
+	for {
+		cfg.purgeTrash(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeTrash permanently deletes videos that have been in the trash for
+// longer than the retention period, along with their stored objects.
+func (cfg *apiConfig) purgeTrash(ctx context.Context) {
+	videos, err := cfg.db.GetVideosTrashedBefore(time.Now().Add(-cfg.trashRetention))
+	if err != nil {
+		log.Printf("Couldn't list expired trash: %v", err)
+		return
+	}
+
+	for _, video := range videos {
+		err = cfg.deleteVideoObjects(ctx, video)
+		if err != nil {
+			log.Printf("Couldn't purge video %v: %v", video.ID, err)
+			continue
+		}
+		err = cfg.db.DeleteVideo(video.ID)
+		if err != nil {
+			log.Printf("Couldn't purge video %v: %v", video.ID, err)
+			continue
+		}
+		log.Printf("Purged video %v from trash", video.ID)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const stalledUploadCheckEvery = time.Minute
+
+// runStalledUploadCheck fails videos whose upload has been going on for
+// longer than the upload timeout, which is what's left when the server
+// stopped in the middle of one. Without it, those videos could never be
+// uploaded again.
+func (cfg *apiConfig) runStalledUploadCheck(ctx context.Context) {
+	ticker := time.NewTicker(stalledUploadCheckEvery)
+	defer ticker.Stop()
+
+	for {
+		cfg.failStalledUploads()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) failStalledUploads() {
+	videos, err := cfg.db.FailStalledUploads(time.Now().Add(-cfg.uploadTimeout), "Upload didn't finish")
+	if err != nil {
+		log.Printf("Couldn't check for stalled uploads: %v", err)
+		return
+	}
+	for _, video := range videos {
+		log.Printf("Upload of video %v didn't finish, marked it as failed", video.ID)
+		cfg.emitVideoEvent(eventVideoFailed, video)
+	}
+}
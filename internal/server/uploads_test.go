@@ -0,0 +1,108 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andycostintoma/tubely/internal/database"
+)
+
+func TestFailStalledUploads(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := signUp(t, cfg, "uploader@example.com", true)
+
+	newVideo := func(statuses ...database.VideoStatus) database.Video {
+		t.Helper()
+		video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Video", UserID: user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, status := range statuses {
+			if err := cfg.db.SetVideoStatus(video.ID, status, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return video
+	}
+	uploading := newVideo(database.VideoStatusUploading)
+	processing := newVideo(database.VideoStatusUploading, database.VideoStatusProcessing)
+	draft := newVideo()
+
+	// Nothing has been running for an hour yet.
+	cfg.uploadTimeout = time.Hour
+	cfg.failStalledUploads()
+	if got, _ := cfg.db.GetVideo(uploading.ID); got.Status != database.VideoStatusUploading {
+		t.Fatalf("a running upload was marked %s", got.Status)
+	}
+
+	cfg.uploadTimeout = -time.Minute
+	cfg.failStalledUploads()
+	for _, video := range []database.Video{uploading, processing} {
+		got, err := cfg.db.GetVideo(video.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != database.VideoStatusFailed || got.FailureReason == nil {
+			t.Errorf("stalled video is %s, want failed with a reason", got.Status)
+		}
+		// Which lets it be uploaded again.
+		if err := cfg.db.SetVideoStatus(video.ID, database.VideoStatusUploading, ""); err != nil {
+			t.Errorf("couldn't upload the video again: %v", err)
+		}
+	}
+	if got, _ := cfg.db.GetVideo(draft.ID); got.Status != database.VideoStatusDraft {
+		t.Errorf("draft video was marked %s", got.Status)
+	}
+}
+
+func TestCompleteVideoUpload(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := signUp(t, cfg, "uploader@example.com", true)
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Video", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(url string) (*string, error) {
+		t.Helper()
+		for _, status := range []database.VideoStatus{database.VideoStatusUploading, database.VideoStatusProcessing} {
+			if err := cfg.db.SetVideoStatus(video.ID, status, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return cfg.db.CompleteVideoUpload(video.ID, database.CompleteVideoUploadParams{VideoURL: url, AspectRatio: "16:9", VideoSize: 1})
+	}
+
+	previous, err := upload("bucket,first.mp4")
+	if err != nil || previous != nil {
+		t.Fatalf("first upload replaced %v, %v", previous, err)
+	}
+	// A new upload hands back the file it replaced, so it can be deleted.
+	previous, err = upload("bucket,second.mp4")
+	if err != nil || previous == nil || *previous != "bucket,first.mp4" {
+		t.Fatalf("second upload replaced %v, %v; want the first file", previous, err)
+	}
+	got, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != database.VideoStatusReady || got.VideoURL == nil || *got.VideoURL != "bucket,second.mp4" {
+		t.Errorf("got %s video at %v, want ready at the second file", got.Status, got.VideoURL)
+	}
+
+	// An upload that was given up on while processing doesn't overwrite
+	// the video afterwards.
+	if err := cfg.db.SetVideoStatus(video.ID, database.VideoStatusUploading, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.SetVideoStatus(video.ID, database.VideoStatusFailed, "gave up"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.db.CompleteVideoUpload(video.ID, database.CompleteVideoUploadParams{VideoURL: "bucket,late.mp4"})
+	if err == nil {
+		t.Error("a failed upload was completed")
+	}
+	if got, _ := cfg.db.GetVideo(video.ID); *got.VideoURL != "bucket,second.mp4" {
+		t.Errorf("video points at %s after a failed upload", *got.VideoURL)
+	}
+}
@@ -2,8 +2,12 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"strings"
 )
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -17,3 +21,58 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(data)
 }
+
+// logSecurityEvent records suspicious activity with enough request context
+// to trace where it came from.
+func logSecurityEvent(r *http.Request, event string, format string, args ...any) {
+	log.Printf("SECURITY %s: %s (remote=%s user_agent=%q)", event, fmt.Sprintf(format, args...), r.RemoteAddr, r.UserAgent())
+}
+
+// clientIP returns the address of the client that made the request. Behind
+// a reverse proxy listed in TRUSTED_PROXIES, that's the last address in
+// X-Forwarded-For the trusted proxies didn't add themselves, or X-Real-IP.
+// Forwarding headers from anyone else are ignored since they can be set by
+// anyone.
+func (cfg *apiConfig) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !cfg.isTrustedProxy(host) {
+		return host
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return addr.Unmap().String()
+		}
+		return host
+	}
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		host = addr.Unmap().String()
+		if !cfg.isTrustedProxy(host) {
+			break
+		}
+	}
+	return host
+}
+
+func (cfg *apiConfig) isTrustedProxy(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range cfg.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
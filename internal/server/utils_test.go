@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{trustedProxies: proxies}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{"direct", "203.0.113.5:1234", nil, "", "203.0.113.5"},
+		{"untrusted peer's headers are ignored", "203.0.113.5:1234", []string{"198.51.100.7"}, "198.51.100.8", "203.0.113.5"},
+		{"trusted proxy", "10.1.2.3:1234", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"spoofed entries before the proxy's are ignored", "10.1.2.3:1234", []string{"1.1.1.1, 198.51.100.7"}, "", "198.51.100.7"},
+		{"chain of trusted proxies", "192.0.2.1:1234", []string{"198.51.100.7, 10.0.0.9", "10.0.0.8"}, "", "198.51.100.7"},
+		{"X-Real-IP", "10.1.2.3:1234", nil, "198.51.100.8", "198.51.100.8"},
+		{"garbage stops the walk", "10.1.2.3:1234", []string{"198.51.100.7, junk, 10.0.0.9"}, "", "10.0.0.9"},
+		{"only proxies", "10.1.2.3:1234", []string{""}, "", "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		for _, value := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := cfg.clientIP(r); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := parseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("parsed an invalid CIDR range")
+	}
+}
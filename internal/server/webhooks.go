@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/andycostintoma/tubely/internal/webhook"
+	"github.com/google/uuid"
+)
+
+const (
+	webhookTimeout   = 10 * time.Second
+	webhookBatchSize = 20
+	// webhookLease is how long a claimed delivery is held before another
+	// pass may send it again, in case this one never records the outcome.
+	// A batch is sent one delivery at a time, so the lease outlasts a batch
+	// of deliveries that all time out.
+	webhookLease = webhookBatchSize*webhookTimeout + time.Minute
+
+	// Failed attempts are retried after 30s, 1m, 2m and so on, giving up
+	// after webhookMaxAttempts, a little over an hour after the first.
+	webhookRetryBase    = 30 * time.Second
+	webhookRetryMax     = 6 * time.Hour
+	webhookMaxAttempts  = 8
+	webhookMaxErrorSize = 512
+)
+
+// runWebhookDispatcher sends deliveries from the outbox. It checks for due
+// retries on every tick and is woken early when new events are queued.
+func (cfg *apiConfig) runWebhookDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(cfg.webhookDispatchEvery)
+	defer ticker.Stop()
+
+	for {
+		cfg.dispatchWebhooks(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cfg.webhookWake:
+		}
+	}
+}
+
+// wakeWebhookDispatcher asks the dispatcher to run a pass now. It never
+// blocks; if a pass is already pending, that one picks up the new work.
+func (cfg *apiConfig) wakeWebhookDispatcher() {
+	select {
+	case cfg.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+func (cfg *apiConfig) dispatchWebhooks(ctx context.Context) {
+	webhooks := map[uuid.UUID]database.Webhook{}
+	for {
+		deliveries, err := cfg.db.ClaimDueWebhookDeliveries(time.Now(), webhookLease, webhookBatchSize)
+		if err != nil {
+			log.Printf("Couldn't claim webhook deliveries: %v", err)
+			return
+		}
+		if len(deliveries) == 0 {
+			return
+		}
+
+		for _, delivery := range deliveries {
+			wh, ok := webhooks[delivery.WebhookID]
+			if !ok {
+				wh, err = cfg.db.GetWebhook(delivery.WebhookID)
+				if err != nil {
+					log.Printf("Couldn't get webhook %v: %v", delivery.WebhookID, err)
+					continue
+				}
+				webhooks[delivery.WebhookID] = wh
+			}
+			if wh.ID == uuid.Nil {
+				continue
+			}
+			cfg.deliverWebhook(ctx, wh, delivery)
+		}
+	}
+}
+
+// deliverWebhook makes one attempt at a delivery and records the outcome,
+// scheduling a retry if it failed and attempts remain.
+func (cfg *apiConfig) deliverWebhook(ctx context.Context, wh database.Webhook, delivery database.WebhookDelivery) {
+	resp, err := cfg.webhookClient.Send(ctx, webhook.Request{
+		URL:        wh.URL,
+		Secret:     wh.Secret,
+		Event:      delivery.Event,
+		DeliveryID: delivery.ID.String(),
+		Body:       []byte(delivery.Payload),
+	})
+
+	attempt := database.WebhookAttempt{At: time.Now()}
+	if err != nil {
+		attempt.Error = truncate(err.Error(), webhookMaxErrorSize)
+	} else {
+		attempt.ResponseStatus = &resp.StatusCode
+		attempt.ResponseBody = resp.Body
+	}
+	succeeded := err == nil && resp.OK()
+
+	var retryAt *time.Time
+	attempts := delivery.Attempts + 1
+	if !succeeded && attempts < webhookMaxAttempts {
+		t := attempt.At.Add(webhookBackoff(attempts))
+		retryAt = &t
+	}
+
+	err = cfg.db.RecordWebhookAttempt(delivery.ID, attempt, succeeded, retryAt)
+	if err != nil {
+		log.Printf("Couldn't record webhook delivery %v: %v", delivery.ID, err)
+		return
+	}
+	if !succeeded && retryAt == nil {
+		log.Printf("Giving up on webhook delivery %v to %s after %d attempts", delivery.ID, wh.URL, attempts)
+	}
+}
+
+// webhookBackoff is how long to wait after the given number of failed
+// attempts.
+func webhookBackoff(attempts int) time.Duration {
+	d := webhookRetryBase
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= webhookRetryMax {
+			return webhookRetryMax
+		}
+	}
+	return d
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andycostintoma/tubely/internal/database"
+	"github.com/andycostintoma/tubely/internal/webhook/webhooktest"
+	"github.com/google/uuid"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// newTestWebhook registers a webhook for a new user that delivers to a
+// webhooktest receiver.
+func newTestWebhook(t *testing.T, cfg *apiConfig) (database.Webhook, *webhooktest.Receiver) {
+	t.Helper()
+	receiver, srv := webhooktest.NewServer(testWebhookSecret)
+	t.Cleanup(srv.Close)
+
+	user := signUp(t, cfg, "hooks@example.com", true)
+	wh, err := cfg.db.CreateWebhook(database.CreateWebhookParams{
+		UserID: user.ID,
+		URL:    srv.URL,
+		Events: webhookEvents,
+		Secret: testWebhookSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wh, receiver
+}
+
+func getDelivery(t *testing.T, cfg *apiConfig, id uuid.UUID) database.WebhookDelivery {
+	t.Helper()
+	delivery, err := cfg.db.GetWebhookDelivery(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+// dispatchAt sends whatever is due at now, as the dispatcher would once
+// that time came.
+func dispatchAt(t *testing.T, cfg *apiConfig, now time.Time) int {
+	t.Helper()
+	deliveries, err := cfg.db.ClaimDueWebhookDeliveries(now, webhookLease, webhookBatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, delivery := range deliveries {
+		wh, err := cfg.db.GetWebhook(delivery.WebhookID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.deliverWebhook(context.Background(), wh, delivery)
+	}
+	return len(deliveries)
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, webhookRetryMax},
+		{100, webhookRetryMax},
+	}
+	for _, tt := range tests {
+		if got := webhookBackoff(tt.attempts); got != tt.want {
+			t.Errorf("webhookBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// A claimed batch has to be sent before its lease runs out, even if every
+// delivery in it times out, or another pass would send them again.
+func TestWebhookLease(t *testing.T) {
+	if worst := webhookBatchSize * webhookTimeout; webhookLease <= worst {
+		t.Errorf("webhookLease is %v, want more than the %v a batch can take", webhookLease, worst)
+	}
+}
+
+func TestDispatchWebhooks(t *testing.T) {
+	cfg := newTestConfig(t)
+	wh, receiver := newTestWebhook(t, cfg)
+
+	deliveries, err := cfg.enqueueEvent(eventPing, map[string]any{"webhook_id": wh.ID}, wh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.dispatchWebhooks(context.Background())
+
+	received := receiver.Deliveries()
+	if len(received) != 1 {
+		t.Fatalf("receiver got %d deliveries, want 1", len(received))
+	}
+	if received[0].ID != deliveries[0].ID.String() || received[0].Event != eventPing {
+		t.Errorf("receiver got %+v, want delivery %v of %s", received[0], deliveries[0].ID, eventPing)
+	}
+	var payload eventPayload
+	if err := json.Unmarshal(received[0].Body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.ID != deliveries[0].EventID || payload.Type != eventPing {
+		t.Errorf("got payload %+v", payload)
+	}
+
+	delivery := getDelivery(t, cfg, deliveries[0].ID)
+	if delivery.Status != database.WebhookDeliverySucceeded || delivery.Attempts != 1 || delivery.DeliveredAt == nil {
+		t.Errorf("got %+v, want one successful attempt", delivery)
+	}
+
+	// Nothing is left to send.
+	cfg.dispatchWebhooks(context.Background())
+	if n := len(receiver.Deliveries()); n != 1 {
+		t.Errorf("receiver got %d deliveries after a second pass, want 1", n)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	cfg := newTestConfig(t)
+	wh, receiver := newTestWebhook(t, cfg)
+	receiver.FailNext(1)
+
+	deliveries, err := cfg.enqueueEvent(eventPing, nil, wh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	cfg.dispatchWebhooks(context.Background())
+
+	delivery := getDelivery(t, cfg, deliveries[0].ID)
+	if delivery.Status != database.WebhookDeliveryPending || delivery.Attempts != 1 {
+		t.Fatalf("got status %s after %d attempts, want a pending retry", delivery.Status, delivery.Attempts)
+	}
+	if delivery.ResponseStatus == nil || *delivery.ResponseStatus != http.StatusInternalServerError {
+		t.Errorf("recorded response %v, want the receiver's 500", delivery.ResponseStatus)
+	}
+	if delivery.NextAttemptAt == nil {
+		t.Fatal("no retry was scheduled")
+	}
+	wait := delivery.NextAttemptAt.Sub(start)
+	if wait < webhookBackoff(1)-time.Second || wait > webhookBackoff(1)+5*time.Second {
+		t.Errorf("retry scheduled in %v, want %v", wait, webhookBackoff(1))
+	}
+
+	// The retry isn't sent early...
+	if n := dispatchAt(t, cfg, time.Now()); n != 0 {
+		t.Errorf("%d deliveries were sent before the retry was due", n)
+	}
+	// ...but is once it's due, with the same delivery and event.
+	if n := dispatchAt(t, cfg, *delivery.NextAttemptAt); n != 1 {
+		t.Fatalf("%d deliveries were sent when the retry was due, want 1", n)
+	}
+	delivery = getDelivery(t, cfg, delivery.ID)
+	if delivery.Status != database.WebhookDeliverySucceeded || delivery.Attempts != 2 {
+		t.Errorf("got status %s after %d attempts, want success on the second", delivery.Status, delivery.Attempts)
+	}
+	received := receiver.Deliveries()
+	if len(received) != 1 || received[0].ID != delivery.ID.String() {
+		t.Errorf("receiver got %+v, want the retried delivery", received)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	cfg := newTestConfig(t)
+	wh, receiver := newTestWebhook(t, cfg)
+	receiver.FailNext(webhookMaxAttempts + 1)
+
+	deliveries, err := cfg.enqueueEvent(eventPing, nil, wh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if n := dispatchAt(t, cfg, now); n != 1 {
+			t.Fatalf("attempt %d: %d deliveries were sent, want 1", attempt, n)
+		}
+		delivery := getDelivery(t, cfg, deliveries[0].ID)
+		if delivery.Attempts != attempt {
+			t.Fatalf("recorded %d attempts, want %d", delivery.Attempts, attempt)
+		}
+		if attempt < webhookMaxAttempts {
+			if delivery.Status != database.WebhookDeliveryPending || delivery.NextAttemptAt == nil {
+				t.Fatalf("attempt %d: got status %s, want a scheduled retry", attempt, delivery.Status)
+			}
+			now = *delivery.NextAttemptAt
+		}
+	}
+
+	delivery := getDelivery(t, cfg, deliveries[0].ID)
+	if delivery.Status != database.WebhookDeliveryFailed || delivery.NextAttemptAt != nil {
+		t.Errorf("got status %s, next attempt %v; want failed with nothing scheduled", delivery.Status, delivery.NextAttemptAt)
+	}
+	if n := dispatchAt(t, cfg, now.Add(webhookRetryMax)); n != 0 {
+		t.Errorf("%d deliveries were sent after giving up", n)
+	}
+	if len(receiver.Deliveries()) != 0 {
+		t.Error("receiver accepted a delivery")
+	}
+}
+
+func TestWebhookRedeliver(t *testing.T) {
+	cfg := newTestConfig(t)
+	wh, receiver := newTestWebhook(t, cfg)
+	srv, client := newTestServer(t, cfg)
+	token := logIn(t, client, srv, "hooks@example.com")
+
+	deliveries, err := cfg.enqueueEvent(eventPing, nil, wh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.dispatchWebhooks(context.Background())
+	original := deliveries[0]
+
+	var redelivery database.WebhookDelivery
+	url := srv.URL + "/api/webhooks/" + wh.ID.String() + "/deliveries/" + original.ID.String() + "/redeliver"
+	if status := doJSON(t, client, http.MethodPost, url, token, nil, &redelivery); status != http.StatusAccepted {
+		t.Fatalf("redeliver returned %d, want %d", status, http.StatusAccepted)
+	}
+	if redelivery.ID == original.ID || redelivery.RedeliveryOf == nil || *redelivery.RedeliveryOf != original.ID {
+		t.Errorf("got %+v, want a new delivery of %v", redelivery, original.ID)
+	}
+	if redelivery.EventID != original.EventID || redelivery.Payload != original.Payload {
+		t.Errorf("redelivery carries event %v, want the original %v", redelivery.EventID, original.EventID)
+	}
+
+	cfg.dispatchWebhooks(context.Background())
+	received := receiver.Deliveries()
+	if len(received) != 2 {
+		t.Fatalf("receiver got %d deliveries, want 2", len(received))
+	}
+	if received[1].ID != redelivery.ID.String() || string(received[1].Body) != string(received[0].Body) {
+		t.Errorf("redelivered %+v, want the original body under delivery %v", received[1], redelivery.ID)
+	}
+
+	// Other users can't see or resend the delivery.
+	signUp(t, cfg, "other@example.com", true)
+	otherClient := newTestClient(t, srv)
+	otherToken := logIn(t, otherClient, srv, "other@example.com")
+	if status := doJSON(t, otherClient, http.MethodPost, url, otherToken, nil, nil); status != http.StatusNotFound {
+		t.Errorf("another user's redeliver returned %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestEmitVideoEvent(t *testing.T) {
+	cfg := newTestConfig(t)
+	wh, receiver := newTestWebhook(t, cfg)
+	_, err := cfg.db.CreateWebhook(database.CreateWebhookParams{
+		UserID: wh.UserID,
+		URL:    wh.URL,
+		Events: []string{eventVideoDeleted},
+		Secret: testWebhookSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Video", UserID: wh.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoURL := "tubely-test,landscape/video.mp4"
+	video.VideoURL = &videoURL
+
+	// Only webhooks subscribed to the event get it.
+	cfg.emitVideoEvent(eventVideoCreated, video)
+	cfg.dispatchWebhooks(context.Background())
+	received := receiver.Deliveries()
+	if len(received) != 1 || received[0].Event != eventVideoCreated {
+		t.Fatalf("receiver got %+v, want one %s", received, eventVideoCreated)
+	}
+
+	// The payload names the video but carries no link to its file, which
+	// would expire before retries ran out.
+	var payload struct {
+		Data struct {
+			Video database.Video `json:"video"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(received[0].Body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Data.Video.ID != video.ID || payload.Data.Video.VideoURL != nil {
+		t.Errorf("payload carries video %v with URL %v, want %v without one", payload.Data.Video.ID, payload.Data.Video.VideoURL, video.ID)
+	}
+}
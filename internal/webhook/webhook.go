@@ -0,0 +1,176 @@
+// Package webhook signs and sends the HTTP callbacks Tubely makes to
+// endpoints its users register, and lets receivers verify them.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	SignatureHeader = "Tubely-Signature"
+	EventHeader     = "Tubely-Event"
+	DeliveryHeader  = "Tubely-Delivery"
+
+	// maxResponseBytes bounds how much of a receiver's response is kept
+	// for the delivery log.
+	maxResponseBytes = 1024
+)
+
+var (
+	ErrInvalidSignature   = errors.New("webhook signature doesn't match")
+	ErrSignatureExpired   = errors.New("webhook signature is too old")
+	ErrPrivateDestination = errors.New("webhook destination is on a private network")
+)
+
+// Sign returns the signature header for body sent at t. The HMAC-SHA256
+// covers the timestamp as well as the body, so a captured request can't be
+// replayed later with a fresh timestamp.
+func Sign(secret string, t time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(mac(secret, t.Unix(), body)))
+}
+
+// Verify checks a signature header made by Sign, rejecting signatures
+// older than tolerance.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp int64
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			timestamp = t
+		case "v1":
+			signature, err := hex.DecodeString(value)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			signatures = append(signatures, signature)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+	if now.Sub(time.Unix(timestamp, 0)) > tolerance {
+		return ErrSignatureExpired
+	}
+
+	expected := mac(secret, timestamp, body)
+	for _, signature := range signatures {
+		if hmac.Equal(signature, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func mac(secret string, timestamp int64, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(h, "%d.", timestamp)
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Request is a single delivery of an event to an endpoint.
+type Request struct {
+	URL        string
+	Secret     string
+	Event      string
+	DeliveryID string
+	Body       []byte
+}
+
+// Response is what the endpoint answered.
+type Response struct {
+	StatusCode int
+	Body       string
+}
+
+// OK reports whether the endpoint accepted the delivery.
+func (r Response) OK() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// Client sends deliveries. Unless AllowPrivateNetworks is set, it refuses
+// to connect to loopback, private and link-local addresses, so endpoints
+// can't be pointed at services inside our own network.
+type Client struct {
+	httpClient *http.Client
+}
+
+func NewClient(timeout time.Duration, allowPrivateNetworks bool) *Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivateNetworks {
+		dialer.Control = rejectPrivateAddresses
+	}
+	return &Client{
+		httpClient: &http.Client{
+			Timeout: timeout,
+			// No proxy: the dialer would then only check the proxy's address,
+			// and the proxy could connect anywhere for us.
+			Transport: &http.Transport{
+				DialContext: dialer.DialContext,
+			},
+			// Following redirects would let an endpoint send us somewhere
+			// it couldn't have been registered for.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Send posts the delivery. An error means no response was received; any
+// response, including a failing one, is returned without an error.
+func (c *Client) Send(ctx context.Context, req Request) (Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		return Response{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", "Tubely-Webhooks/1.0")
+	httpReq.Header.Set(EventHeader, req.Event)
+	httpReq.Header.Set(DeliveryHeader, req.DeliveryID)
+	httpReq.Header.Set(SignatureHeader, Sign(req.Secret, time.Now(), req.Body))
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return Response{}, err
+	}
+	return Response{StatusCode: resp.StatusCode, Body: string(body)}, nil
+}
+
+func rejectPrivateAddresses(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return ErrPrivateDestination
+	}
+	return nil
+}
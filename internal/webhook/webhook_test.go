@@ -0,0 +1,123 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andycostintoma/tubely/internal/webhook"
+	"github.com/andycostintoma/tubely/internal/webhook/webhooktest"
+)
+
+const (
+	secret    = "whsec_test"
+	tolerance = 5 * time.Minute
+)
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"1","type":"ping"}`)
+	now := time.Now()
+	header := webhook.Sign(secret, now, body)
+
+	if err := webhook.Verify(secret, header, body, tolerance, now); err != nil {
+		t.Fatalf("valid signature was rejected: %v", err)
+	}
+
+	// While a secret is being rotated, a header may carry a signature per
+	// secret; any one of them matching is enough.
+	other := webhook.Sign("whsec_old", now, body)
+	rotated := other + "," + strings.Split(header, ",")[1]
+	if err := webhook.Verify(secret, rotated, body, tolerance, now); err != nil {
+		t.Errorf("header with several signatures was rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   string
+		now    time.Time
+		want   error
+	}{
+		{"tampered body", secret, header, `{"id":"2","type":"ping"}`, now, webhook.ErrInvalidSignature},
+		{"wrong secret", "whsec_other", header, string(body), now, webhook.ErrInvalidSignature},
+		{"expired", secret, header, string(body), now.Add(tolerance + time.Second), webhook.ErrSignatureExpired},
+		{"replayed with a new timestamp", secret, fmt.Sprintf("t=%d,%s", now.Add(time.Hour).Unix(), strings.Split(header, ",")[1]), string(body), now.Add(time.Hour), webhook.ErrInvalidSignature},
+		{"missing", secret, "", string(body), now, webhook.ErrInvalidSignature},
+		{"no timestamp", secret, strings.Split(header, ",")[1], string(body), now, webhook.ErrInvalidSignature},
+		{"malformed", secret, "t=abc,v1=zz", string(body), now, webhook.ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := webhook.Verify(tt.secret, tt.header, []byte(tt.body), tolerance, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	receiver, srv := webhooktest.NewServer(secret)
+	defer srv.Close()
+	client := webhook.NewClient(time.Second, true)
+
+	req := webhook.Request{
+		URL:        srv.URL,
+		Secret:     secret,
+		Event:      "ping",
+		DeliveryID: "delivery-1",
+		Body:       []byte(`{"type":"ping"}`),
+	}
+	resp, err := client.Send(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.OK() {
+		t.Fatalf("receiver answered %d: %s", resp.StatusCode, resp.Body)
+	}
+	deliveries := receiver.Deliveries()
+	if len(deliveries) != 1 || deliveries[0].ID != "delivery-1" || deliveries[0].Event != "ping" {
+		t.Fatalf("receiver got %+v", deliveries)
+	}
+
+	// A failing endpoint is a response, not an error, so it can be logged.
+	receiver.FailNext(1)
+	resp, err = client.Send(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.OK() || resp.StatusCode != 500 {
+		t.Errorf("got %d, want the receiver's 500", resp.StatusCode)
+	}
+
+	req.Secret = "whsec_wrong"
+	resp, err = client.Send(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 401 {
+		t.Errorf("receiver accepted a delivery signed with the wrong secret: %d", resp.StatusCode)
+	}
+}
+
+func TestSendRejectsPrivateNetworks(t *testing.T) {
+	receiver, srv := webhooktest.NewServer(secret)
+	defer srv.Close()
+
+	client := webhook.NewClient(time.Second, false)
+	_, err := client.Send(context.Background(), webhook.Request{
+		URL:    srv.URL,
+		Secret: secret,
+		Body:   []byte(`{}`),
+	})
+	if !errors.Is(err, webhook.ErrPrivateDestination) {
+		t.Errorf("got %v, want %v", err, webhook.ErrPrivateDestination)
+	}
+	if len(receiver.Deliveries()) != 0 {
+		t.Error("delivery reached a loopback address")
+	}
+}
@@ -0,0 +1,108 @@
+// Package webhooktest provides a webhook endpoint that checks signatures
+// and remembers what it received, for exercising deliveries offline.
+package webhooktest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/andycostintoma/tubely/internal/webhook"
+)
+
+// signatureTolerance is how old a delivery's signature may be.
+const signatureTolerance = 5 * time.Minute
+
+// Delivery is a request the receiver accepted.
+type Delivery struct {
+	ID         string          `json:"id"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+	ReceivedAt time.Time       `json:"received_at"`
+}
+
+// Receiver is a webhook endpoint. Requests with a missing or wrong
+// signature are answered with 401; the next few can be made to fail with
+// a 500 to exercise retries.
+type Receiver struct {
+	Secret string
+
+	mu         sync.Mutex
+	deliveries []Delivery
+	failNext   int
+	onDelivery func(Delivery)
+}
+
+func New(secret string) *Receiver {
+	return &Receiver{Secret: secret}
+}
+
+// NewServer starts a receiver on a local port. Callers must Close the
+// returned server.
+func NewServer(secret string) (*Receiver, *httptest.Server) {
+	receiver := New(secret)
+	return receiver, httptest.NewServer(receiver)
+}
+
+// FailNext makes the next n signed deliveries fail.
+func (rc *Receiver) FailNext(n int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.failNext = n
+}
+
+// OnDelivery registers a function called with each accepted delivery.
+func (rc *Receiver) OnDelivery(f func(Delivery)) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.onDelivery = f
+}
+
+// Deliveries returns what has been accepted so far, oldest first.
+func (rc *Receiver) Deliveries() []Delivery {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]Delivery(nil), rc.deliveries...)
+}
+
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "couldn't read body", http.StatusBadRequest)
+		return
+	}
+	err = webhook.Verify(rc.Secret, r.Header.Get(webhook.SignatureHeader), body, signatureTolerance, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	rc.mu.Lock()
+	if rc.failNext > 0 {
+		rc.failNext--
+		rc.mu.Unlock()
+		http.Error(w, "failing on purpose", http.StatusInternalServerError)
+		return
+	}
+	delivery := Delivery{
+		ID:         r.Header.Get(webhook.DeliveryHeader),
+		Event:      r.Header.Get(webhook.EventHeader),
+		Body:       body,
+		ReceivedAt: time.Now(),
+	}
+	rc.deliveries = append(rc.deliveries, delivery)
+	onDelivery := rc.onDelivery
+	rc.mu.Unlock()
+
+	if onDelivery != nil {
+		onDelivery(delivery)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}